@@ -1,6 +1,8 @@
 package influx
 
 import (
+	"context"
+	"fmt"
 	"net/http"
 	"time"
 
@@ -21,26 +23,113 @@ type API struct {
 	client              remotewrite.Client
 	recorder            Recorder
 	maxRequestSizeBytes int
+	maxWireSizeBytes    int
+	maxCompressionRatio float64
+	maxLineLengthBytes  int
+	writeBatchSize      int
+	converters          *converters
+	v1                  V1Config
+	v2                  V2Config
+	v3                  V3Config
+	influxVersion       string
+}
+
+// probePaths are the paths of the health checks and of the handshakes of
+// Influx clients, such as the influx CLI or the data source test of Grafana,
+// which don't send X-Scope-OrgID and never need a tenant.
+var probePaths = map[string]bool{
+	"/healthz":      true,
+	"/ping":         true,
+	"/api/v2/ping":  true,
+	"/health":       true,
+	"/api/v2/setup": true,
 }
 
 func (a *API) Register(router *mux.Router) {
 	registerer := route.NewMuxRegisterer(router)
 
 	// Registering two write endpoints; the second is necessary to allow for compatibility with clients that hard-code the endpoint
-	registerer.RegisterRoute("/api/v1/push/influx/write", http.HandlerFunc(a.handleSeriesPush), http.MethodPost)
-	registerer.RegisterRoute("/api/v2/write", http.HandlerFunc(a.handleSeriesPush), http.MethodPost)
+	registerer.RegisterRoute("/api/v1/push/influx/write", a.withVersionHeaders(http.HandlerFunc(a.handleSeriesPush)), http.MethodPost)
+	registerer.RegisterRoute("/api/v2/write", a.withVersionHeaders(http.HandlerFunc(a.handleV2Write)), http.MethodPost)
+	registerer.RegisterRoute("/write", a.withVersionHeaders(http.HandlerFunc(a.handleV1Write)), http.MethodPost)
+	registerer.RegisterRoute("/api/v3/write_lp", a.withVersionHeaders(http.HandlerFunc(a.handleV3Write)), http.MethodPost)
+	registerer.RegisterRoute("/api/put", http.HandlerFunc(a.handleOpenTSDBPut), http.MethodPost)
 	registerer.RegisterRoute("/healthz", http.HandlerFunc(a.handleHealth), http.MethodGet)
+
+	// Handshake endpoints called by Influx clients before they start writing
+	registerer.RegisterRoute("/ping", a.withVersionHeaders(http.HandlerFunc(a.handlePing)), http.MethodGet, http.MethodHead)
+	registerer.RegisterRoute("/api/v2/ping", a.withVersionHeaders(http.HandlerFunc(a.handlePing)), http.MethodGet, http.MethodHead)
+	registerer.RegisterRoute("/health", a.withVersionHeaders(http.HandlerFunc(a.handleInfluxHealth)), http.MethodGet)
+	registerer.RegisterRoute("/api/v2/setup", a.withVersionHeaders(http.HandlerFunc(a.handleSetup)), http.MethodGet)
 }
 
 func NewAPI(conf ProxyConfig, client remotewrite.Client, recorder Recorder) (*API, error) {
+	if err := conf.V1Config.Validate(); err != nil {
+		return nil, fmt.Errorf("invalid v1 config: %w", err)
+	}
+	if err := conf.V2Config.Validate(); err != nil {
+		return nil, fmt.Errorf("invalid v2 config: %w", err)
+	}
+	if err := conf.V3Config.Validate(); err != nil {
+		return nil, fmt.Errorf("invalid v3 config: %w", err)
+	}
+	if err := conf.ConversionConfig.Validate(); err != nil {
+		return nil, fmt.Errorf("invalid conversion config: %w", err)
+	}
+	// The tenant mapped from a query parameter would replace the
+	// authenticated one, letting clients write to any tenant.
+	if conf.EnableAuth && mapsTenant(conf.V1Config.DB, conf.V1Config.RP, conf.V2Config.Org, conf.V2Config.Bucket, conf.V3Config.DB) {
+		return nil, fmt.Errorf("query parameters can't be mapped to the tenant when authentication is enabled")
+	}
+
+	influxVersion := conf.InfluxVersion
+	if influxVersion == "" {
+		influxVersion = DefaultInfluxVersion
+	}
+
+	writeBatchSize := conf.WriteBatchSize
+	if writeBatchSize <= 0 {
+		writeBatchSize = DefaultWriteBatchSize
+	}
+
+	var overrides Overrides
+	if conf.OverridesFile != "" {
+		var err error
+		if overrides, err = LoadOverrides(conf.OverridesFile); err != nil {
+			return nil, err
+		}
+	}
+	converters, err := newConverters(conf.ConversionConfig, overrides, conf.Logger, recorder)
+	if err != nil {
+		return nil, fmt.Errorf("invalid overrides: %w", err)
+	}
+
 	return &API{
 		logger:              conf.Logger,
 		client:              client,
 		recorder:            recorder,
 		maxRequestSizeBytes: conf.MaxRequestSizeBytes,
+		maxWireSizeBytes:    conf.MaxWireRequestSizeBytes,
+		maxCompressionRatio: conf.MaxCompressionRatio,
+		maxLineLengthBytes:  conf.MaxLineLengthBytes,
+		writeBatchSize:      writeBatchSize,
+		converters:          converters,
+		v1:                  conf.V1Config,
+		v2:                  conf.V2Config,
+		v3:                  conf.V3Config,
+		influxVersion:       influxVersion,
 	}, nil
 }
 
+// bodyLimits returns the limits of the body of the write requests.
+func (a *API) bodyLimits() bodyLimits {
+	return bodyLimits{
+		maxWireBytes:         int64(a.maxWireSizeBytes),
+		maxDecompressedBytes: int64(a.maxRequestSizeBytes),
+		maxCompressionRatio:  a.maxCompressionRatio,
+	}
+}
+
 func (a *API) handleHealth(w http.ResponseWriter, r *http.Request) {
 	span, _ := opentracing.StartSpanFromContext(r.Context(), "handleHealth")
 	defer span.Finish()
@@ -57,51 +146,108 @@ func (a *API) handleHealth(w http.ResponseWriter, r *http.Request) {
 	w.WriteHeader(http.StatusOK)
 }
 
-// HandlerForInfluxLine is a http.Handler which accepts Influx Line protocol and converts it to WriteRequests.
+// handleSeriesPush is a http.Handler which accepts Influx Line protocol and converts it to WriteRequests.
 func (a *API) handleSeriesPush(w http.ResponseWriter, r *http.Request) {
+	a.handleWrite(w, r, a.parsePushWriteParams, a.handleError)
+}
+
+// parsePushWriteParams returns the params of a write to
+// /api/v1/push/influx/write, which only takes the precision parameter.
+func (a *API) parsePushWriteParams(r *http.Request) (context.Context, writeParams, error) {
+	return r.Context(), writeParams{endpoint: endpointPush, precision: r.URL.Query().Get("precision")}, nil
+}
+
+// handleWrite converts the Influx Line protocol body of r to a WriteRequest
+// and writes it, using the params returned by parseParams, the parser of the
+// endpoint that received it. Errors, including invalid lines, are responded to
+// with handleError, which gives the body of the error responses of the
+// endpoint.
+func (a *API) handleWrite(w http.ResponseWriter, r *http.Request, parseParams func(*http.Request) (context.Context, writeParams, error), handleError func(http.ResponseWriter, *http.Request, error, log.Logger)) {
 	span, ctx := opentracing.StartSpanFromContext(r.Context(), "handleSeriesPush")
 	defer span.Finish()
 
 	logger := withRequestInfo(a.logger, r)
+	ctx, params, err := parseParams(r.WithContext(ctx))
+	if err != nil {
+		ext.LogError(span, err)
+		handleError(w, r, err, logger)
+		return
+	}
+
 	beforeConversion := time.Now()
+	tenant, _ := user.ExtractOrgID(ctx)
+	params.converter = a.converters.get(params.endpoint, tenant)
 
-	ts, bytesRead, err := parseInfluxLineReader(ctx, r, a.maxRequestSizeBytes)
+	// Series are written in batches as the body is parsed.
+	var nosMetrics, nosMetricsWritten int
+	var writeDuration time.Duration
+	bytesRead, lineErrs, err := parseInfluxLineReader(ctx, r, params, a.bodyLimits(), a.maxLineLengthBytes, a.writeBatchSize, func(ts []mimirpb.TimeSeries) error {
+		nosMetrics += len(ts)
+		beforeWrite := time.Now()
+		defer func() { writeDuration += time.Since(beforeWrite) }()
+		if err := a.write(ctx, ts, params.converter); err != nil {
+			return err
+		}
+		nosMetricsWritten += len(ts)
+		return nil
+	})
 	span.LogKV("bytesRead", bytesRead)
 	logger = log.With(logger, "bytesRead", bytesRead)
+	if nosMetrics > 0 || err == nil {
+		logger = log.With(logger, "nosMetrics", nosMetrics)
+		span.LogKV("nosMetrics", nosMetrics)
+		a.recorder.measureMetricsParsed(nosMetrics)
+		a.recorder.measureConversionDuration(time.Since(beforeConversion) - writeDuration)
+	}
+	a.measureLineErrors(lineErrs)
 	if err != nil {
+		if nosMetricsWritten > 0 {
+			err = partialWriteError{written: nosMetricsWritten, err: err}
+		}
 		ext.LogError(span, err)
-		a.handleError(w, r, err, logger)
+		handleError(w, r, err, logger)
 		return
 	}
 
-	nosMetrics := len(ts)
-	logger = log.With(logger, "nosMetrics", nosMetrics)
-	span.LogKV("nosMetrics", nosMetrics)
+	span.LogKV("nosMetricsWritten", nosMetricsWritten)
+	if lineErrs.count() > 0 {
+		handleError(w, r, invalidLinesError{lineErrs: lineErrs, rejected: params.allOrNothing}, logger)
+		return
+	}
+	statusCode := http.StatusNoContent
+	_ = level.Info(logger).Log("response_code", statusCode)
+	w.WriteHeader(statusCode) // Needed for Telegraf, otherwise it tries to marshal JSON and considers the write a failure.
+}
 
-	a.recorder.measureMetricsParsed(nosMetrics)
-	a.recorder.measureConversionDuration(time.Since(beforeConversion))
+// write sends the given series to the remote write endpoint, along with the
+// metadata due to be sent of the converter that converted them.
+func (a *API) write(ctx context.Context, ts []mimirpb.TimeSeries, conv *converter) error {
+	tenant, _ := user.ExtractOrgID(ctx)
+	ts = conv.validateSamples(tenant, ts, time.Now())
+	ts = conv.relabel(tenant, conv.assembleHistograms(ts))
+	rwReq := newWriteRequest(ts, conv.metadataDue(tenant, ts))
+	if err := a.client.Write(ctx, rwReq); err != nil {
+		return err
+	}
+	a.recorder.measureMetricsWritten(len(rwReq.Timeseries))
+	return nil
+}
 
+// newWriteRequest wraps the given series and metadata in a WriteRequest,
+// merging the samples of the same series.
+func newWriteRequest(ts []mimirpb.TimeSeries, metadata []*mimirpb.MetricMetadata) *mimirpb.WriteRequest {
+	ts = mergeSeries(ts)
 	// Sigh, a write API optimisation needs me to jump through hoops.
-	pts := make([]mimirpb.PreallocTimeseries, 0, nosMetrics)
+	pts := make([]mimirpb.PreallocTimeseries, 0, len(ts))
 	for i := range ts {
 		pts = append(pts, mimirpb.PreallocTimeseries{
 			TimeSeries: &ts[i],
 		})
 	}
-	rwReq := &mimirpb.WriteRequest{
+	return &mimirpb.WriteRequest{
 		Timeseries: pts,
+		Metadata:   metadata,
 	}
-
-	if err := a.client.Write(ctx, rwReq); err != nil {
-		ext.LogError(span, err)
-		a.handleError(w, r, err, logger)
-		return
-	}
-	a.recorder.measureMetricsWritten(len(rwReq.Timeseries))
-	span.LogKV("nosMetricsWritten", len(rwReq.Timeseries))
-	statusCode := http.StatusNoContent
-	_ = level.Info(logger).Log("response_code", statusCode)
-	w.WriteHeader(statusCode) // Needed for Telegraf, otherwise it tries to marshal JSON and considers the write a failure.
 }
 
 func withRequestInfo(logger log.Logger, r *http.Request) log.Logger {
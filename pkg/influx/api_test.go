@@ -102,20 +102,17 @@ func TestHandleSeriesPush(t *testing.T) {
 			expectedCode: http.StatusBadRequest,
 			expectJsonBody: `{
 				"code": "invalid",
-				"message": "error parsing points"
+				"message": "partial write: unable to parse 'measurement,t1=v1 f1= 1465839830100400200': missing field value (line 1) dropped=1",
+				"line": 1
 			}`,
 			remoteWriteMock: func() *remotewritemock.Client {
-				remoteWriteMock := &remotewritemock.Client{}
-				remoteWriteMock.On("Write", mock.Anything, mock.Anything).
-					Return(errorx.BadRequest{})
-				return remoteWriteMock
+				return &remotewritemock.Client{}
 			},
 			recorderMock: func() *MockRecorder {
 				recorderMock := &MockRecorder{}
 				recorderMock.On("measureMetricsParsed", 0).Return(nil)
-				recorderMock.On("measureMetricsWritten", 0).Return(nil)
-				recorderMock.On("measureProxyErrors", "errorx.BadRequest").Return(nil)
-				recorderMock.On("measureConversionDuration", 0).Return(nil)
+				recorderMock.On("measureFailedLines", "parse_error", 1).Return(nil)
+				recorderMock.On("measureConversionDuration", mock.Anything).Return(nil)
 				return recorderMock
 			},
 			maxRequestSizeBytes: DefaultMaxRequestSizeBytes,
@@ -174,10 +171,10 @@ func TestHandleSeriesPush(t *testing.T) {
 			name:         "max batch size violated",
 			url:          "/write",
 			data:         "measurement,t1=v1 f1=2 0123456789",
-			expectedCode: http.StatusBadRequest,
+			expectedCode: http.StatusRequestEntityTooLarge,
 			expectJsonBody: `{
-				"code": "invalid",
-				"message": "problem reading body"
+				"code": "request too large",
+				"message": "decompressed request body is larger than 8 bytes"
 			}`,
 			remoteWriteMock: func() *remotewritemock.Client {
 				return &remotewritemock.Client{}
@@ -186,7 +183,8 @@ func TestHandleSeriesPush(t *testing.T) {
 				recorderMock := &MockRecorder{}
 				recorderMock.On("measureMetricsParsed", 0).Return(nil)
 				recorderMock.On("measureMetricsWritten", 0).Return(nil)
-				recorderMock.On("measureProxyErrors", "errorx.BadRequest").Return(nil)
+				recorderMock.On("measureRequestTooLarge", "decompressed").Return(nil)
+				recorderMock.On("measureProxyErrors", "influx.requestTooLargeError").Return(nil)
 				recorderMock.On("measureConversionDuration", mock.MatchedBy(func(duration time.Duration) bool { return duration > 0 })).Return(nil)
 
 				return recorderMock
@@ -219,6 +217,88 @@ func TestHandleSeriesPush(t *testing.T) {
 		})
 	}
 }
+
+func TestHandleSeriesPushBatches(t *testing.T) {
+	data := "m f=1 1465839830100400200\nm f=2 1465839830100400200\nm f=3 1465839830100400200\nm f=4 1465839830100400200\nm f=5 1465839830100400200"
+	tests := []struct {
+		name           string
+		data           string
+		writeErrs      []error
+		expectedWrites int
+		expectedCode   int
+		expectJsonBody string
+	}{
+		{
+			name:           "all batches written",
+			data:           data,
+			writeErrs:      []error{nil, nil, nil},
+			expectedWrites: 3,
+			expectedCode:   http.StatusNoContent,
+		},
+		{
+			name:           "second batch failed",
+			data:           data,
+			writeErrs:      []error{nil, errorx.Internal{Msg: "some error message"}},
+			expectedWrites: 2,
+			expectedCode:   http.StatusInternalServerError,
+			expectJsonBody: `{
+				"code": "internal error",
+				"message": "partial write: some error message (2 series written)"
+			}`,
+		},
+		{
+			name:           "invalid line between batches",
+			data:           "m f=1 1465839830100400200\nm f=2 1465839830100400200\nm f= 1465839830100400200\nm f=4 1465839830100400200",
+			writeErrs:      []error{nil, nil},
+			expectedWrites: 2,
+			expectedCode:   http.StatusBadRequest,
+			expectJsonBody: `{
+				"code": "invalid",
+				"message": "partial write: unable to parse 'm f= 1465839830100400200': missing field value (line 3) dropped=1",
+				"line": 3
+			}`,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			req := httptest.NewRequest("POST", "/write", bytes.NewReader([]byte(tt.data)))
+			rec := httptest.NewRecorder()
+
+			remoteWriteMock := &remotewritemock.Client{}
+			for _, err := range tt.writeErrs {
+				remoteWriteMock.On("Write", mock.Anything, mock.MatchedBy(func(req *mimirpb.WriteRequest) bool {
+					return len(req.Timeseries) <= 2
+				})).Return(err).Once()
+			}
+			recorderMock := &MockRecorder{}
+			recorderMock.On("measureMetricsParsed", mock.Anything).Return(nil)
+			recorderMock.On("measureMetricsWritten", 2).Return(nil)
+			recorderMock.On("measureMetricsWritten", 1).Return(nil)
+			recorderMock.On("measureProxyErrors", mock.Anything).Return(nil)
+			recorderMock.On("measureFailedLines", "parse_error", 1).Return(nil)
+			recorderMock.On("measureConversionDuration", mock.Anything).Return(nil)
+
+			conf := ProxyConfig{
+				Logger:              log.NewNopLogger(),
+				MaxRequestSizeBytes: DefaultMaxRequestSizeBytes,
+				WriteBatchSize:      2,
+			}
+			api, err := NewAPI(conf, remoteWriteMock, recorderMock)
+			require.NoError(t, err)
+
+			api.handleSeriesPush(rec, req)
+			assert.Equal(t, tt.expectedCode, rec.Code)
+			if tt.expectJsonBody != "" {
+				assert.JSONEq(t, tt.expectJsonBody, rec.Body.String())
+			} else {
+				assert.Empty(t, rec.Body.String())
+			}
+			remoteWriteMock.AssertNumberOfCalls(t, "Write", tt.expectedWrites)
+		})
+	}
+}
+
 func TestHandleHealth(t *testing.T) {
 	req := httptest.NewRequest("GET", "/healthz", nil)
 	req.Header.Set("X-Scope-OrgID", "fake")
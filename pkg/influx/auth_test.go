@@ -93,7 +93,7 @@ func TestAuthentication(t *testing.T) {
 			require.NoError(t, services.StartAndAwaitRunning(context.Background(), service))
 			defer service.StopAsync()
 
-			url := fmt.Sprintf("http://%s/api/v2/write", service.Addr())
+			url := fmt.Sprintf("http://%s/api/v2/write?org=my-org&bucket=my-bucket", service.Addr())
 			req, err := http.NewRequest("POST", url, bytes.NewReader([]byte("measurement,t1=v1 f1=2 1465839830100400200")))
 			require.NoError(t, err)
 			req = req.WithContext(user.InjectOrgID(req.Context(), tt.orgID))
@@ -106,3 +106,114 @@ func TestAuthentication(t *testing.T) {
 		})
 	}
 }
+
+func TestAuthenticationTenantMapping(t *testing.T) {
+	tests := []struct {
+		name          string
+		enableAuth    bool
+		expectedOrgID string
+		expectedErr   bool
+	}{
+		{
+			name:        "test auth enabled rejects tenant mapping",
+			enableAuth:  true,
+			expectedErr: true,
+		},
+		{
+			name:          "test auth disabled maps db to tenant",
+			enableAuth:    false,
+			expectedOrgID: "other",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			old := prometheus.DefaultRegisterer
+			prometheus.DefaultRegisterer = prometheus.NewRegistry()
+			t.Cleanup(func() {
+				prometheus.DefaultRegisterer = old
+			})
+
+			apiConfig := ProxyConfig{
+				HTTPConfig: server.Config{
+					HTTPListenAddress: "127.0.0.1",
+					HTTPListenPort:    0, // Request system available port
+				},
+				EnableAuth: tt.enableAuth,
+				V1Config:   V1Config{DB: ParamMapping{Mode: MappingTenant}},
+				Logger:     log.NewNopLogger(),
+				Registerer: prometheus.NewRegistry(),
+			}
+
+			remoteWriteMock := &remotewritemock.Client{}
+			remoteWriteMock.On("Write", mock.Anything, mock.Anything).
+				Return(nil).Run(func(args mock.Arguments) {
+				orgID, err := user.ExtractOrgID(args.Get(0).(context.Context))
+				require.NoError(t, err)
+				require.Equal(t, tt.expectedOrgID, orgID)
+			})
+
+			service, err := newProxyWithClient(apiConfig, remoteWriteMock)
+			if tt.expectedErr {
+				require.Error(t, err)
+				return
+			}
+			require.NoError(t, err)
+			require.NoError(t, services.StartAndAwaitRunning(context.Background(), service))
+			defer service.StopAsync()
+
+			url := fmt.Sprintf("http://%s/write?db=other", service.Addr())
+			req, err := http.NewRequest("POST", url, bytes.NewReader([]byte("measurement,t1=v1 f1=2 1465839830100400200")))
+			require.NoError(t, err)
+			require.NoError(t, user.InjectOrgIDIntoHTTPRequest(user.InjectOrgID(req.Context(), "mine"), req))
+
+			resp, err := http.DefaultClient.Do(req)
+			require.NoError(t, err)
+			require.Equal(t, http.StatusNoContent, resp.StatusCode)
+			remoteWriteMock.AssertNumberOfCalls(t, "Write", 1)
+		})
+	}
+}
+
+func TestAuthenticationProbes(t *testing.T) {
+	old := prometheus.DefaultRegisterer
+	prometheus.DefaultRegisterer = prometheus.NewRegistry()
+	t.Cleanup(func() {
+		prometheus.DefaultRegisterer = old
+	})
+
+	apiConfig := ProxyConfig{
+		HTTPConfig: server.Config{
+			HTTPListenAddress: "127.0.0.1",
+			HTTPListenPort:    0, // Request system available port
+		},
+		EnableAuth: true,
+		Logger:     log.NewNopLogger(),
+		Registerer: prometheus.NewRegistry(),
+	}
+	service, err := newProxyWithClient(apiConfig, &remotewritemock.Client{})
+	require.NoError(t, err)
+	require.NoError(t, services.StartAndAwaitRunning(context.Background(), service))
+	defer service.StopAsync()
+
+	// The probes answer without X-Scope-OrgID, unlike the writes.
+	for path, expectedCode := range map[string]int{
+		"/healthz":           http.StatusOK,
+		"/ping":              http.StatusNoContent,
+		"/api/v2/ping":       http.StatusNoContent,
+		"/health":            http.StatusOK,
+		"/api/v2/setup":      http.StatusOK,
+		"/write?db=telegraf": http.StatusUnauthorized,
+		"/api/v2/write?org=my-org&bucket=my-bucket": http.StatusUnauthorized,
+	} {
+		method := "GET"
+		if expectedCode == http.StatusUnauthorized {
+			method = "POST"
+		}
+		req, err := http.NewRequest(method, fmt.Sprintf("http://%s%s", service.Addr(), path), nil)
+		require.NoError(t, err)
+		resp, err := http.DefaultClient.Do(req)
+		require.NoError(t, err)
+		require.NoError(t, resp.Body.Close())
+		require.Equal(t, expectedCode, resp.StatusCode, path)
+	}
+}
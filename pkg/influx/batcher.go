@@ -0,0 +1,93 @@
+package influx
+
+import (
+	"context"
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/go-kit/log"
+	"github.com/go-kit/log/level"
+	"github.com/grafana/dskit/user"
+	"github.com/grafana/mimir-graphite/v2/pkg/remotewrite"
+	"github.com/grafana/mimir/pkg/mimirpb"
+)
+
+// seriesBatcher accumulates the series converted by a listener and writes them
+// to the remote write endpoint in batches of about maxSize series. Series
+// that fail to be written are dropped, as listeners have no client to report
+// the error to. It is safe for concurrent use.
+type seriesBatcher struct {
+	// ctx carries the tenant of the writes.
+	ctx      context.Context
+	client   remotewrite.Client
+	recorder Recorder
+	logger   log.Logger
+	maxSize  int
+	// converter applies the per-tenant settings of the listener to the series
+	// written, and sends their metadata.
+	converter *converter
+
+	mtx     sync.Mutex
+	pending []mimirpb.TimeSeries
+}
+
+// newSeriesBatcher creates a batcher writing the series converted by conv to
+// tenant in batches of about maxSize series.
+func newSeriesBatcher(tenant string, maxSize int, conv *converter, client remotewrite.Client, recorder Recorder, logger log.Logger) *seriesBatcher {
+	return &seriesBatcher{
+		ctx:       user.InjectOrgID(context.Background(), tenant),
+		client:    client,
+		recorder:  recorder,
+		logger:    logger,
+		maxSize:   maxSize,
+		converter: conv,
+	}
+}
+
+// add appends ts to the batch, writing out full batches. A full batch isn't
+// cut through the parts of a histogram, as histogramCut tells, but a batch
+// written because of the batch timeout may still split them.
+func (b *seriesBatcher) add(ts []mimirpb.TimeSeries) {
+	var full [][]mimirpb.TimeSeries
+	b.mtx.Lock()
+	b.pending = append(b.pending, ts...)
+	for b.maxSize > 0 && len(b.pending) >= b.maxSize {
+		n := b.converter.histogramCut(b.pending, b.maxSize)
+		if n == 0 {
+			break
+		}
+		full = append(full, b.pending[:n:n])
+		b.pending = b.pending[n:]
+	}
+	b.mtx.Unlock()
+
+	for _, batch := range full {
+		b.write(batch)
+	}
+}
+
+// flush writes the pending series, if any.
+func (b *seriesBatcher) flush() {
+	b.mtx.Lock()
+	batch := b.pending
+	b.pending = nil
+	b.mtx.Unlock()
+
+	if len(batch) > 0 {
+		b.write(batch)
+	}
+}
+
+func (b *seriesBatcher) write(ts []mimirpb.TimeSeries) {
+	tenant, _ := user.ExtractOrgID(b.ctx)
+	ts = b.converter.validateSamples(tenant, ts, time.Now())
+	ts = b.converter.relabel(tenant, b.converter.assembleHistograms(ts))
+	rwReq := newWriteRequest(ts, b.converter.metadataDue(tenant, ts))
+	if err := b.client.Write(b.ctx, rwReq); err != nil {
+		_ = level.Warn(b.logger).Log("msg", "failed to write batch", "series", len(rwReq.Timeseries), "err", err)
+		b.recorder.measureProxyErrors(fmt.Sprintf("%T", err))
+		return
+	}
+	b.recorder.measureMetricsWritten(len(rwReq.Timeseries))
+}
@@ -0,0 +1,149 @@
+package influx
+
+import (
+	"fmt"
+	"io"
+	"net/http"
+)
+
+const (
+	tooLargeWire             = "wire"
+	tooLargeDecompressed     = "decompressed"
+	tooLargeCompressionRatio = "compression_ratio"
+
+	// compressionRatioMinBytes is the decompressed size below which the
+	// compression ratio isn't checked, as small bodies can't hurt and are
+	// dominated by the headers of the compression format.
+	compressionRatioMinBytes = 1 << 20
+)
+
+// bodyLimits bound the size of a request body. Zero values mean no limit.
+type bodyLimits struct {
+	// maxWireBytes limits the size of the body as received, compressed or not.
+	maxWireBytes int64
+	// maxDecompressedBytes limits the size of the body once decompressed.
+	maxDecompressedBytes int64
+	// maxCompressionRatio limits the decompressed size relative to the wire
+	// size.
+	maxCompressionRatio float64
+}
+
+// requestTooLargeError is returned when a request body exceeds one of its
+// limits. reason tells which one.
+type requestTooLargeError struct {
+	reason string
+	limit  float64
+}
+
+func (e requestTooLargeError) Error() string {
+	switch e.reason {
+	case tooLargeDecompressed:
+		return fmt.Sprintf("decompressed request body is larger than %.0f bytes", e.limit)
+	case tooLargeCompressionRatio:
+		return fmt.Sprintf("request body compression ratio is higher than %g", e.limit)
+	}
+	return fmt.Sprintf("request body is larger than %.0f bytes", e.limit)
+}
+
+// checkDecodedLen checks the size a body of wireBytes bytes decompresses to at
+// once, rather than as it is read, before it is allocated. As that size is
+// sent by the client, it is bounded by DefaultMaxRequestSizeBytes even when the
+// decompressed size isn't limited.
+func (l bodyLimits) checkDecodedLen(wireBytes, decodedBytes int64) error {
+	if l.maxCompressionRatio > 0 && decodedBytes > compressionRatioMinBytes &&
+		float64(decodedBytes) > l.maxCompressionRatio*float64(wireBytes) {
+		return requestTooLargeError{reason: tooLargeCompressionRatio, limit: l.maxCompressionRatio}
+	}
+	max := l.maxDecompressedBytes
+	if max <= 0 {
+		max = DefaultMaxRequestSizeBytes
+	}
+	if decodedBytes > max {
+		return requestTooLargeError{reason: tooLargeDecompressed, limit: float64(max)}
+	}
+	return nil
+}
+
+// batchReadCloser returns a reader of the body of r, decompressed according to
+// its Content-Encoding, which fails with a requestTooLargeError as soon as one of the limits
+// is exceeded. The wire size limit is checked against the Content-Length of
+// the request before anything is read.
+func batchReadCloser(r *http.Request, limits bodyLimits) (io.ReadCloser, error) {
+	if limits.maxWireBytes > 0 && r.ContentLength > limits.maxWireBytes {
+		return nil, requestTooLargeError{reason: tooLargeWire, limit: float64(limits.maxWireBytes)}
+	}
+
+	wire := &countingReader{r: r.Body, max: limits.maxWireBytes, reason: tooLargeWire}
+	decoded, err := newDecoder(wire, r.Header.Get("Content-Encoding"), limits)
+	if err != nil {
+		return nil, err
+	}
+
+	body := &bodyReader{
+		decompressed:        &countingReader{r: decoded, max: limits.maxDecompressedBytes, reason: tooLargeDecompressed},
+		wire:                wire,
+		maxCompressionRatio: limits.maxCompressionRatio,
+		closers:             []io.Closer{decoded, r.Body},
+	}
+	return body, nil
+}
+
+// bodyReader reads a decompressed request body, checking its compression
+// ratio.
+type bodyReader struct {
+	decompressed        *countingReader
+	wire                *countingReader
+	maxCompressionRatio float64
+	closers             []io.Closer
+}
+
+func (b *bodyReader) Read(p []byte) (int, error) {
+	n, err := b.decompressed.Read(p)
+	if b.maxCompressionRatio > 0 && b.decompressed.n > compressionRatioMinBytes &&
+		float64(b.decompressed.n) > b.maxCompressionRatio*float64(b.wire.n) {
+		return 0, requestTooLargeError{reason: tooLargeCompressionRatio, limit: b.maxCompressionRatio}
+	}
+	return n, err
+}
+
+func (b *bodyReader) Close() error {
+	var firstErr error
+	for _, c := range b.closers {
+		if err := c.Close(); err != nil && firstErr == nil {
+			firstErr = err
+		}
+	}
+	return firstErr
+}
+
+// countingReader counts the bytes read from r and, when max is positive,
+// fails with a requestTooLargeError once more than max bytes were read.
+type countingReader struct {
+	r      io.Reader
+	n      int64
+	max    int64
+	reason string
+}
+
+func (c *countingReader) Read(p []byte) (int, error) {
+	if c.max > 0 {
+		if c.n > c.max {
+			return 0, c.tooLarge()
+		}
+		// Read one byte past the limit to tell bodies of exactly max bytes
+		// from larger ones.
+		if remaining := c.max - c.n + 1; int64(len(p)) > remaining {
+			p = p[:remaining]
+		}
+	}
+	n, err := c.r.Read(p)
+	c.n += int64(n)
+	if c.max > 0 && c.n > c.max {
+		return n - int(c.n-c.max), c.tooLarge()
+	}
+	return n, err
+}
+
+func (c *countingReader) tooLarge() error {
+	return requestTooLargeError{reason: c.reason, limit: float64(c.max)}
+}
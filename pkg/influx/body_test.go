@@ -0,0 +1,131 @@
+package influx
+
+import (
+	"bytes"
+	"compress/gzip"
+	"io"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/grafana/mimir-graphite/v2/pkg/errorx"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func gzipped(t *testing.T, data string) []byte {
+	var buf bytes.Buffer
+	gz := gzip.NewWriter(&buf)
+	_, err := gz.Write([]byte(data))
+	require.NoError(t, err)
+	require.NoError(t, gz.Close())
+	return buf.Bytes()
+}
+
+func TestBatchReadCloser(t *testing.T) {
+	data := strings.Repeat("m,t1=v1 f1=2 1465839830100400200\n", 1<<16)
+	compressed := gzipped(t, data)
+
+	tests := []struct {
+		name          string
+		body          []byte
+		encoding      string
+		contentLength int64
+		limits        bodyLimits
+		expectedErr   string
+		expectedRead  string
+	}{
+		{
+			name:         "no limits",
+			body:         []byte(data),
+			expectedRead: data,
+		},
+		{
+			name:         "gzip",
+			body:         compressed,
+			encoding:     "gzip",
+			limits:       bodyLimits{maxWireBytes: int64(len(compressed)), maxDecompressedBytes: int64(len(data)), maxCompressionRatio: 1000},
+			expectedRead: data,
+		},
+		{
+			name:        "wire size from content length",
+			body:        []byte(data),
+			limits:      bodyLimits{maxWireBytes: 10},
+			expectedErr: tooLargeWire,
+		},
+		{
+			name:          "wire size without content length",
+			body:          []byte(data),
+			contentLength: -1,
+			limits:        bodyLimits{maxWireBytes: 10},
+			expectedErr:   tooLargeWire,
+			expectedRead:  data[:10],
+		},
+		{
+			name:        "compressed wire size",
+			body:        compressed,
+			encoding:    "gzip",
+			limits:      bodyLimits{maxWireBytes: int64(len(compressed)) - 1},
+			expectedErr: tooLargeWire,
+		},
+		{
+			name:         "decompressed size",
+			body:         compressed,
+			encoding:     "gzip",
+			limits:       bodyLimits{maxDecompressedBytes: 100},
+			expectedErr:  tooLargeDecompressed,
+			expectedRead: data[:100],
+		},
+		{
+			name:        "compression ratio",
+			body:        compressed,
+			encoding:    "gzip",
+			limits:      bodyLimits{maxCompressionRatio: 10},
+			expectedErr: tooLargeCompressionRatio,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			req := httptest.NewRequest("POST", "/write", bytes.NewReader(tt.body))
+			if tt.contentLength != 0 {
+				req.ContentLength = tt.contentLength
+			}
+			if tt.encoding != "" {
+				req.Header.Set("Content-Encoding", tt.encoding)
+			}
+
+			reader, err := batchReadCloser(req, tt.limits)
+			var read []byte
+			if err == nil {
+				read, err = io.ReadAll(reader)
+				require.NoError(t, reader.Close())
+			}
+			if tt.expectedErr == "" {
+				require.NoError(t, err)
+			} else {
+				var tooLarge requestTooLargeError
+				require.ErrorAs(t, err, &tooLarge)
+				assert.Equal(t, tt.expectedErr, tooLarge.reason)
+			}
+			if tt.expectedRead != "" {
+				assert.Equal(t, tt.expectedRead, string(read))
+			}
+		})
+	}
+}
+
+func TestBatchReadCloserInvalidGzip(t *testing.T) {
+	req := httptest.NewRequest("POST", "/write", bytes.NewReader([]byte("m,t1=v1 f1=2 1465839830100400200")))
+	req.Header.Add("Content-Encoding", "gzip")
+
+	_, err := batchReadCloser(req, bodyLimits{})
+	require.Error(t, err)
+	assert.ErrorAs(t, err, &errorx.BadRequest{})
+}
+
+func TestRequestTooLargeError(t *testing.T) {
+	assert.Equal(t, "request body is larger than 10485760 bytes", requestTooLargeError{reason: tooLargeWire, limit: 10 << 20}.Error())
+	assert.Equal(t, "decompressed request body is larger than 8 bytes", requestTooLargeError{reason: tooLargeDecompressed, limit: 8}.Error())
+	assert.Equal(t, "request body compression ratio is higher than 12.5", requestTooLargeError{reason: tooLargeCompressionRatio, limit: 12.5}.Error())
+}
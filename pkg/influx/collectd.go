@@ -0,0 +1,268 @@
+package influx
+
+import (
+	"errors"
+	"flag"
+	"fmt"
+	"math"
+	"os"
+	"path/filepath"
+	"sort"
+	"time"
+
+	"collectd.org/api"
+	"collectd.org/network"
+	"github.com/grafana/mimir/pkg/mimirpb"
+	"github.com/prometheus/prometheus/model/labels"
+)
+
+const (
+	collectdListenerName = "collectd"
+	// collectdSource is the value of the source label of collectd series.
+	collectdSource = "collectd"
+
+	CollectdSecurityNone    = "none"
+	CollectdSecuritySign    = "sign"
+	CollectdSecurityEncrypt = "encrypt"
+)
+
+// CollectdConfig configures the optional listener of the collectd binary
+// network protocol, modelled after the collectd service of InfluxDB 1.x.
+type CollectdConfig struct {
+	// ListenAddress is the host:port the listener binds to. Empty disables the
+	// listener.
+	ListenAddress string
+	// Tenant is the tenant (X-Scope-OrgID) of the values received.
+	Tenant string
+	// TypesDB is a types.db file, or a directory of them, naming the data
+	// sources of the values received. Without it, the data sources of single
+	// value types are named "value" and the others by their index.
+	TypesDB string
+	// SecurityLevel is the minimum security level of the packets accepted:
+	// none, sign or encrypt.
+	SecurityLevel string
+	// AuthFile holds the user:password pairs used to verify signed packets and
+	// decrypt encrypted ones.
+	AuthFile string
+	// BatchSize is the number of series written per remote write request.
+	BatchSize int
+	// BatchTimeout is the longest time series wait before being written.
+	BatchTimeout time.Duration
+	// BatchPending is the number of packets queued for parsing. Packets
+	// received while the queue is full are dropped.
+	BatchPending int
+	// ReadBufferBytes sets the socket receive buffer size. 0 keeps the
+	// operating system default.
+	ReadBufferBytes int
+}
+
+func (c *CollectdConfig) RegisterFlags(flags *flag.FlagSet) {
+	flags.StringVar(&c.ListenAddress, "collectd.listen-address", "", "host:port of the collectd listener; empty to disable it")
+	flags.StringVar(&c.Tenant, "collectd.tenant", "fake", "tenant of the values received by the collectd listener")
+	flags.StringVar(&c.TypesDB, "collectd.typesdb", "", "types.db file, or directory of them, naming the data sources of collectd values")
+	flags.StringVar(&c.SecurityLevel, "collectd.security-level", CollectdSecurityNone, "minimum security level of the collectd packets accepted: none, sign or encrypt")
+	flags.StringVar(&c.AuthFile, "collectd.auth-file", "", "file of user:password pairs used to verify and decrypt collectd packets")
+	flags.IntVar(&c.BatchSize, "collectd.batch-size", 5000, "number of series written per request by the collectd listener")
+	flags.DurationVar(&c.BatchTimeout, "collectd.batch-timeout", time.Second, "how long the collectd listener waits before writing an incomplete batch")
+	flags.IntVar(&c.BatchPending, "collectd.batch-pending", 10, "number of packets the collectd listener queues before dropping them")
+	flags.IntVar(&c.ReadBufferBytes, "collectd.read-buffer-bytes", 0, "socket receive buffer size of the collectd listener; 0 for the OS default")
+}
+
+// Validate checks the configuration is usable.
+func (c CollectdConfig) Validate() error {
+	if c.ListenAddress == "" {
+		return nil
+	}
+	if c.Tenant == "" {
+		return errors.New("tenant is required")
+	}
+	switch c.SecurityLevel {
+	case "", CollectdSecurityNone:
+	case CollectdSecuritySign, CollectdSecurityEncrypt:
+		if c.AuthFile == "" {
+			return fmt.Errorf("security level %q requires an auth file", c.SecurityLevel)
+		}
+	default:
+		return fmt.Errorf("invalid security level %q (use none, sign or encrypt)", c.SecurityLevel)
+	}
+	if c.BatchSize <= 0 || c.BatchTimeout <= 0 || c.BatchPending <= 0 {
+		return errors.New("batch size, timeout and pending must be positive")
+	}
+	return nil
+}
+
+// udpConfig returns the configuration of the UDP listener receiving the
+// packets.
+func (c CollectdConfig) udpConfig() UDPConfig {
+	return UDPConfig{
+		ListenAddress:   c.ListenAddress,
+		Tenant:          c.Tenant,
+		BatchSize:       c.BatchSize,
+		BatchTimeout:    c.BatchTimeout,
+		BatchPending:    c.BatchPending,
+		ReadBufferBytes: c.ReadBufferBytes,
+	}
+}
+
+// parseOpts loads the types.db files and the auth file.
+func (c CollectdConfig) parseOpts() (network.ParseOpts, error) {
+	var opts network.ParseOpts
+	switch c.SecurityLevel {
+	case CollectdSecuritySign:
+		opts.SecurityLevel = network.Sign
+	case CollectdSecurityEncrypt:
+		opts.SecurityLevel = network.Encrypt
+	}
+	if c.AuthFile != "" {
+		if _, err := os.Stat(c.AuthFile); err != nil {
+			return network.ParseOpts{}, fmt.Errorf("failed to read auth file: %w", err)
+		}
+		opts.PasswordLookup = network.NewAuthFile(c.AuthFile)
+	}
+	if c.TypesDB != "" {
+		typesDB, err := loadTypesDB(c.TypesDB)
+		if err != nil {
+			return network.ParseOpts{}, err
+		}
+		opts.TypesDB = typesDB
+	}
+	return opts, nil
+}
+
+// loadTypesDB loads a types.db file, or merges all the files of a directory.
+func loadTypesDB(path string) (*api.TypesDB, error) {
+	fi, err := os.Stat(path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read types.db: %w", err)
+	}
+	files := []string{path}
+	if fi.IsDir() {
+		entries, err := os.ReadDir(path)
+		if err != nil {
+			return nil, fmt.Errorf("failed to read types.db directory: %w", err)
+		}
+		files = files[:0]
+		for _, e := range entries {
+			if !e.IsDir() {
+				files = append(files, filepath.Join(path, e.Name()))
+			}
+		}
+	}
+
+	var typesDB *api.TypesDB
+	for _, file := range files {
+		f, err := os.Open(file)
+		if err != nil {
+			return nil, fmt.Errorf("failed to read types.db: %w", err)
+		}
+		db, err := api.NewTypesDB(f)
+		_ = f.Close()
+		if err != nil {
+			return nil, fmt.Errorf("failed to parse types.db %s: %w", file, err)
+		}
+		if typesDB == nil {
+			typesDB = db
+		} else {
+			typesDB.Merge(db)
+		}
+	}
+	if typesDB == nil {
+		return nil, fmt.Errorf("no types.db file found in %s", path)
+	}
+	return typesDB, nil
+}
+
+// collectdPacketParser parses packets of the collectd binary network protocol.
+func collectdPacketParser(opts network.ParseOpts) packetParser {
+	return func(conv *converter, limits *requestLimits, packet []byte) ([]mimirpb.TimeSeries, error) {
+		vls, err := network.Parse(packet, opts)
+		now := time.Now()
+		var ts []mimirpb.TimeSeries
+		for _, vl := range vls {
+			series, vlErr := limits.add(len(vl.Values), func() ([]mimirpb.TimeSeries, error) {
+				return conv.collectdValueListToTimeseries(vl, now)
+			})
+			if errors.As(vlErr, &invalidPointError{}) {
+				if err == nil {
+					err = vlErr
+				}
+				continue
+			}
+			if vlErr != nil {
+				return nil, vlErr
+			}
+			ts = append(ts, series...)
+		}
+		return ts, err
+	}
+}
+
+// collectdValueListToTimeseries converts each value of vl to a series, named
+// and labelled like the InfluxDB collectd service does: the name is the plugin
+// followed by the data source name, and the host, plugin instance, type and type
+// instance become the host, instance, type and type_instance labels, unless
+// they are named like a reserved label. The external labels are added like for
+// Influx points, and so do the limits on labels: the value list is invalid if
+// the labels of one of its series are. Undefined (NaN) gauges are skipped.
+func (c *converter) collectdValueListToTimeseries(vl *api.ValueList, now time.Time) ([]mimirpb.TimeSeries, error) {
+	timestamp := vl.Time
+	if timestamp.IsZero() {
+		timestamp = now
+	}
+
+	returnTs := make([]mimirpb.TimeSeries, 0, len(vl.Values))
+	for i, v := range vl.Values {
+		var value float64
+		switch v := v.(type) {
+		case api.Gauge:
+			if math.IsNaN(float64(v)) {
+				continue
+			}
+			value = float64(v)
+		case api.Derive:
+			value = float64(v)
+		case api.Counter:
+			value = float64(v)
+		default:
+			continue
+		}
+
+		name := vl.Plugin + "_" + vl.DSName(i)
+		replaceInvalidChars(&name)
+		lbls := make([]mimirpb.LabelAdapter, 0, len(c.externalLabels)+6)
+		lbls = append(lbls, mimirpb.LabelAdapter{
+			Name:  labels.MetricName,
+			Value: name,
+		})
+		if source := c.protocolSourceLabel(collectdSource); source.Name != "" {
+			lbls = append(lbls, source) // A label for tracking active series
+		}
+		for _, l := range []mimirpb.LabelAdapter{
+			{Name: "host", Value: vl.Host},
+			{Name: "instance", Value: vl.PluginInstance},
+			{Name: "type", Value: vl.Type},
+			{Name: "type_instance", Value: vl.TypeInstance},
+		} {
+			if l.Value != "" && !c.isReservedLabel(l.Name) {
+				lbls = append(lbls, l)
+			}
+		}
+		lbls = c.withExternalLabels(lbls)
+		sort.Slice(lbls, func(i, j int) bool {
+			return lbls[i].Name < lbls[j].Name
+		})
+		lbls, err := c.limitLabels(lbls)
+		if err != nil {
+			return nil, err
+		}
+
+		returnTs = append(returnTs, mimirpb.TimeSeries{
+			Labels: lbls,
+			Samples: []mimirpb.Sample{{
+				TimestampMs: timestamp.UnixMilli(),
+				Value:       value,
+			}},
+		})
+	}
+	return returnTs, nil
+}
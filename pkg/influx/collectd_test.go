@@ -0,0 +1,233 @@
+package influx
+
+import (
+	"context"
+	"math"
+	"net"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"collectd.org/api"
+	"collectd.org/network"
+	"github.com/go-kit/log"
+	"github.com/grafana/dskit/services"
+	"github.com/grafana/dskit/user"
+	"github.com/grafana/mimir-graphite/v2/pkg/remotewrite/remotewritemock"
+	"github.com/grafana/mimir/pkg/mimirpb"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/mock"
+	"github.com/stretchr/testify/require"
+)
+
+const testTypesDB = `
+# comment
+if_octets		rx:DERIVE:0:U, tx:DERIVE:0:U
+load			shortterm:GAUGE:0:5000, midterm:GAUGE:0:5000, longterm:GAUGE:0:5000
+memory			value:GAUGE:0:281474976710656
+`
+
+func TestCollectdValueListToTimeseries(t *testing.T) {
+	now := time.Unix(1465839830, 0)
+	tests := []struct {
+		name     string
+		vl       *api.ValueList
+		expected []mimirpb.TimeSeries
+	}{
+		{
+			name: "single value",
+			vl: &api.ValueList{
+				Identifier: api.Identifier{Host: "web01", Plugin: "memory", Type: "memory", TypeInstance: "used"},
+				Time:       time.Unix(1465839831, 0),
+				Values:     []api.Value{api.Gauge(42)},
+			},
+			expected: []mimirpb.TimeSeries{{
+				Labels: []mimirpb.LabelAdapter{
+					{Name: "__name__", Value: "memory_value"},
+					{Name: "__proxy_source__", Value: "collectd"},
+					{Name: "host", Value: "web01"},
+					{Name: "type", Value: "memory"},
+					{Name: "type_instance", Value: "used"},
+				},
+				Samples: []mimirpb.Sample{{Value: 42, TimestampMs: 1465839831000}},
+			}},
+		},
+		{
+			name: "multiple values",
+			vl: &api.ValueList{
+				Identifier: api.Identifier{Host: "web01", Plugin: "interface", PluginInstance: "eth0", Type: "if_octets"},
+				Values:     []api.Value{api.Derive(1), api.Counter(2)},
+				DSNames:    []string{"rx", "tx"},
+			},
+			expected: []mimirpb.TimeSeries{
+				{
+					Labels: []mimirpb.LabelAdapter{
+						{Name: "__name__", Value: "interface_rx"},
+						{Name: "__proxy_source__", Value: "collectd"},
+						{Name: "host", Value: "web01"},
+						{Name: "instance", Value: "eth0"},
+						{Name: "type", Value: "if_octets"},
+					},
+					Samples: []mimirpb.Sample{{Value: 1, TimestampMs: 1465839830000}},
+				},
+				{
+					Labels: []mimirpb.LabelAdapter{
+						{Name: "__name__", Value: "interface_tx"},
+						{Name: "__proxy_source__", Value: "collectd"},
+						{Name: "host", Value: "web01"},
+						{Name: "instance", Value: "eth0"},
+						{Name: "type", Value: "if_octets"},
+					},
+					Samples: []mimirpb.Sample{{Value: 2, TimestampMs: 1465839830000}},
+				},
+			},
+		},
+		{
+			name: "no types.db",
+			vl: &api.ValueList{
+				Identifier: api.Identifier{Plugin: "load.avg", Type: "load"},
+				Values:     []api.Value{api.Gauge(1), api.Gauge(math.NaN())},
+			},
+			expected: []mimirpb.TimeSeries{{
+				Labels: []mimirpb.LabelAdapter{
+					{Name: "__name__", Value: "load_avg_0"},
+					{Name: "__proxy_source__", Value: "collectd"},
+					{Name: "type", Value: "load"},
+				},
+				Samples: []mimirpb.Sample{{Value: 1, TimestampMs: 1465839830000}},
+			}},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			conv := newConverter(ConversionConfig{}, log.NewNopLogger(), &MockRecorder{})
+			ts, err := conv.collectdValueListToTimeseries(tt.vl, now)
+			require.NoError(t, err)
+			assert.Equal(t, tt.expected, ts)
+		})
+	}
+}
+
+func TestLoadTypesDB(t *testing.T) {
+	dir := t.TempDir()
+	require.NoError(t, os.WriteFile(filepath.Join(dir, "types.db"), []byte(testTypesDB), 0o600))
+	require.NoError(t, os.WriteFile(filepath.Join(dir, "custom.db"), []byte("queue_length\tvalue:GAUGE:0:U\n"), 0o600))
+
+	typesDB, err := loadTypesDB(filepath.Join(dir, "types.db"))
+	require.NoError(t, err)
+	ds, ok := typesDB.DataSet("if_octets")
+	require.True(t, ok)
+	assert.Equal(t, []string{"rx", "tx"}, ds.Names())
+	_, ok = typesDB.DataSet("queue_length")
+	assert.False(t, ok)
+
+	typesDB, err = loadTypesDB(dir)
+	require.NoError(t, err)
+	_, ok = typesDB.DataSet("if_octets")
+	assert.True(t, ok)
+	_, ok = typesDB.DataSet("queue_length")
+	assert.True(t, ok)
+
+	_, err = loadTypesDB(t.TempDir())
+	assert.Error(t, err)
+}
+
+func TestCollectdListener(t *testing.T) {
+	dir := t.TempDir()
+	typesDBPath := filepath.Join(dir, "types.db")
+	require.NoError(t, os.WriteFile(typesDBPath, []byte(testTypesDB), 0o600))
+	authFilePath := filepath.Join(dir, "auth_file")
+	require.NoError(t, os.WriteFile(authFilePath, []byte("alice: w0nderl4nd\n"), 0o600))
+
+	remoteWriteMock := &remotewritemock.Client{}
+	written := make(chan []mimirpb.PreallocTimeseries, 1)
+	remoteWriteMock.On("Write", mock.Anything, mock.Anything).Return(nil).Run(func(args mock.Arguments) {
+		orgID, err := user.ExtractOrgID(args.Get(0).(context.Context))
+		require.NoError(t, err)
+		require.Equal(t, "collectd-tenant", orgID)
+		written <- args.Get(1).(*mimirpb.WriteRequest).Timeseries
+	})
+	recorderMock := &MockRecorder{}
+	recorderMock.On("measureMessagesReceived", "collectd").Return(nil)
+	recorderMock.On("measureMetricsParsed", mock.Anything).Return(nil)
+	recorderMock.On("measureMetricsWritten", 2).Return(nil)
+	recorderMock.On("measureConversionDuration", mock.Anything).Return(nil)
+
+	cfg := CollectdConfig{
+		ListenAddress: "127.0.0.1:0",
+		Tenant:        "collectd-tenant",
+		TypesDB:       typesDBPath,
+		SecurityLevel: CollectdSecuritySign,
+		AuthFile:      authFilePath,
+		BatchSize:     2,
+		BatchTimeout:  time.Hour,
+		BatchPending:  10,
+	}
+	require.NoError(t, cfg.Validate())
+	opts, err := cfg.parseOpts()
+	require.NoError(t, err)
+	l := newUDPListener(collectdListenerName, cfg.udpConfig(), collectdPacketParser(opts), newConverter(ConversionConfig{}, log.NewNopLogger(), recorderMock), log.NewNopLogger(), remoteWriteMock, recorderMock)
+	require.NoError(t, services.StartAndAwaitRunning(context.Background(), l))
+	defer func() {
+		require.NoError(t, services.StopAndAwaitTerminated(context.Background(), l))
+	}()
+
+	conn, err := net.Dial("udp", l.Addr().String())
+	require.NoError(t, err)
+	defer conn.Close()
+
+	vl := &api.ValueList{
+		Identifier: api.Identifier{Host: "web01", Plugin: "interface", PluginInstance: "eth0", Type: "if_octets"},
+		Time:       time.Unix(1465839830, 0),
+		Interval:   10 * time.Second,
+		Values:     []api.Value{api.Derive(1), api.Derive(2)},
+	}
+
+	// Unsigned packets are ignored with the sign security level.
+	buf := network.NewBuffer(network.DefaultBufferSize)
+	require.NoError(t, buf.Write(context.Background(), vl))
+	packet, err := buf.Bytes()
+	require.NoError(t, err)
+	_, err = conn.Write(packet)
+	require.NoError(t, err)
+
+	buf = network.NewBuffer(network.DefaultBufferSize)
+	buf.Sign("alice", "w0nderl4nd")
+	require.NoError(t, buf.Write(context.Background(), vl))
+	packet, err = buf.Bytes()
+	require.NoError(t, err)
+	_, err = conn.Write(packet)
+	require.NoError(t, err)
+
+	select {
+	case ts := <-written:
+		require.Len(t, ts, 2)
+		assert.Equal(t, "interface_rx", ts[0].Labels[0].Value)
+		assert.Equal(t, "interface_tx", ts[1].Labels[0].Value)
+		assert.Equal(t, []mimirpb.Sample{{Value: 2, TimestampMs: 1465839830000}}, ts[1].Samples)
+	case <-time.After(5 * time.Second):
+		t.Fatal("batch not written")
+	}
+}
+
+func TestCollectdConfigValidate(t *testing.T) {
+	valid := CollectdConfig{ListenAddress: ":25826", Tenant: "t", BatchSize: 1, BatchTimeout: time.Second, BatchPending: 1}
+	assert.NoError(t, valid.Validate())
+	assert.NoError(t, CollectdConfig{}.Validate())
+
+	cfg := valid
+	cfg.SecurityLevel = CollectdSecurityEncrypt
+	assert.Error(t, cfg.Validate())
+	cfg.AuthFile = "auth_file"
+	assert.NoError(t, cfg.Validate())
+
+	cfg = valid
+	cfg.SecurityLevel = "paranoid"
+	assert.Error(t, cfg.Validate())
+
+	cfg = valid
+	cfg.Tenant = ""
+	assert.Error(t, cfg.Validate())
+}
@@ -0,0 +1,68 @@
+package influx
+
+import (
+	"errors"
+	"fmt"
+	"strconv"
+
+	"github.com/go-kit/log/level"
+	"github.com/grafana/mimir/pkg/mimirpb"
+	"github.com/prometheus/prometheus/model/labels"
+)
+
+// Policies for the tags, or string fields added as labels, whose label names
+// collide once sanitized, such as a.b and a-b, or with a reserved label name.
+const (
+	// LabelCollisionsFirstWins keeps the first of the colliding tags, in the
+	// order of their keys, and drops the others.
+	LabelCollisionsFirstWins = "first-wins"
+	// LabelCollisionsSuffix adds the lowest free _1, _2… suffix to the label
+	// names of the colliding tags after the first.
+	LabelCollisionsSuffix = "suffix"
+	// LabelCollisionsReject rejects the line of the point.
+	LabelCollisionsReject = "reject"
+)
+
+var errLabelCollision = errors.New("label name collision")
+
+// isReservedLabel reports whether name is the name of a label set by the
+// converter.
+func (c *converter) isReservedLabel(name string) bool {
+	return name == labels.MetricName || name == c.sourceLabel.Name || name == escapingLabel || name == labelsHashLabel
+}
+
+// addLabel appends the label of key, named name, to the labels lbls of the
+// keys of a point, resolving its collisions with the configured policy. keys
+// are the keys of lbls, and kind is either tag or field for logging.
+func (c *converter) addLabel(lbls []mimirpb.LabelAdapter, keys []string, kind, key, name, value string) ([]mimirpb.LabelAdapter, []string, error) {
+	collision := c.isReservedLabel(name)
+	other := "reserved label"
+	for i, l := range lbls {
+		if l.Name == name {
+			collision, other = true, kind+" "+strconv.Quote(keys[i])
+			break
+		}
+	}
+	if !collision {
+		return append(lbls, mimirpb.LabelAdapter{Name: name, Value: value}), append(keys, key), nil
+	}
+
+	policy := c.labelCollisions
+	if policy == "" {
+		policy = LabelCollisionsFirstWins
+	}
+	_ = level.Debug(c.logger).Log("msg", "label name collision", kind, key, "label", name, "with", other, "policy", policy)
+	c.recorder.measureLabelCollisions(policy)
+	switch policy {
+	case LabelCollisionsReject:
+		return nil, nil, invalidPointError{fmt.Errorf("%s %q: %w with %s as %q", kind, key, errLabelCollision, other, name)}
+	case LabelCollisionsSuffix:
+		for n := 1; ; n++ {
+			suffixed := name + "_" + strconv.Itoa(n)
+			if !c.isReservedLabel(suffixed) && !hasLabel(lbls, suffixed) {
+				return append(lbls, mimirpb.LabelAdapter{Name: suffixed, Value: value}), append(keys, key), nil
+			}
+		}
+	}
+	return lbls, keys, nil
+}
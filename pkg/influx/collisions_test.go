@@ -0,0 +1,98 @@
+package influx
+
+import (
+	"testing"
+	"time"
+
+	"github.com/go-kit/log"
+	"github.com/grafana/mimir/pkg/mimirpb"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestLabelCollisions(t *testing.T) {
+	const data = `cpu,a.b=1,a-b=2,a_b_1=3,__name-_=x usage=1 1465839830100400200`
+	tests := []struct {
+		policy      string
+		expected    []mimirpb.LabelAdapter
+		collisions  int
+		expectedErr string
+	}{
+		{
+			policy: "",
+			expected: []mimirpb.LabelAdapter{
+				{Name: "__name__", Value: "cpu_usage"},
+				{Name: "__proxy_source__", Value: "influx"},
+				{Name: "a_b", Value: "2"},
+				{Name: "a_b_1", Value: "3"},
+			},
+			collisions: 2,
+		},
+		{
+			policy: LabelCollisionsSuffix,
+			expected: []mimirpb.LabelAdapter{
+				{Name: "__name__", Value: "cpu_usage"},
+				{Name: "__name___1", Value: "x"},
+				{Name: "__proxy_source__", Value: "influx"},
+				{Name: "a_b", Value: "2"},
+				{Name: "a_b_1", Value: "1"},
+				{Name: "a_b_1_1", Value: "3"},
+			},
+			collisions: 3,
+		},
+		{
+			policy:      LabelCollisionsReject,
+			collisions:  1,
+			expectedErr: `tag "__name-_": label name collision with reserved label as "__name__"`,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.policy, func(t *testing.T) {
+			policy := tt.policy
+			if policy == "" {
+				policy = LabelCollisionsFirstWins
+			}
+			recorderMock := &MockRecorder{}
+			recorderMock.On("measureLabelCollisions", policy).Return(nil)
+			conv := newConverter(ConversionConfig{LabelCollisions: tt.policy}, log.NewNopLogger(), recorderMock)
+
+			points, err := parsePointsWithPrecision([]byte(data), time.Now(), "ns")
+			require.NoError(t, err)
+			ts, err := conv.writeRequestFromInfluxPoints("fake", points, nil)
+			recorderMock.AssertNumberOfCalls(t, "measureLabelCollisions", tt.collisions)
+			if tt.expectedErr != "" {
+				assert.ErrorIs(t, err, errLabelCollision)
+				assert.ErrorAs(t, err, &invalidPointError{})
+				assert.EqualError(t, err, tt.expectedErr)
+				return
+			}
+			require.NoError(t, err)
+			require.Len(t, ts, 1)
+			assert.Equal(t, tt.expected, ts[0].Labels)
+		})
+	}
+}
+
+func TestLabelCollisionsStringFields(t *testing.T) {
+	recorderMock := &MockRecorder{}
+	recorderMock.On("measureLabelCollisions", LabelCollisionsFirstWins).Return(nil)
+	conv := newConverter(ConversionConfig{StringFields: StringFieldsConfig{Labels: []string{"a.b", "a-b"}}}, log.NewNopLogger(), recorderMock)
+
+	points, err := parsePointsWithPrecision([]byte(`m a.b="x",a-b="y",v=1 1465839830100400200`), time.Now(), "ns")
+	require.NoError(t, err)
+	ts, err := conv.writeRequestFromInfluxPoints("fake", points, nil)
+	require.NoError(t, err)
+	require.Len(t, ts, 1)
+	assert.Equal(t, []mimirpb.LabelAdapter{
+		{Name: "__name__", Value: "m_v"},
+		{Name: "__proxy_source__", Value: "influx"},
+		{Name: "a_b", Value: "y"},
+	}, ts[0].Labels)
+	recorderMock.AssertNumberOfCalls(t, "measureLabelCollisions", 1)
+}
+
+func TestLabelCollisionsValidate(t *testing.T) {
+	assert.NoError(t, ConversionConfig{LabelCollisions: LabelCollisionsReject}.Validate())
+	assert.Error(t, ConversionConfig{LabelCollisions: "last-wins"}.Validate())
+}
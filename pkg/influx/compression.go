@@ -0,0 +1,196 @@
+package influx
+
+import (
+	"bufio"
+	"bytes"
+	"compress/flate"
+	"compress/gzip"
+	"compress/zlib"
+	"fmt"
+	"io"
+	"strings"
+	"sync"
+
+	"github.com/andybalholm/brotli"
+	"github.com/golang/snappy"
+	"github.com/grafana/mimir-graphite/v2/pkg/errorx"
+	"github.com/klauspost/compress/zstd"
+)
+
+// snappyStreamMagic starts every stream of the framed snappy format, which
+// tells it from the block format.
+var snappyStreamMagic = []byte("\xff\x06\x00\x00sNaPpY")
+
+// decoders create the readers decompressing the bodies sent with each
+// Content-Encoding. Decoders are pooled, and returned to their pool when the
+// reader is closed.
+var decoders = map[string]func(r io.Reader, limits bodyLimits) (io.ReadCloser, error){
+	"gzip":    decodeGzip,
+	"x-gzip":  decodeGzip,
+	"zstd":    decodeZstd,
+	"snappy":  decodeSnappy,
+	"deflate": decodeDeflate,
+	"br":      decodeBrotli,
+}
+
+// newDecoder returns a reader of the body r sent with the given
+// Content-Encoding. Empty and identity encodings are read as is.
+func newDecoder(r io.Reader, encoding string, limits bodyLimits) (io.ReadCloser, error) {
+	encoding = strings.ToLower(strings.TrimSpace(encoding))
+	if encoding == "" || encoding == "identity" {
+		return io.NopCloser(r), nil
+	}
+	decode, ok := decoders[encoding]
+	if !ok {
+		return nil, errorx.UnsupportedMediaType{Msg: fmt.Sprintf("unsupported Content-Encoding %q", encoding)}
+	}
+	rc, err := decode(r, limits)
+	if err != nil {
+		return nil, errorx.BadRequest{Msg: encoding + " compression error", Err: err}
+	}
+	return rc, nil
+}
+
+// pooledReader is a decoder that goes back to its pool once closed.
+type pooledReader struct {
+	io.Reader
+	release func()
+}
+
+func (p *pooledReader) Close() error {
+	if p.release != nil {
+		p.release()
+		p.release = nil
+	}
+	return nil
+}
+
+var gzipReaders sync.Pool
+
+func decodeGzip(r io.Reader, _ bodyLimits) (io.ReadCloser, error) {
+	zr, _ := gzipReaders.Get().(*gzip.Reader)
+	if zr == nil {
+		var err error
+		if zr, err = gzip.NewReader(r); err != nil {
+			return nil, err
+		}
+	} else if err := zr.Reset(r); err != nil {
+		gzipReaders.Put(zr)
+		return nil, err
+	}
+	return &pooledReader{Reader: zr, release: func() { gzipReaders.Put(zr) }}, nil
+}
+
+var zstdDecoders sync.Pool
+
+func decodeZstd(r io.Reader, _ bodyLimits) (io.ReadCloser, error) {
+	dec, _ := zstdDecoders.Get().(*zstd.Decoder)
+	if dec == nil {
+		var err error
+		// A single goroutine per decoder, as requests are already decoded
+		// concurrently.
+		if dec, err = zstd.NewReader(nil, zstd.WithDecoderConcurrency(1), zstd.WithDecoderLowmem(true)); err != nil {
+			return nil, err
+		}
+	}
+	if err := dec.Reset(r); err != nil {
+		zstdDecoders.Put(dec)
+		return nil, err
+	}
+	return &pooledReader{Reader: dec, release: func() {
+		// Drop the reference to the body.
+		_ = dec.Reset(nil)
+		zstdDecoders.Put(dec)
+	}}, nil
+}
+
+var snappyReaders sync.Pool
+
+// decodeSnappy reads bodies in either the framed or the block snappy format.
+// Blocks can only be decoded whole, so they are read into memory first, and
+// the size they claim to decode to is checked before it is allocated.
+func decodeSnappy(r io.Reader, limits bodyLimits) (io.ReadCloser, error) {
+	br := bufio.NewReader(r)
+	magic, err := br.Peek(len(snappyStreamMagic))
+	if err == nil && bytes.Equal(magic, snappyStreamMagic) {
+		sr, _ := snappyReaders.Get().(*snappy.Reader)
+		if sr == nil {
+			sr = snappy.NewReader(br)
+		} else {
+			sr.Reset(br)
+		}
+		return &pooledReader{Reader: sr, release: func() {
+			sr.Reset(nil)
+			snappyReaders.Put(sr)
+		}}, nil
+	}
+
+	block, err := io.ReadAll(br)
+	if err != nil {
+		return nil, err
+	}
+	n, err := snappy.DecodedLen(block)
+	if err != nil {
+		return nil, err
+	}
+	if err := limits.checkDecodedLen(int64(len(block)), int64(n)); err != nil {
+		return nil, err
+	}
+	decoded, err := snappy.Decode(nil, block)
+	if err != nil {
+		return nil, err
+	}
+	return io.NopCloser(bytes.NewReader(decoded)), nil
+}
+
+var (
+	zlibReaders  sync.Pool
+	flateReaders sync.Pool
+)
+
+// decodeDeflate reads zlib streams, as the deflate encoding is defined, and
+// raw deflate streams, which some clients send instead.
+func decodeDeflate(r io.Reader, _ bodyLimits) (io.ReadCloser, error) {
+	br := bufio.NewReader(r)
+	header, err := br.Peek(2)
+	if err == nil && isZlibHeader(header) {
+		zr, _ := zlibReaders.Get().(io.ReadCloser)
+		if zr == nil {
+			if zr, err = zlib.NewReader(br); err != nil {
+				return nil, err
+			}
+		} else if err := zr.(zlib.Resetter).Reset(br, nil); err != nil {
+			zlibReaders.Put(zr)
+			return nil, err
+		}
+		return &pooledReader{Reader: zr, release: func() { zlibReaders.Put(zr) }}, nil
+	}
+
+	fr, _ := flateReaders.Get().(io.ReadCloser)
+	if fr == nil {
+		fr = flate.NewReader(br)
+	} else if err := fr.(flate.Resetter).Reset(br, nil); err != nil {
+		flateReaders.Put(fr)
+		return nil, err
+	}
+	return &pooledReader{Reader: fr, release: func() { flateReaders.Put(fr) }}, nil
+}
+
+// isZlibHeader tells whether b starts with a valid zlib header: the deflate
+// compression method and a checksum that is a multiple of 31.
+func isZlibHeader(b []byte) bool {
+	return b[0]&0x0f == 8 && (uint16(b[0])<<8|uint16(b[1]))%31 == 0
+}
+
+var brotliReaders sync.Pool
+
+func decodeBrotli(r io.Reader, _ bodyLimits) (io.ReadCloser, error) {
+	br, _ := brotliReaders.Get().(*brotli.Reader)
+	if br == nil {
+		br = brotli.NewReader(r)
+	} else if err := br.Reset(r); err != nil {
+		brotliReaders.Put(br)
+		return nil, err
+	}
+	return &pooledReader{Reader: br, release: func() { brotliReaders.Put(br) }}, nil
+}
@@ -0,0 +1,131 @@
+package influx
+
+import (
+	"bytes"
+	"compress/flate"
+	"compress/gzip"
+	"compress/zlib"
+	"encoding/binary"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/andybalholm/brotli"
+	"github.com/go-kit/log"
+	"github.com/golang/snappy"
+	"github.com/grafana/mimir-graphite/v2/pkg/errorx"
+	"github.com/grafana/mimir-graphite/v2/pkg/remotewrite/remotewritemock"
+	"github.com/klauspost/compress/zstd"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/mock"
+	"github.com/stretchr/testify/require"
+)
+
+func compress(t *testing.T, encoding, data string) []byte {
+	var buf bytes.Buffer
+	var w io.WriteCloser
+	switch encoding {
+	case "gzip":
+		w = gzip.NewWriter(&buf)
+	case "zstd":
+		var err error
+		w, err = zstd.NewWriter(&buf)
+		require.NoError(t, err)
+	case "snappy-framed":
+		w = snappy.NewBufferedWriter(&buf)
+	case "snappy-block":
+		return snappy.Encode(nil, []byte(data))
+	case "zlib":
+		w = zlib.NewWriter(&buf)
+	case "flate":
+		var err error
+		w, err = flate.NewWriter(&buf, flate.DefaultCompression)
+		require.NoError(t, err)
+	case "br":
+		w = brotli.NewWriter(&buf)
+	default:
+		return []byte(data)
+	}
+	_, err := w.Write([]byte(data))
+	require.NoError(t, err)
+	require.NoError(t, w.Close())
+	return buf.Bytes()
+}
+
+func TestNewDecoder(t *testing.T) {
+	data := "m,t1=v1 f1=2 1465839830100400200\nm,t1=v2 f1=3 1465839830100400200\n"
+	tests := []struct {
+		name     string
+		encoding string
+		format   string
+	}{
+		{name: "none", encoding: "", format: ""},
+		{name: "identity", encoding: "identity", format: ""},
+		{name: "gzip", encoding: "gzip", format: "gzip"},
+		{name: "x-gzip", encoding: "x-gzip", format: "gzip"},
+		{name: "zstd", encoding: "zstd", format: "zstd"},
+		{name: "framed snappy", encoding: "snappy", format: "snappy-framed"},
+		{name: "block snappy", encoding: "snappy", format: "snappy-block"},
+		{name: "deflate", encoding: "deflate", format: "zlib"},
+		{name: "raw deflate", encoding: "deflate", format: "flate"},
+		{name: "brotli", encoding: "br", format: "br"},
+		{name: "case insensitive", encoding: " ZSTD", format: "zstd"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			body := compress(t, tt.format, data)
+			// Decode twice to use pooled decoders.
+			for i := 0; i < 2; i++ {
+				rc, err := newDecoder(bytes.NewReader(body), tt.encoding, bodyLimits{})
+				require.NoError(t, err)
+				decoded, err := io.ReadAll(rc)
+				require.NoError(t, err)
+				require.NoError(t, rc.Close())
+				assert.Equal(t, data, string(decoded))
+			}
+		})
+	}
+}
+
+func TestNewDecoderErrors(t *testing.T) {
+	_, err := newDecoder(bytes.NewReader([]byte("m f=1")), "lz4", bodyLimits{})
+	assert.ErrorAs(t, err, &errorx.UnsupportedMediaType{})
+
+	_, err = newDecoder(bytes.NewReader([]byte("m f=1")), "gzip", bodyLimits{})
+	assert.ErrorAs(t, err, &errorx.BadRequest{})
+
+	_, err = newDecoder(bytes.NewReader(compress(t, "snappy-block", "m f=1 1465839830100400200")), "snappy", bodyLimits{maxDecompressedBytes: 10})
+	var tooLarge requestTooLargeError
+	require.ErrorAs(t, err, &tooLarge)
+	assert.Equal(t, tooLargeDecompressed, tooLarge.reason)
+
+	// A block only claiming to be large is rejected before anything is
+	// allocated, even without decompressed size limit.
+	block := binary.AppendUvarint(nil, 1<<30)
+	_, err = newDecoder(bytes.NewReader(block), "snappy", bodyLimits{})
+	require.ErrorAs(t, err, &tooLarge)
+	assert.Equal(t, requestTooLargeError{reason: tooLargeDecompressed, limit: DefaultMaxRequestSizeBytes}, tooLarge)
+
+	block = binary.AppendUvarint(nil, 2<<20)
+	_, err = newDecoder(bytes.NewReader(block), "snappy", bodyLimits{maxCompressionRatio: 100})
+	require.ErrorAs(t, err, &tooLarge)
+	assert.Equal(t, tooLargeCompressionRatio, tooLarge.reason)
+}
+
+func TestHandleSeriesPushUnsupportedEncoding(t *testing.T) {
+	req := httptest.NewRequest("POST", "/api/v1/push/influx/write", bytes.NewReader([]byte("m f=1")))
+	req.Header.Set("Content-Encoding", "lz4")
+	rec := httptest.NewRecorder()
+
+	recorderMock := &MockRecorder{}
+	recorderMock.On("measureProxyErrors", "errorx.UnsupportedMediaType").Return(nil)
+	api, err := NewAPI(ProxyConfig{Logger: log.NewNopLogger()}, &remotewritemock.Client{}, recorderMock)
+	require.NoError(t, err)
+
+	api.handleSeriesPush(rec, req)
+	assert.Equal(t, http.StatusUnsupportedMediaType, rec.Code)
+	assert.JSONEq(t, `{"code": "invalid", "message": "unsupported Content-Encoding \"lz4\""}`, rec.Body.String())
+	recorderMock.AssertCalled(t, "measureProxyErrors", mock.Anything)
+}
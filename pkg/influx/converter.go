@@ -0,0 +1,499 @@
+package influx
+
+import (
+	"errors"
+	"flag"
+	"fmt"
+	"sort"
+	"time"
+
+	"github.com/go-kit/log"
+	"github.com/go-kit/log/level"
+	"github.com/grafana/mimir-graphite/v2/pkg/errorx"
+	"github.com/grafana/mimir/pkg/mimirpb"
+	"github.com/grafana/mimir/pkg/util"
+	"github.com/influxdata/influxdb/v2/models"
+	"github.com/prometheus/prometheus/model/labels"
+)
+
+// Policies for the integer fields that a float64 can't represent exactly.
+const (
+	// LargeIntegersAccept converts them to the nearest float64.
+	LargeIntegersAccept = "accept"
+	// LargeIntegersReject rejects the line of the point.
+	LargeIntegersReject = "reject"
+	// LargeIntegersSplit writes the high and low 32 bits of the integer as two
+	// series with _high and _low suffixes, so that it can be computed exactly
+	// as high * 2^32 + low.
+	LargeIntegersSplit = "split"
+
+	// maxSafeInteger is the largest integer such that it and all the smaller
+	// ones are exactly represented by a float64.
+	maxSafeInteger = 1 << 53
+)
+
+var errInexactInteger = errors.New("integer can't be represented exactly")
+
+// ConversionConfig configures the conversion of Influx points to series.
+type ConversionConfig struct {
+	// StringFields configures the conversion of string fields.
+	StringFields StringFieldsConfig `yaml:"string_fields"`
+	// LargeIntegers is the policy for integer fields larger than 2^53 in
+	// absolute value: LargeIntegersAccept, LargeIntegersReject or
+	// LargeIntegersSplit. Empty means LargeIntegersAccept.
+	LargeIntegers string `yaml:"large_integers"`
+	// Naming configures how series are named.
+	Naming NamingConfig `yaml:"naming"`
+	// Metadata configures the metadata sent with the series.
+	Metadata MetadataConfig `yaml:"metadata"`
+	// RelabelConfigs are Prometheus relabeling rules applied to the series
+	// before they are written.
+	RelabelConfigs RelabelConfigs `yaml:"relabel_configs"`
+	// LabelCollisions is the policy for the tags and string fields whose label
+	// names collide once sanitized: LabelCollisionsFirstWins,
+	// LabelCollisionsSuffix or LabelCollisionsReject. Empty means
+	// LabelCollisionsFirstWins.
+	LabelCollisions string `yaml:"label_collisions"`
+	// Histograms is the format of the histograms and summaries sent by
+	// Telegraf: HistogramsNone, HistogramsClassic or HistogramsNative. Empty
+	// means HistogramsNone.
+	Histograms string `yaml:"histograms"`
+	// SourceLabel configures the label recording the protocol of the series.
+	SourceLabel SourceLabelConfig `yaml:"source_label"`
+	// ExternalLabels are added to every series.
+	ExternalLabels ExternalLabels `yaml:"external_labels"`
+	// HonorLabels keeps the tags and string field labels clashing with an
+	// external label, instead of renaming them with the exported_ prefix.
+	HonorLabels bool `yaml:"honor_labels"`
+	// Samples configures the validation of the timestamps and values of the
+	// samples.
+	Samples SamplesConfig `yaml:"samples"`
+	// Limits bounds the size of the requests and series.
+	Limits LimitsConfig `yaml:"limits"`
+}
+
+func (c *ConversionConfig) RegisterFlags(flags *flag.FlagSet) {
+	c.StringFields.RegisterFlags(flags)
+	c.Naming.RegisterFlags(flags)
+	c.Metadata.RegisterFlags(flags)
+	c.SourceLabel.RegisterFlags(flags)
+	c.Samples.RegisterFlags(flags)
+	c.Limits.RegisterFlags(flags)
+	flags.StringVar(&c.LargeIntegers, "large.integers", LargeIntegersAccept, fmt.Sprintf("what to do with integer fields larger than 2^53, which lose precision as floats: %s, %s the line, or %s them into _high and _low series", LargeIntegersAccept, LargeIntegersReject, LargeIntegersSplit))
+	flags.StringVar(&c.LabelCollisions, "label.collisions", LabelCollisionsFirstWins, fmt.Sprintf("what to do with tags whose label names collide once sanitized, such as a.b and a-b: keep the %s, add a %s to the others, or %s the line", LabelCollisionsFirstWins, LabelCollisionsSuffix, LabelCollisionsReject))
+	flags.StringVar(&c.Histograms, "histograms", HistogramsNone, fmt.Sprintf("how to write the histograms and summaries sent by Telegraf: %s, as unrelated series, or assembled into %s or %s histograms", HistogramsNone, HistogramsClassic, HistogramsNative))
+	flags.Var(&c.ExternalLabels, "external.label", "static label added to every series converted from Influx points, as name=value (can be repeated)")
+	flags.Var(&relabelConfigFile{configs: &c.RelabelConfigs}, "relabel.config-file", "YAML file with the Prometheus relabel_configs applied to every series, unless overridden")
+	flags.BoolVar(&c.HonorLabels, "honor.labels", false, "keep the tags clashing with an external label, like Prometheus' honor_labels, instead of renaming them with the exported_ prefix")
+}
+
+// Validate checks the configuration is usable.
+func (c ConversionConfig) Validate() error {
+	if err := c.StringFields.Validate(); err != nil {
+		return fmt.Errorf("invalid string fields config: %w", err)
+	}
+	switch c.LargeIntegers {
+	case "", LargeIntegersAccept, LargeIntegersReject, LargeIntegersSplit:
+	default:
+		return fmt.Errorf("invalid large integers policy %q", c.LargeIntegers)
+	}
+	if err := c.Naming.Validate(); err != nil {
+		return fmt.Errorf("invalid naming config: %w", err)
+	}
+	if err := c.Metadata.Validate(); err != nil {
+		return fmt.Errorf("invalid metadata config: %w", err)
+	}
+	// The fields of a measurement are a single metric family, which can't get
+	// the metadata of each of them.
+	if c.Naming.Scheme == NamingFieldLabel && (len(c.Metadata.Rules) > 0 || c.Metadata.TelegrafCatalog) {
+		return fmt.Errorf("metadata rules can't be used with the %s naming scheme", NamingFieldLabel)
+	}
+	switch c.LabelCollisions {
+	case "", LabelCollisionsFirstWins, LabelCollisionsSuffix, LabelCollisionsReject:
+	default:
+		return fmt.Errorf("invalid label collisions policy %q", c.LabelCollisions)
+	}
+	if err := c.RelabelConfigs.Validate(); err != nil {
+		return err
+	}
+	switch c.Histograms {
+	case "", HistogramsNone, HistogramsClassic, HistogramsNative:
+	default:
+		return fmt.Errorf("invalid histograms format %q", c.Histograms)
+	}
+	if err := c.SourceLabel.Validate(); err != nil {
+		return err
+	}
+	if err := c.ExternalLabels.Validate(c.SourceLabel); err != nil {
+		return err
+	}
+	if err := c.Samples.Validate(); err != nil {
+		return fmt.Errorf("invalid samples config: %w", err)
+	}
+	if err := c.Limits.Validate(); err != nil {
+		return fmt.Errorf("invalid limits config: %w", err)
+	}
+	return nil
+}
+
+// clone returns a copy of the configuration that doesn't share its maps, so
+// that overrides can be decoded into it.
+func (c ConversionConfig) clone() ConversionConfig {
+	if c.StringFields.Enums != nil {
+		enums := make(EnumMappings, len(c.StringFields.Enums))
+		for field, mapping := range c.StringFields.Enums {
+			enums[field] = mapping
+		}
+		c.StringFields.Enums = enums
+	}
+	if c.ExternalLabels != nil {
+		external := make(ExternalLabels, len(c.ExternalLabels))
+		for name, value := range c.ExternalLabels {
+			external[name] = value
+		}
+		c.ExternalLabels = external
+	}
+	return c
+}
+
+// invalidPointError is returned when a point is rejected by the conversion,
+// which only fails the line of the point.
+type invalidPointError struct {
+	err error
+}
+
+func (e invalidPointError) Error() string {
+	return e.err.Error()
+}
+
+func (e invalidPointError) Unwrap() error {
+	return e.err
+}
+
+// converter converts Influx points to series. It is shared by all the
+// endpoints and listeners, and is safe for concurrent use.
+type converter struct {
+	stringFields  *stringFieldsConverter
+	largeIntegers string
+	namer         namer
+	// sourceLabel has an empty name if it is disabled.
+	sourceLabel mimirpb.LabelAdapter
+	// escaping is recorded in the escapingLabel of the series, if set.
+	escaping        string
+	labelCollisions string
+	metadata        *metadataTracker
+	histograms      string
+	// relabelConfigs are validated with the config.
+	relabelConfigs RelabelConfigs
+	// externalLabels are sorted.
+	externalLabels []mimirpb.LabelAdapter
+	honorLabels    bool
+	samples        SamplesConfig
+	limits         LimitsConfig
+	logger         log.Logger
+	recorder       Recorder
+}
+
+func newConverter(cfg ConversionConfig, logger log.Logger, recorder Recorder) *converter {
+	return &converter{
+		stringFields:    newStringFieldsConverter(cfg.StringFields, recorder),
+		largeIntegers:   cfg.LargeIntegers,
+		namer:           newNamer(cfg.Naming),
+		sourceLabel:     cfg.SourceLabel.label(),
+		escaping:        nameEscaping(cfg.Naming),
+		labelCollisions: cfg.LabelCollisions,
+		metadata:        newMetadataTracker(cfg.Metadata),
+		histograms:      cfg.Histograms,
+		relabelConfigs:  cfg.RelabelConfigs,
+		externalLabels:  cfg.ExternalLabels.labels(),
+		honorLabels:     cfg.HonorLabels,
+		samples:         cfg.Samples,
+		limits:          cfg.Limits,
+		logger:          logger,
+		recorder:        recorder,
+	}
+}
+
+func (c *converter) writeRequestFromInfluxPoints(tenant string, points []models.Point, extraLabels []mimirpb.LabelAdapter) ([]mimirpb.TimeSeries, error) {
+	// A new series is created for each sample: the samples of the same series
+	// are merged when the series are written, by newWriteRequest.
+
+	now := time.Now()
+	returnTs := []mimirpb.TimeSeries{}
+	for _, pt := range points {
+		ts, err := c.influxPointToTimeseries(tenant, pt, extraLabels, now)
+		if err != nil {
+			return nil, err
+		}
+		returnTs = append(returnTs, ts...)
+	}
+
+	return returnTs, nil
+}
+
+// metadataDue returns the metadata of the series of ts converted by c that is
+// due to be sent for tenant.
+func (c *converter) metadataDue(tenant string, ts []mimirpb.TimeSeries) []*mimirpb.MetricMetadata {
+	return c.metadata.due(tenant, ts, time.Now())
+}
+
+// Points to Prometheus is heavily inspired from https://github.com/prometheus/influxdb_exporter/blob/a1dc16ad596a990d8854545ea39a57a99a3c7c43/main.go#L148-L211
+func (c *converter) influxPointToTimeseries(tenant string, pt models.Point, extraLabels []mimirpb.LabelAdapter, now time.Time) ([]mimirpb.TimeSeries, error) {
+	returnTs := []mimirpb.TimeSeries{}
+
+	fields, err := pt.Fields()
+	if err != nil {
+		return nil, errorx.Internal{Msg: "error getting fields from point", Err: err}
+	}
+	measurement := string(pt.Name())
+	timestampMs := util.TimeToMillis(pt.Time())
+	tagLabels, err := c.tagLabels(pt.Tags())
+	if err != nil {
+		return nil, err
+	}
+	fieldLabels, err := c.fieldLabels(tenant, measurement, tagLabels, fields, now)
+	if err != nil {
+		return nil, err
+	}
+
+	for field, v := range fields {
+		var value float64
+		// large is set for the integers that lose precision as floats, with
+		// their high and low 32 bits.
+		var large bool
+		var high, low int64
+		switch v := v.(type) {
+		case float64:
+			value = v
+		case int64:
+			value = float64(v)
+			if v > maxSafeInteger || v < -maxSafeInteger {
+				large, high, low = true, v>>32, v&0xffffffff
+			}
+		case uint64:
+			value = float64(v)
+			if v > maxSafeInteger {
+				large, high, low = true, int64(v>>32), int64(v&0xffffffff)
+			}
+		case bool:
+			if v {
+				value = 1
+			} else {
+				value = 0
+			}
+		case string:
+			n, isEnum, dropped := c.stringFields.enum(field, v)
+			if dropped {
+				continue
+			}
+			if !isEnum {
+				if c.stringFields.isLabel(field) && hasNumericField(fields) {
+					// Already added to the labels of the other series.
+					continue
+				}
+				name, fieldLabel, ok := c.namer.metricName(measurement, field)
+				if !ok {
+					continue
+				}
+				label, ok := c.namer.labelName(field)
+				if !ok {
+					continue
+				}
+				if name, ok := c.stringFields.infoName(tenant, name, tagLabels, field, v, now); ok {
+					returnTs = append(returnTs, mimirpb.TimeSeries{
+						Labels:  c.influxLabels(tagLabels, name, extraLabels, withFieldLabel([]mimirpb.LabelAdapter{{Name: label, Value: v}}, fieldLabel)),
+						Samples: []mimirpb.Sample{{TimestampMs: timestampMs, Value: 1}},
+					})
+				}
+				continue
+			}
+			value = n
+		default:
+			continue
+		}
+
+		name, fieldLabel, ok := c.namer.metricName(measurement, field)
+		if !ok {
+			continue
+		}
+		name = c.metadata.name(measurement, field, name)
+		seriesLabels := withFieldLabel(fieldLabels, fieldLabel)
+
+		if large {
+			_ = level.Debug(c.logger).Log("msg", "integer field larger than 2^53", "measurement", measurement, "field", field, "policy", c.largeIntegers)
+			switch c.largeIntegers {
+			case LargeIntegersReject:
+				c.recorder.measureLargeIntegers(LargeIntegersReject)
+				return nil, invalidPointError{fmt.Errorf("field %q: %w: %v", field, errInexactInteger, v)}
+			case LargeIntegersSplit:
+				c.recorder.measureLargeIntegers(LargeIntegersSplit)
+				lbls := c.influxLabels(tagLabels, name+"_high", extraLabels, seriesLabels)
+				returnTs = append(returnTs, mimirpb.TimeSeries{
+					Labels:  lbls,
+					Samples: []mimirpb.Sample{{TimestampMs: timestampMs, Value: float64(high)}},
+				})
+				returnTs = append(returnTs, mimirpb.TimeSeries{
+					Labels:  withMetricName(lbls, name+"_low"),
+					Samples: []mimirpb.Sample{{TimestampMs: timestampMs, Value: float64(low)}},
+				})
+				continue
+			default:
+				c.recorder.measureLargeIntegers(LargeIntegersAccept)
+			}
+		}
+
+		returnTs = append(returnTs, mimirpb.TimeSeries{
+			Labels: c.influxLabels(tagLabels, name, extraLabels, seriesLabels),
+			Samples: []mimirpb.Sample{{
+				TimestampMs: timestampMs,
+				Value:       value,
+			}},
+		})
+	}
+
+	for i := range returnTs {
+		if returnTs[i].Labels, err = c.limitLabels(returnTs[i].Labels); err != nil {
+			return nil, err
+		}
+	}
+	return returnTs, nil
+}
+
+// fieldLabels returns the labels of the string fields configured to be added
+// to the other series of their point, with the labels of tags, written for
+// tenant. Points without numeric fields have no other series, so their string
+// fields are handled like any other.
+func (c *converter) fieldLabels(tenant, measurement string, tagLabels []mimirpb.LabelAdapter, fields models.Fields, now time.Time) ([]mimirpb.LabelAdapter, error) {
+	if len(c.stringFields.labels) == 0 || !hasNumericField(fields) {
+		return nil, nil
+	}
+	// Sorted, so that label name collisions are resolved in the same order
+	// as for tags.
+	names := make([]string, 0, len(fields))
+	for field := range fields {
+		names = append(names, field)
+	}
+	sort.Strings(names)
+	var lbls []mimirpb.LabelAdapter
+	var keys []string
+	for _, field := range names {
+		s, ok := fields[field].(string)
+		if !ok || !c.stringFields.isLabel(field) {
+			continue
+		}
+		if _, isEnum := c.stringFields.cfg.Enums[field]; isEnum {
+			continue
+		}
+		if !c.stringFields.allow(tenant, measurement, tagLabels, field, s, now) {
+			continue
+		}
+		name, ok := c.namer.labelName(field)
+		if !ok {
+			continue
+		}
+		var err error
+		if lbls, keys, err = c.addLabel(lbls, keys, "field", field, name, s); err != nil {
+			return nil, err
+		}
+	}
+	return lbls, nil
+}
+
+// tagLabels returns the labels of tags, in the order of their keys. Tags named
+// like a reserved label are dropped.
+func (c *converter) tagLabels(tags models.Tags) ([]mimirpb.LabelAdapter, error) {
+	lbls := make([]mimirpb.LabelAdapter, 0, len(tags))
+	keys := make([]string, 0, len(tags))
+	for _, tag := range tags {
+		key := string(tag.Key)
+		if c.isReservedLabel(key) {
+			continue
+		}
+		name, ok := c.namer.labelName(key)
+		if !ok {
+			continue
+		}
+		var err error
+		if lbls, keys, err = c.addLabel(lbls, keys, "tag", key, name, string(tag.Value)); err != nil {
+			return nil, err
+		}
+	}
+	return lbls, nil
+}
+
+// hasNumericField reports whether fields has a field converted to a series
+// whatever the string fields configuration.
+func hasNumericField(fields models.Fields) bool {
+	for _, v := range fields {
+		switch v.(type) {
+		case float64, int64, uint64, bool:
+			return true
+		}
+	}
+	return false
+}
+
+// withFieldLabel returns the labels of the string fields of a series with the
+// label of its field added, if the naming scheme has one.
+func withFieldLabel(fieldLabels []mimirpb.LabelAdapter, fieldLabel mimirpb.LabelAdapter) []mimirpb.LabelAdapter {
+	if fieldLabel.Name == "" {
+		return fieldLabels
+	}
+	lbls := make([]mimirpb.LabelAdapter, 0, len(fieldLabels)+1)
+	lbls = append(lbls, fieldLabel)
+	for _, l := range fieldLabels {
+		if l.Name != fieldLabel.Name {
+			lbls = append(lbls, l)
+		}
+	}
+	return lbls
+}
+
+// influxLabels returns the sorted labels of the series called name of a point: its
+// tags, the extra labels, the labels of its fields and the external labels.
+// Extra labels take precedence over field labels, which take precedence over
+// tags.
+func (c *converter) influxLabels(tagLabels []mimirpb.LabelAdapter, name string, extraLabels, fieldLabels []mimirpb.LabelAdapter) []mimirpb.LabelAdapter {
+	lbls := make([]mimirpb.LabelAdapter, 0, len(tagLabels)+len(extraLabels)+len(fieldLabels)+len(c.externalLabels)+3) // Additional ones for __name__, the source label and the escaping label
+	lbls = append(lbls, mimirpb.LabelAdapter{
+		Name:  labels.MetricName,
+		Value: name,
+	})
+	if c.sourceLabel.Name != "" {
+		lbls = append(lbls, c.sourceLabel) // A label for tracking active series
+	}
+	if c.escaping != "" {
+		lbls = append(lbls, mimirpb.LabelAdapter{Name: escapingLabel, Value: c.escaping})
+	}
+	lbls = append(lbls, extraLabels...)
+	for _, l := range fieldLabels {
+		if c.isReservedLabel(l.Name) || hasLabel(extraLabels, l.Name) {
+			continue
+		}
+		lbls = append(lbls, l)
+	}
+	for _, l := range tagLabels {
+		if hasLabel(extraLabels, l.Name) || hasLabel(fieldLabels, l.Name) {
+			continue
+		}
+		lbls = append(lbls, l)
+	}
+	lbls = c.withExternalLabels(lbls)
+	sort.Slice(lbls, func(i, j int) bool {
+		return lbls[i].Name < lbls[j].Name
+	})
+	return lbls
+}
+
+// withMetricName returns a copy of the sorted labels lbls with the metric name
+// set to name.
+func withMetricName(lbls []mimirpb.LabelAdapter, name string) []mimirpb.LabelAdapter {
+	named := make([]mimirpb.LabelAdapter, len(lbls))
+	copy(named, lbls)
+	for i := range named {
+		if named[i].Name == labels.MetricName {
+			named[i].Value = name
+		}
+	}
+	return named
+}
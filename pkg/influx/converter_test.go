@@ -0,0 +1,148 @@
+package influx
+
+import (
+	"sort"
+	"testing"
+	"time"
+
+	"github.com/go-kit/log"
+	"github.com/grafana/mimir/pkg/mimirpb"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestLargeIntegers(t *testing.T) {
+	tests := []struct {
+		name        string
+		policy      string
+		data        string
+		expected    []mimirpb.TimeSeries
+		expectedErr error
+	}{
+		{
+			name: "unsigned integer",
+			data: `m,t1=v1 f1=42u 1465839830100400200`,
+			expected: []mimirpb.TimeSeries{{
+				Labels: []mimirpb.LabelAdapter{
+					{Name: "__name__", Value: "m_f1"},
+					{Name: "__proxy_source__", Value: "influx"},
+					{Name: "t1", Value: "v1"},
+				},
+				Samples: []mimirpb.Sample{{Value: 42, TimestampMs: 1465839830100}},
+			}},
+		},
+		{
+			name:   "safe integer",
+			policy: LargeIntegersReject,
+			data:   `m f1=9007199254740992i 1465839830100400200`,
+			expected: []mimirpb.TimeSeries{{
+				Labels: []mimirpb.LabelAdapter{
+					{Name: "__name__", Value: "m_f1"},
+					{Name: "__proxy_source__", Value: "influx"},
+				},
+				Samples: []mimirpb.Sample{{Value: 1 << 53, TimestampMs: 1465839830100}},
+			}},
+		},
+		{
+			name: "accept",
+			data: `m f1=18446744073709551615u 1465839830100400200`,
+			expected: []mimirpb.TimeSeries{{
+				Labels: []mimirpb.LabelAdapter{
+					{Name: "__name__", Value: "m_f1"},
+					{Name: "__proxy_source__", Value: "influx"},
+				},
+				Samples: []mimirpb.Sample{{Value: 18446744073709551615, TimestampMs: 1465839830100}},
+			}},
+		},
+		{
+			name:        "reject",
+			policy:      LargeIntegersReject,
+			data:        `m f1=9007199254740993i 1465839830100400200`,
+			expectedErr: errInexactInteger,
+		},
+		{
+			name:   "split unsigned",
+			policy: LargeIntegersSplit,
+			data:   `m,t1=v1 f1=18446744073709551615u 1465839830100400200`,
+			expected: []mimirpb.TimeSeries{
+				{
+					Labels: []mimirpb.LabelAdapter{
+						{Name: "__name__", Value: "m_f1_high"},
+						{Name: "__proxy_source__", Value: "influx"},
+						{Name: "t1", Value: "v1"},
+					},
+					Samples: []mimirpb.Sample{{Value: 4294967295, TimestampMs: 1465839830100}},
+				},
+				{
+					Labels: []mimirpb.LabelAdapter{
+						{Name: "__name__", Value: "m_f1_low"},
+						{Name: "__proxy_source__", Value: "influx"},
+						{Name: "t1", Value: "v1"},
+					},
+					Samples: []mimirpb.Sample{{Value: 4294967295, TimestampMs: 1465839830100}},
+				},
+			},
+		},
+		{
+			name:   "split negative",
+			policy: LargeIntegersSplit,
+			data:   `m value=-9007199254740993i 1465839830100400200`,
+			expected: []mimirpb.TimeSeries{
+				{
+					Labels: []mimirpb.LabelAdapter{
+						{Name: "__name__", Value: "m_high"},
+						{Name: "__proxy_source__", Value: "influx"},
+					},
+					Samples: []mimirpb.Sample{{Value: -2097153, TimestampMs: 1465839830100}},
+				},
+				{
+					Labels: []mimirpb.LabelAdapter{
+						{Name: "__name__", Value: "m_low"},
+						{Name: "__proxy_source__", Value: "influx"},
+					},
+					Samples: []mimirpb.Sample{{Value: 4294967295, TimestampMs: 1465839830100}},
+				},
+			},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			recorderMock := &MockRecorder{}
+			policy := tt.policy
+			if policy == "" {
+				policy = LargeIntegersAccept
+			}
+			recorderMock.On("measureLargeIntegers", policy).Return(nil)
+			conv := newConverter(ConversionConfig{LargeIntegers: tt.policy}, log.NewNopLogger(), recorderMock)
+
+			points, err := parsePointsWithPrecision([]byte(tt.data), time.Now(), "ns")
+			require.NoError(t, err)
+			ts, err := conv.writeRequestFromInfluxPoints("fake", points, nil)
+			if tt.expectedErr != nil {
+				assert.ErrorIs(t, err, tt.expectedErr)
+				assert.ErrorAs(t, err, &invalidPointError{})
+				recorderMock.AssertCalled(t, "measureLargeIntegers", policy)
+				return
+			}
+			require.NoError(t, err)
+
+			sort.Slice(ts, func(i, j int) bool {
+				return ts[i].String() < ts[j].String()
+			})
+			assert.Equal(t, tt.expected, ts)
+		})
+	}
+}
+
+func TestConversionConfigValidate(t *testing.T) {
+	assert.NoError(t, ConversionConfig{}.Validate())
+	assert.NoError(t, ConversionConfig{LargeIntegers: LargeIntegersSplit}.Validate())
+	assert.Error(t, ConversionConfig{LargeIntegers: "round"}.Validate())
+	assert.NoError(t, ConversionConfig{Histograms: HistogramsNative}.Validate())
+	assert.Error(t, ConversionConfig{Histograms: "exponential"}.Validate())
+	assert.Error(t, ConversionConfig{StringFields: StringFieldsConfig{Labels: []string{""}}}.Validate())
+	assert.NoError(t, ConversionConfig{Naming: NamingConfig{Scheme: NamingFieldLabel, FieldLabel: "field"}}.Validate())
+	assert.Error(t, ConversionConfig{Naming: NamingConfig{Scheme: NamingFieldLabel, FieldLabel: "field"}, Metadata: MetadataConfig{Rules: []MetadataRule{{Type: "gauge"}}}}.Validate())
+	assert.Error(t, ConversionConfig{Naming: NamingConfig{Scheme: NamingFieldLabel, FieldLabel: "field"}, Metadata: MetadataConfig{TelegrafCatalog: true}}.Validate())
+}
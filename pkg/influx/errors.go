@@ -6,6 +6,7 @@ import (
 	"fmt"
 	"net"
 	"net/http"
+	"strings"
 
 	"github.com/go-kit/log"
 	"github.com/go-kit/log/level"
@@ -33,6 +34,10 @@ const (
 	ETooLarge            = "request too large"
 )
 
+// maxReportedLineErrors limits the number of invalid lines described in a
+// response.
+const maxReportedLineErrors = 10
+
 func errorxToInfluxErrorCode(err errorx.Error) string {
 	switch {
 	case errors.As(err, &errorx.BadRequest{}):
@@ -41,6 +46,8 @@ func errorxToInfluxErrorCode(err errorx.Error) string {
 		return EInternal
 	case errors.As(err, &errorx.Conflict{}):
 		return EConflict
+	case errors.As(err, &errorx.UnsupportedMediaType{}):
+		return EInvalid
 	}
 	return EInternal
 }
@@ -58,11 +65,47 @@ func tryUnwrap(err error) error {
 // this function. All client errors should be categorized as an errorx at the
 // site where they are thrown.
 func (a *API) handleError(w http.ResponseWriter, r *http.Request, err error, logger log.Logger) {
+	var linesErr invalidLinesError
+	if errors.As(err, &linesErr) {
+		a.handleLineErrors(w, linesErr.lineErrs, logger)
+		return
+	}
+	statusCode, errorCode, httpErrString := a.classifyError(r, err, logger)
+	a.writeErrorResponse(w, statusCode, struct {
+		Code    string `json:"code"`
+		Message string `json:"message"`
+	}{
+		Code:    errorCode,
+		Message: httpErrString,
+	}, logger)
+}
+
+// classifyError returns the http response code, the Influx error code and the
+// message for the given error, logging it and measuring it as it goes.
+func (a *API) classifyError(r *http.Request, err error, logger log.Logger) (int, string, string) {
+	var partial partialWriteError
+	isPartial := errors.As(err, &partial)
+
 	var statusCode int
 	var httpErrString string
+	var tooLarge requestTooLargeError
+	var limitErr limitError
 	var errx errorx.Error
 	errorCode := EInternal
 	switch {
+	case errors.As(err, &tooLarge):
+		// errorx has no 413 error, and size limits can be hit while reading the
+		// body at any point of the request, wrapped in other errors.
+		httpErrString = tooLarge.Error()
+		statusCode = http.StatusRequestEntityTooLarge
+		errorCode = ETooLarge
+		a.recorder.measureRequestTooLarge(tooLarge.reason)
+		err = tooLarge
+	case errors.As(err, &limitErr):
+		// Lines exceeding a limit with LimitsRejectRequest stop the request.
+		httpErrString = limitErr.Error()
+		statusCode, errorCode = limitErr.status()
+		err = limitErr
 	case errors.As(err, &errx):
 		errorCode = errorxToInfluxErrorCode(errx)
 		httpErrString = errx.Message()
@@ -94,6 +137,9 @@ func (a *API) handleError(w http.ResponseWriter, r *http.Request, err error, log
 		httpErrString = "uncategorized error"
 		statusCode = http.StatusInternalServerError
 	}
+	if isPartial {
+		httpErrString = fmt.Sprintf("partial write: %s (%d series written)", httpErrString, partial.written)
+	}
 	if statusCode < 500 {
 		_ = level.Info(logger).Log("msg", httpErrString, "response_code", statusCode, "err", tryUnwrap(err))
 	} else if statusCode >= 500 {
@@ -101,15 +147,78 @@ func (a *API) handleError(w http.ResponseWriter, r *http.Request, err error, log
 	}
 	a.recorder.measureProxyErrors(fmt.Sprintf("%T", err))
 
-	w.Header().Set("Content-Type", "application/json; charset=utf-8")
-	w.WriteHeader(statusCode)
-	e := struct {
+	return statusCode, errorCode, httpErrString
+}
+
+// handleLineErrors responds to a request of which some lines could not be
+// parsed, once the valid ones were written. The response has the shape of the
+// InfluxDB 2.x ones, with the number of the first invalid line, and its message
+// follows the "partial write:" convention of InfluxDB 1.x.
+func (a *API) handleLineErrors(w http.ResponseWriter, lineErrs lineErrors, logger log.Logger) {
+	msg := lineErrorsMessage(lineErrs)
+	statusCode := http.StatusBadRequest
+	_ = level.Info(logger).Log("msg", "invalid lines", "response_code", statusCode, "lines", lineErrs.count(), "err", lineErrs.errs[0].err)
+	a.writeErrorResponse(w, statusCode, struct {
 		Code    string `json:"code"`
 		Message string `json:"message"`
+		Line    int    `json:"line"`
 	}{
-		Code:    errorCode,
-		Message: httpErrString,
+		Code:    EInvalid,
+		Message: msg,
+		Line:    lineErrs.errs[0].lineNum,
+	}, logger)
+}
+
+// lineErrorsMessage describes the first maxReportedLineErrors line errors, like
+// InfluxDB 1.x does for partial writes.
+func lineErrorsMessage(lineErrs lineErrors) string {
+	var sb strings.Builder
+	sb.WriteString("partial write: ")
+	for i, le := range lineErrs.errs {
+		if i == maxReportedLineErrors {
+			break
+		}
+		if i > 0 {
+			sb.WriteString("\n")
+		}
+		fmt.Fprintf(&sb, "%v (line %d)", le.err, le.lineNum)
 	}
+	count := lineErrs.count()
+	if count > maxReportedLineErrors {
+		fmt.Fprintf(&sb, "\n... and %d more invalid lines", count-maxReportedLineErrors)
+	}
+	fmt.Fprintf(&sb, " dropped=%d", count)
+	return sb.String()
+}
+
+// lineErrorReason returns the reason the invalid lines failing with err, or the
+// listener messages dropped because of it, are measured with, so that the
+// lines rejected by a policy can be told from malformed ones.
+func lineErrorReason(err error) string {
+	switch {
+	case errors.Is(err, errLineTooLong):
+		return "line_too_long"
+	case errors.Is(err, errInexactInteger):
+		return "large_integer"
+	case errors.Is(err, errLabelCollision):
+		return "label_collision"
+	case errors.As(err, &limitError{}):
+		return "limit_exceeded"
+	}
+	return "parse_error"
+}
+
+// measureLineErrors counts the lines that could not be parsed by reason.
+func (a *API) measureLineErrors(lineErrs lineErrors) {
+	for reason, count := range lineErrs.reasons {
+		a.recorder.measureFailedLines(reason, count)
+	}
+}
+
+// writeErrorResponse writes the JSON encoded body e as the response.
+func (a *API) writeErrorResponse(w http.ResponseWriter, statusCode int, e interface{}, logger log.Logger) {
+	w.Header().Set("Content-Type", "application/json; charset=utf-8")
+	w.WriteHeader(statusCode)
 	b, err := json.Marshal(e)
 	if err != nil {
 		_ = level.Warn(logger).Log("msg", "failed to marshal error response", "err", err)
@@ -122,6 +231,33 @@ func (a *API) handleError(w http.ResponseWriter, r *http.Request, err error, log
 	}
 }
 
+// partialWriteError is returned when a request failed after some of its
+// series were written.
+type partialWriteError struct {
+	written int
+	err     error
+}
+
+func (e partialWriteError) Error() string {
+	return fmt.Sprintf("partial write: %d series written: %v", e.written, e.err)
+}
+
+func (e partialWriteError) Unwrap() error {
+	return e.err
+}
+
+// invalidLinesError is returned when some lines of a request are invalid.
+type invalidLinesError struct {
+	lineErrs lineErrors
+	// rejected tells that no series of the request were written because of
+	// them, rather than only the valid lines.
+	rejected bool
+}
+
+func (e invalidLinesError) Error() string {
+	return lineErrorsMessage(e.lineErrs)
+}
+
 func isNetworkTimeout(err error) bool {
 	if err == nil {
 		return false
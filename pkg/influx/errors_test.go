@@ -143,3 +143,44 @@ type mockReader struct {
 func (r *mockReader) Read(_ []byte) (int, error) {
 	return 0, r.err
 }
+
+func TestLineErrorsMessage(t *testing.T) {
+	var lineErrs lineErrors
+	lineErrs.add(lineError{lineNum: 2, err: fmt.Errorf("unable to parse 'm f=': missing field value")})
+	lineErrs.add(lineError{lineNum: 5, err: fmt.Errorf("%w: more than 10 bytes", errLineTooLong)})
+	require.Equal(t, "partial write: unable to parse 'm f=': missing field value (line 2)\nline too long: more than 10 bytes (line 5) dropped=2", lineErrorsMessage(lineErrs))
+
+	lineErrs = lineErrors{}
+	for i := 1; i <= maxReportedLineErrors+3; i++ {
+		lineErrs.add(lineError{lineNum: i, err: fmt.Errorf("invalid")})
+	}
+	msg := lineErrorsMessage(lineErrs)
+	require.Equal(t, maxReportedLineErrors, strings.Count(msg, "invalid (line"))
+	require.True(t, strings.HasSuffix(msg, "\n... and 3 more invalid lines dropped=13"), msg)
+}
+
+func TestLineErrorsKept(t *testing.T) {
+	var lineErrs lineErrors
+	for i := 1; i <= maxKeptLineErrors+10; i++ {
+		lineErrs.add(lineError{lineNum: i, err: fmt.Errorf("invalid")})
+	}
+	lineErrs.add(lineError{lineNum: maxKeptLineErrors + 11, err: errLineTooLong})
+
+	// The lines beyond the kept ones are still counted.
+	require.Len(t, lineErrs.errs, maxKeptLineErrors)
+	require.Equal(t, maxKeptLineErrors+11, lineErrs.count())
+	require.Equal(t, map[string]int{"parse_error": maxKeptLineErrors + 10, "line_too_long": 1}, lineErrs.reasons)
+	require.True(t, strings.HasSuffix(lineErrorsMessage(lineErrs), fmt.Sprintf("... and %d more invalid lines dropped=%d", maxKeptLineErrors+1, maxKeptLineErrors+11)))
+}
+
+func TestLineErrorReason(t *testing.T) {
+	for err, reason := range map[error]string{
+		fmt.Errorf("unable to parse 'm f=': missing field value"):             "parse_error",
+		fmt.Errorf("%w: more than 10 bytes", errLineTooLong):                  "line_too_long",
+		invalidPointError{fmt.Errorf("field %q: %w", "f", errInexactInteger)}: "large_integer",
+		invalidPointError{fmt.Errorf("tag %q: %w", "a.b", errLabelCollision)}: "label_collision",
+		invalidPointError{limitError{limit: "series_per_request", max: 1}}:    "limit_exceeded",
+	} {
+		require.Equal(t, reason, lineErrorReason(err), err.Error())
+	}
+}
@@ -0,0 +1,175 @@
+package influx
+
+import (
+	"errors"
+	"flag"
+	"fmt"
+	"sort"
+	"strings"
+
+	"github.com/go-kit/log/level"
+	"github.com/grafana/mimir/pkg/mimirpb"
+	"github.com/prometheus/common/model"
+	"github.com/prometheus/prometheus/model/labels"
+)
+
+const (
+	// defaultSourceValue is the value of the source label of the series
+	// converted from Influx points.
+	defaultSourceValue = "influx"
+
+	// exportedLabelPrefix is prepended to the labels of a point clashing
+	// with an external label, unless the point's labels are honored.
+	exportedLabelPrefix = "exported_"
+)
+
+// SourceLabelConfig configures the label recording the protocol of the series
+// converted from Influx points, which is used for tracking active series.
+type SourceLabelConfig struct {
+	// Name is the name of the label. Empty means __proxy_source__.
+	Name string `yaml:"name"`
+	// Value is the value of the label. Empty means influx.
+	Value string `yaml:"value"`
+	// Disabled removes the label.
+	Disabled bool `yaml:"disabled"`
+}
+
+func (c *SourceLabelConfig) RegisterFlags(flags *flag.FlagSet) {
+	flags.StringVar(&c.Name, "source.label.name", internalLabel, "name of the label recording the protocol of the series converted from Influx points")
+	flags.StringVar(&c.Value, "source.label.value", defaultSourceValue, "value of the label recording the protocol of the series converted from Influx points")
+	flags.BoolVar(&c.Disabled, "source.label.disabled", false, "don't add the label recording the protocol to the series converted from Influx points")
+}
+
+// Validate checks the configuration is usable.
+func (c SourceLabelConfig) Validate() error {
+	if c.Disabled || c.Name == "" {
+		return nil
+	}
+	if !model.LabelName(c.Name).IsValidLegacy() {
+		return fmt.Errorf("invalid source label name %q", c.Name)
+	}
+	if c.Name == labels.MetricName || c.Name == escapingLabel {
+		return fmt.Errorf("source label name %q is reserved", c.Name)
+	}
+	return nil
+}
+
+// label returns the source label, or an empty label if it is disabled.
+func (c SourceLabelConfig) label() mimirpb.LabelAdapter {
+	if c.Disabled {
+		return mimirpb.LabelAdapter{}
+	}
+	l := mimirpb.LabelAdapter{Name: c.Name, Value: c.Value}
+	if l.Name == "" {
+		l.Name = internalLabel
+	}
+	if l.Value == "" {
+		l.Value = defaultSourceValue
+	}
+	return l
+}
+
+// protocolSourceLabel returns the source label of the series received with
+// another protocol than Influx line protocol, whose value is the protocol, or
+// an empty label if it is disabled.
+func (c *converter) protocolSourceLabel(protocol string) mimirpb.LabelAdapter {
+	if c.sourceLabel.Name == "" {
+		return mimirpb.LabelAdapter{}
+	}
+	return mimirpb.LabelAdapter{Name: c.sourceLabel.Name, Value: protocol}
+}
+
+// ExternalLabels are static labels added to every series converted from Influx
+// points. It is a flag.Value set by repeated name=value flags. In overrides,
+// the external labels of a tenant are merged with those of the proxy, and an
+// empty value removes a label of the proxy.
+type ExternalLabels map[string]string
+
+func (e ExternalLabels) String() string {
+	lbls := make([]string, 0, len(e))
+	for name, value := range e {
+		lbls = append(lbls, name+"="+value)
+	}
+	sort.Strings(lbls)
+	return strings.Join(lbls, ",")
+}
+
+func (e *ExternalLabels) Set(s string) error {
+	name, value, ok := strings.Cut(s, "=")
+	if !ok || name == "" {
+		return fmt.Errorf("invalid external label %q (use name=value)", s)
+	}
+	if *e == nil {
+		*e = ExternalLabels{}
+	}
+	(*e)[name] = value
+	return nil
+}
+
+// Validate checks the external labels are usable with the source label
+// source.
+func (e ExternalLabels) Validate(source SourceLabelConfig) error {
+	reserved := source.label().Name
+	for name := range e {
+		if name == "" {
+			return errors.New("empty external label name")
+		}
+		if !model.LabelName(name).IsValidLegacy() {
+			return fmt.Errorf("invalid external label name %q", name)
+		}
+		if name == labels.MetricName || name == escapingLabel || name == reserved {
+			return fmt.Errorf("external label name %q is reserved", name)
+		}
+	}
+	return nil
+}
+
+// labels returns the sorted external labels, without those removed by an
+// empty value.
+func (e ExternalLabels) labels() []mimirpb.LabelAdapter {
+	lbls := make([]mimirpb.LabelAdapter, 0, len(e))
+	for name, value := range e {
+		if value != "" {
+			lbls = append(lbls, mimirpb.LabelAdapter{Name: name, Value: value})
+		}
+	}
+	sort.Slice(lbls, func(i, j int) bool {
+		return lbls[i].Name < lbls[j].Name
+	})
+	return lbls
+}
+
+// withExternalLabels returns the labels lbls of a point with the external
+// labels added. Like Prometheus' honor_labels, the labels of the point clashing
+// with an external label are kept if they are honored, and renamed with the
+// exported_ prefix otherwise.
+func (c *converter) withExternalLabels(lbls []mimirpb.LabelAdapter) []mimirpb.LabelAdapter {
+	for _, ext := range c.externalLabels {
+		i := labelIndex(lbls, ext.Name)
+		if i < 0 {
+			lbls = append(lbls, ext)
+			continue
+		}
+		_ = level.Debug(c.logger).Log("msg", "label clashing with external label", "label", ext.Name, "honor_labels", c.honorLabels)
+		if c.honorLabels {
+			continue
+		}
+		exported := exportedLabelPrefix + ext.Name
+		for hasLabel(lbls, exported) {
+			exported = exportedLabelPrefix + exported
+		}
+		lbls[i].Name = exported
+		lbls = append(lbls, ext)
+	}
+	return lbls
+}
+
+// labelIndex returns the index of the label called name in lbls, or -1.
+func labelIndex(lbls []mimirpb.LabelAdapter, name string) int {
+	for i, l := range lbls {
+		if l.Name == name {
+			return i
+		}
+	}
+	return -1
+}
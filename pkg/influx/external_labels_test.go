@@ -0,0 +1,236 @@
+package influx
+
+import (
+	"flag"
+	"testing"
+	"time"
+
+	"collectd.org/api"
+	"github.com/go-kit/log"
+	"github.com/grafana/mimir/pkg/mimirpb"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestExternalLabels(t *testing.T) {
+	const data = `cpu,host=a,region=eu,exported_region=x,__proxy_source__=y usage=1 1465839830100400200`
+	tests := []struct {
+		name     string
+		cfg      ConversionConfig
+		expected []mimirpb.LabelAdapter
+	}{
+		{
+			name: "no external labels",
+			expected: []mimirpb.LabelAdapter{
+				{Name: "__name__", Value: "cpu_usage"},
+				{Name: "__proxy_source__", Value: "influx"},
+				{Name: "exported_region", Value: "x"},
+				{Name: "host", Value: "a"},
+				{Name: "region", Value: "eu"},
+			},
+		},
+		{
+			name: "external labels win",
+			cfg:  ConversionConfig{ExternalLabels: ExternalLabels{"region": "us", "cluster": "prod"}},
+			expected: []mimirpb.LabelAdapter{
+				{Name: "__name__", Value: "cpu_usage"},
+				{Name: "__proxy_source__", Value: "influx"},
+				{Name: "cluster", Value: "prod"},
+				{Name: "exported_exported_region", Value: "eu"},
+				{Name: "exported_region", Value: "x"},
+				{Name: "host", Value: "a"},
+				{Name: "region", Value: "us"},
+			},
+		},
+		{
+			name: "honor labels",
+			cfg:  ConversionConfig{ExternalLabels: ExternalLabels{"region": "us", "cluster": "prod"}, HonorLabels: true},
+			expected: []mimirpb.LabelAdapter{
+				{Name: "__name__", Value: "cpu_usage"},
+				{Name: "__proxy_source__", Value: "influx"},
+				{Name: "cluster", Value: "prod"},
+				{Name: "exported_region", Value: "x"},
+				{Name: "host", Value: "a"},
+				{Name: "region", Value: "eu"},
+			},
+		},
+		{
+			name: "removed external label",
+			cfg:  ConversionConfig{ExternalLabels: ExternalLabels{"region": ""}},
+			expected: []mimirpb.LabelAdapter{
+				{Name: "__name__", Value: "cpu_usage"},
+				{Name: "__proxy_source__", Value: "influx"},
+				{Name: "exported_region", Value: "x"},
+				{Name: "host", Value: "a"},
+				{Name: "region", Value: "eu"},
+			},
+		},
+		{
+			name: "renamed source label",
+			cfg:  ConversionConfig{SourceLabel: SourceLabelConfig{Name: "source", Value: "telegraf"}},
+			expected: []mimirpb.LabelAdapter{
+				{Name: "__name__", Value: "cpu_usage"},
+				{Name: "__proxy_source__", Value: "y"},
+				{Name: "exported_region", Value: "x"},
+				{Name: "host", Value: "a"},
+				{Name: "region", Value: "eu"},
+				{Name: "source", Value: "telegraf"},
+			},
+		},
+		{
+			name: "disabled source label",
+			cfg:  ConversionConfig{SourceLabel: SourceLabelConfig{Disabled: true}},
+			expected: []mimirpb.LabelAdapter{
+				{Name: "__name__", Value: "cpu_usage"},
+				{Name: "__proxy_source__", Value: "y"},
+				{Name: "exported_region", Value: "x"},
+				{Name: "host", Value: "a"},
+				{Name: "region", Value: "eu"},
+			},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			conv := newConverter(tt.cfg, log.NewNopLogger(), &MockRecorder{})
+
+			points, err := parsePointsWithPrecision([]byte(data), time.Now(), "ns")
+			require.NoError(t, err)
+			ts, err := conv.writeRequestFromInfluxPoints("fake", points, nil)
+			require.NoError(t, err)
+			require.Len(t, ts, 1)
+			assert.Equal(t, tt.expected, ts[0].Labels)
+		})
+	}
+}
+
+func TestExternalLabelsOverrides(t *testing.T) {
+	var cfg ConversionConfig
+	flags := flag.NewFlagSet("test", flag.PanicOnError)
+	cfg.RegisterFlags(flags)
+	require.NoError(t, flags.Parse([]string{"-external.label=cluster=prod", "-external.label=region=eu"}))
+	assert.Equal(t, ExternalLabels{"cluster": "prod", "region": "eu"}, cfg.ExternalLabels)
+
+	convs, err := newConverters(cfg, mustLoadOverrides(t, `
+tenants:
+  team-a:
+    external_labels:
+      region: us
+      team: a
+  team-b:
+    external_labels:
+      region: ""
+    source_label:
+      disabled: true
+`), log.NewNopLogger(), &MockRecorder{})
+	require.NoError(t, err)
+
+	tests := map[string][]mimirpb.LabelAdapter{
+		"other": {
+			{Name: "__name__", Value: "m"},
+			{Name: "__proxy_source__", Value: "influx"},
+			{Name: "cluster", Value: "prod"},
+			{Name: "region", Value: "eu"},
+		},
+		"team-a": {
+			{Name: "__name__", Value: "m"},
+			{Name: "__proxy_source__", Value: "influx"},
+			{Name: "cluster", Value: "prod"},
+			{Name: "region", Value: "us"},
+			{Name: "team", Value: "a"},
+		},
+		"team-b": {
+			{Name: "__name__", Value: "m"},
+			{Name: "cluster", Value: "prod"},
+		},
+	}
+	for tenant, expected := range tests {
+		points, err := parsePointsWithPrecision([]byte(`m value=1 1000000000`), time.Now(), "ns")
+		require.NoError(t, err)
+		ts, err := convs.get(endpointPush, tenant).writeRequestFromInfluxPoints("fake", points, nil)
+		require.NoError(t, err)
+		require.Len(t, ts, 1)
+		assert.Equal(t, expected, ts[0].Labels, tenant)
+	}
+	assert.Equal(t, ExternalLabels{"cluster": "prod", "region": "eu"}, cfg.ExternalLabels)
+}
+
+func TestExternalLabelsOtherProtocols(t *testing.T) {
+	tests := []struct {
+		name             string
+		cfg              ConversionConfig
+		expectedOpenTSDB []mimirpb.LabelAdapter
+		expectedCollectd []mimirpb.LabelAdapter
+	}{
+		{
+			name: "exported",
+			cfg: ConversionConfig{
+				SourceLabel:    SourceLabelConfig{Name: "source"},
+				ExternalLabels: ExternalLabels{"host": "proxy", "region": "eu"},
+			},
+			expectedOpenTSDB: []mimirpb.LabelAdapter{
+				{Name: "__name__", Value: "sys_cpu_user"},
+				{Name: "exported_host", Value: "web01"},
+				{Name: "host", Value: "proxy"},
+				{Name: "region", Value: "eu"},
+				{Name: "source", Value: "opentsdb"},
+			},
+			expectedCollectd: []mimirpb.LabelAdapter{
+				{Name: "__name__", Value: "memory_value"},
+				{Name: "exported_host", Value: "web01"},
+				{Name: "host", Value: "proxy"},
+				{Name: "region", Value: "eu"},
+				{Name: "source", Value: "collectd"},
+				{Name: "type", Value: "memory"},
+			},
+		},
+		{
+			name: "honored without source label",
+			cfg: ConversionConfig{
+				SourceLabel:    SourceLabelConfig{Disabled: true},
+				ExternalLabels: ExternalLabels{"host": "proxy"},
+				HonorLabels:    true,
+			},
+			expectedOpenTSDB: []mimirpb.LabelAdapter{
+				{Name: "__name__", Value: "sys_cpu_user"},
+				{Name: "host", Value: "web01"},
+			},
+			expectedCollectd: []mimirpb.LabelAdapter{
+				{Name: "__name__", Value: "memory_value"},
+				{Name: "host", Value: "web01"},
+				{Name: "type", Value: "memory"},
+			},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			conv := newConverter(tt.cfg, log.NewNopLogger(), &MockRecorder{})
+
+			ts, err := conv.parseOpenTSDBPut("put sys.cpu.user 1465839830 1 host=web01")
+			require.NoError(t, err)
+			assert.Equal(t, tt.expectedOpenTSDB, ts.Labels)
+
+			collectdTs, err := conv.collectdValueListToTimeseries(&api.ValueList{
+				Identifier: api.Identifier{Host: "web01", Plugin: "memory", Type: "memory"},
+				Values:     []api.Value{api.Gauge(1)},
+			}, time.Now())
+			require.NoError(t, err)
+			require.Len(t, collectdTs, 1)
+			assert.Equal(t, tt.expectedCollectd, collectdTs[0].Labels)
+		})
+	}
+}
+
+func TestValidateExternalLabels(t *testing.T) {
+	assert.NoError(t, ConversionConfig{SourceLabel: SourceLabelConfig{Name: "source"}, ExternalLabels: ExternalLabels{"__proxy_source__": "a"}}.Validate())
+	assert.NoError(t, ConversionConfig{SourceLabel: SourceLabelConfig{Name: "a.b", Disabled: true}}.Validate())
+	assert.Error(t, ConversionConfig{SourceLabel: SourceLabelConfig{Name: "a.b"}}.Validate())
+	assert.Error(t, ConversionConfig{SourceLabel: SourceLabelConfig{Name: "__name__"}}.Validate())
+	assert.Error(t, ConversionConfig{ExternalLabels: ExternalLabels{"__proxy_source__": "a"}}.Validate())
+	assert.Error(t, ConversionConfig{ExternalLabels: ExternalLabels{"a-b": "a"}}.Validate())
+
+	var e ExternalLabels
+	assert.Error(t, e.Set("region"))
+	assert.Error(t, e.Set("=eu"))
+}
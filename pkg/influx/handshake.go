@@ -0,0 +1,82 @@
+package influx
+
+import (
+	"encoding/json"
+	"net/http"
+
+	"github.com/go-kit/log/level"
+)
+
+const (
+	// DefaultInfluxVersion is the InfluxDB version reported to clients. It
+	// matches the version of the line protocol parser the proxy uses.
+	DefaultInfluxVersion = "v2.7.12"
+	influxBuild          = "OSS"
+)
+
+// withVersionHeaders sets the headers InfluxDB adds to every response, which
+// some clients use to detect the server they talk to.
+func (a *API) withVersionHeaders(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("X-Influxdb-Build", influxBuild)
+		w.Header().Set("X-Influxdb-Version", a.influxVersion)
+		next.ServeHTTP(w, r)
+	})
+}
+
+// handlePing answers the /ping and /api/v2/ping probes. Like InfluxDB 1.x, it
+// returns the version in a JSON body when the verbose parameter is set.
+func (a *API) handlePing(w http.ResponseWriter, r *http.Request) {
+	if verbose := r.URL.Query().Get("verbose"); verbose == "" || verbose == "false" || r.Method == http.MethodHead {
+		w.WriteHeader(http.StatusNoContent)
+		return
+	}
+	a.writeJSON(w, r, http.StatusOK, struct {
+		Version string `json:"version"`
+	}{
+		Version: a.influxVersion,
+	})
+}
+
+// handleInfluxHealth answers the /health probe with the health document of
+// InfluxDB 2.x.
+func (a *API) handleInfluxHealth(w http.ResponseWriter, r *http.Request) {
+	a.writeJSON(w, r, http.StatusOK, struct {
+		Name    string   `json:"name"`
+		Message string   `json:"message"`
+		Status  string   `json:"status"`
+		Checks  []string `json:"checks"`
+		Version string   `json:"version"`
+		Commit  string   `json:"commit"`
+	}{
+		Name:    "influxdb",
+		Message: "ready for queries and writes",
+		Status:  "pass",
+		Checks:  []string{},
+		Version: a.influxVersion,
+		Commit:  DockerTag,
+	})
+}
+
+// handleSetup answers the /api/v2/setup probe, telling clients the instance
+// is already set up so that they don't try to onboard it.
+func (a *API) handleSetup(w http.ResponseWriter, r *http.Request) {
+	a.writeJSON(w, r, http.StatusOK, struct {
+		Allowed bool `json:"allowed"`
+	}{
+		Allowed: false,
+	})
+}
+
+func (a *API) writeJSON(w http.ResponseWriter, r *http.Request, statusCode int, v interface{}) {
+	b, err := json.Marshal(v)
+	if err != nil {
+		a.handleError(w, r, err, withRequestInfo(a.logger, r))
+		return
+	}
+	w.Header().Set("Content-Type", "application/json; charset=utf-8")
+	w.WriteHeader(statusCode)
+	if _, err := w.Write(b); err != nil {
+		_ = level.Warn(withRequestInfo(a.logger, r)).Log("msg", "failed to write response", "err", err)
+	}
+}
@@ -0,0 +1,123 @@
+package influx
+
+import (
+	"bytes"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/go-kit/log"
+	"github.com/gorilla/mux"
+	"github.com/grafana/mimir-graphite/v2/pkg/remotewrite/remotewritemock"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/mock"
+	"github.com/stretchr/testify/require"
+)
+
+func TestHandshakeEndpoints(t *testing.T) {
+	tests := []struct {
+		name           string
+		method         string
+		url            string
+		expectedCode   int
+		expectJsonBody string
+	}{
+		{
+			name:         "ping",
+			method:       http.MethodGet,
+			url:          "/ping",
+			expectedCode: http.StatusNoContent,
+		},
+		{
+			name:         "ping head",
+			method:       http.MethodHead,
+			url:          "/ping?verbose=true",
+			expectedCode: http.StatusNoContent,
+		},
+		{
+			name:           "ping verbose",
+			method:         http.MethodGet,
+			url:            "/ping?verbose=true",
+			expectedCode:   http.StatusOK,
+			expectJsonBody: `{"version": "v2.7.12"}`,
+		},
+		{
+			name:         "v2 ping",
+			method:       http.MethodGet,
+			url:          "/api/v2/ping",
+			expectedCode: http.StatusNoContent,
+		},
+		{
+			name:         "health",
+			method:       http.MethodGet,
+			url:          "/health",
+			expectedCode: http.StatusOK,
+			expectJsonBody: `{
+				"name": "influxdb",
+				"message": "ready for queries and writes",
+				"status": "pass",
+				"checks": [],
+				"version": "v2.7.12",
+				"commit": "unset"
+			}`,
+		},
+		{
+			name:           "setup",
+			method:         http.MethodGet,
+			url:            "/api/v2/setup",
+			expectedCode:   http.StatusOK,
+			expectJsonBody: `{"allowed": false}`,
+		},
+	}
+
+	conf := ProxyConfig{
+		Logger: log.NewNopLogger(),
+	}
+	api, err := NewAPI(conf, &remotewritemock.Client{}, &MockRecorder{})
+	require.NoError(t, err)
+	router := mux.NewRouter()
+	api.Register(router)
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			req := httptest.NewRequest(tt.method, tt.url, nil)
+			rec := httptest.NewRecorder()
+
+			router.ServeHTTP(rec, req)
+			assert.Equal(t, tt.expectedCode, rec.Code)
+			assert.Equal(t, "OSS", rec.Header().Get("X-Influxdb-Build"))
+			assert.Equal(t, DefaultInfluxVersion, rec.Header().Get("X-Influxdb-Version"))
+			if tt.expectJsonBody != "" {
+				assert.JSONEq(t, tt.expectJsonBody, rec.Body.String())
+			} else {
+				assert.Empty(t, rec.Body.String())
+			}
+		})
+	}
+}
+
+func TestWriteVersionHeaders(t *testing.T) {
+	remoteWriteMock := &remotewritemock.Client{}
+	remoteWriteMock.On("Write", mock.Anything, mock.Anything).Return(nil)
+	recorderMock := &MockRecorder{}
+	recorderMock.On("measureMetricsParsed", mock.Anything).Return(nil)
+	recorderMock.On("measureMetricsWritten", mock.Anything).Return(nil)
+	recorderMock.On("measureConversionDuration", mock.Anything).Return(nil)
+
+	conf := ProxyConfig{
+		Logger:        log.NewNopLogger(),
+		InfluxVersion: "1.8.10",
+	}
+	api, err := NewAPI(conf, remoteWriteMock, recorderMock)
+	require.NoError(t, err)
+	router := mux.NewRouter()
+	api.Register(router)
+
+	req := httptest.NewRequest(http.MethodPost, "/write?db=telegraf", bytes.NewReader([]byte("measurement,t1=v1 f1=2 1465839830100400200")))
+	rec := httptest.NewRecorder()
+	router.ServeHTTP(rec, req)
+
+	assert.Equal(t, http.StatusNoContent, rec.Code)
+	assert.Equal(t, "OSS", rec.Header().Get("X-Influxdb-Build"))
+	assert.Equal(t, "1.8.10", rec.Header().Get("X-Influxdb-Version"))
+}
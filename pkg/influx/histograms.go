@@ -0,0 +1,386 @@
+package influx
+
+import (
+	"math"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/grafana/mimir/pkg/mimirpb"
+	"github.com/prometheus/prometheus/model/histogram"
+	"github.com/prometheus/prometheus/model/labels"
+)
+
+// Formats of the histograms and summaries sent by Telegraf, as buckets with an
+// le tag or quantiles with a quantile tag, along with their _sum and _count.
+const (
+	// HistogramsNone converts them like any other field, to unrelated series.
+	HistogramsNone = "none"
+	// HistogramsClassic assembles them into classic histograms and summaries.
+	HistogramsClassic = "classic"
+	// HistogramsNative assembles histograms into native histograms with custom
+	// buckets, falling back to classic histograms when their buckets are
+	// incomplete. Summaries are classic.
+	HistogramsNative = "native"
+)
+
+const (
+	bucketLabel   = "le"
+	quantileLabel = "quantile"
+)
+
+// histogramGroup are the buckets or quantiles of a histogram or summary at a
+// timestamp, with its sum and count.
+type histogramGroup struct {
+	family string
+	// labels are the labels of the series of the group, without the metric
+	// name and the le or quantile label.
+	labels      []mimirpb.LabelAdapter
+	timestampMs int64
+	summary     bool
+	// bounds are the upper bounds of the buckets or the quantiles, with their
+	// cumulative counts or values.
+	bounds           []histogramBound
+	sum, count       float64
+	hasSum, hasCount bool
+}
+
+type histogramBound struct {
+	bound, value float64
+}
+
+// assembleHistograms replaces the series of ts that are the buckets,
+// quantiles, sums and counts of histograms and summaries converted by c with
+// the series of the configured format. Histograms and summaries are only
+// assembled from the series of the same request or batch, which is why batches
+// are cut around the parts, as histogramCut tells.
+func (c *converter) assembleHistograms(ts []mimirpb.TimeSeries) []mimirpb.TimeSeries {
+	if c.histograms == "" || c.histograms == HistogramsNone {
+		return ts
+	}
+
+	groups := map[string]*histogramGroup{}
+	var order []*histogramGroup
+	consumed := make([]bool, len(ts))
+	for i, s := range ts {
+		family, lbls, bound, summary, ok := histogramPart(s.Labels)
+		if !ok {
+			continue
+		}
+		for _, sample := range s.Samples {
+			key := histogramKey(family, lbls, sample.TimestampMs)
+			g, ok := groups[key]
+			if !ok {
+				g = &histogramGroup{family: family, labels: lbls, timestampMs: sample.TimestampMs, summary: summary}
+				groups[key] = g
+				order = append(order, g)
+			}
+			g.bounds = append(g.bounds, histogramBound{bound: bound, value: sample.Value})
+		}
+		consumed[i] = true
+	}
+	if len(order) == 0 {
+		return ts
+	}
+
+	for i, s := range ts {
+		if consumed[i] {
+			continue
+		}
+		name := metricName(s.Labels)
+		family, isSum := strings.CutSuffix(name, "_sum")
+		if !isSum {
+			var isCount bool
+			if family, isCount = strings.CutSuffix(name, "_count"); !isCount {
+				continue
+			}
+		}
+		lbls := withoutLabel(s.Labels, labels.MetricName)
+		remaining := s.Samples[:0:0]
+		for _, sample := range s.Samples {
+			g, ok := groups[histogramKey(family, lbls, sample.TimestampMs)]
+			switch {
+			case !ok:
+				remaining = append(remaining, sample)
+			case isSum:
+				g.sum, g.hasSum = sample.Value, true
+			default:
+				g.count, g.hasCount = sample.Value, true
+			}
+		}
+		if len(remaining) == 0 {
+			consumed[i] = true
+		} else {
+			ts[i].Samples = remaining
+		}
+	}
+
+	assembled := make([]mimirpb.TimeSeries, 0, len(ts))
+	for i, s := range ts {
+		if !consumed[i] {
+			assembled = append(assembled, s)
+		}
+	}
+	for _, g := range order {
+		assembled = append(assembled, c.histogramSeries(g)...)
+	}
+	return assembled
+}
+
+// histogramCut returns where to cut a batch of about size series off the
+// front of ts without splitting the series with the same labels, besides
+// their name and le or quantile label, and the same timestamp, like the parts
+// of a histogram or summary and the other fields of their points. When the
+// series around the cut include such parts, the batch ends before them, or
+// after them if they start it. It returns 0 if they start the batch and may
+// continue after the end of ts, as the next series may complete them, and
+// size if histograms aren't assembled. size must be at most len(ts).
+func (c *converter) histogramCut(ts []mimirpb.TimeSeries, size int) int {
+	if c.histograms == "" || c.histograms == HistogramsNone || size <= 0 {
+		return size
+	}
+	key, ok := histogramTailKey(ts[size-1])
+	if !ok {
+		return size
+	}
+	hasPart := false
+	i := size
+	for ; i > 0; i-- {
+		if k, ok := histogramTailKey(ts[i-1]); !ok || k != key {
+			break
+		}
+		hasPart = hasPart || isHistogramPart(ts[i-1].Labels)
+	}
+	j := size
+	for ; j < len(ts); j++ {
+		if k, ok := histogramTailKey(ts[j]); !ok || k != key {
+			break
+		}
+		hasPart = hasPart || isHistogramPart(ts[j].Labels)
+	}
+	switch {
+	case !hasPart || (j == size && j < len(ts)):
+		return size
+	case i > 0:
+		return i
+	case j == len(ts):
+		return 0
+	}
+	return j
+}
+
+// histogramTailKey identifies the labels of a series with a single sample,
+// without its name and le or quantile label, and its timestamp.
+func histogramTailKey(s mimirpb.TimeSeries) (string, bool) {
+	if len(s.Samples) != 1 {
+		return "", false
+	}
+	lbls := withoutLabel(withoutLabel(withoutLabel(s.Labels, labels.MetricName), bucketLabel), quantileLabel)
+	return histogramKey("", lbls, s.Samples[0].TimestampMs), true
+}
+
+// isHistogramPart reports whether the series with the labels lbls may be a
+// bucket, quantile, sum or count of a histogram or summary.
+func isHistogramPart(lbls []mimirpb.LabelAdapter) bool {
+	if _, _, _, _, ok := histogramPart(lbls); ok {
+		return true
+	}
+	name := metricName(lbls)
+	return strings.HasSuffix(name, "_sum") || strings.HasSuffix(name, "_count")
+}
+
+// histogramPart returns the metric family and the other labels of a series
+// that is a bucket of a histogram or a quantile of a summary, with its bound.
+// Buckets are named after their family with a _bucket suffix.
+func histogramPart(lbls []mimirpb.LabelAdapter) (family string, others []mimirpb.LabelAdapter, bound float64, summary bool, ok bool) {
+	name := metricName(lbls)
+	boundLabel := bucketLabel
+	value, ok := labelValue(lbls, bucketLabel)
+	if ok {
+		if family, ok = strings.CutSuffix(name, "_bucket"); !ok {
+			return "", nil, 0, false, false
+		}
+	} else if value, ok = labelValue(lbls, quantileLabel); ok {
+		family, boundLabel, summary = name, quantileLabel, true
+	} else {
+		return "", nil, 0, false, false
+	}
+	bound, err := strconv.ParseFloat(value, 64)
+	if err != nil || math.IsNaN(bound) {
+		return "", nil, 0, false, false
+	}
+	return family, withoutLabel(withoutLabel(lbls, labels.MetricName), boundLabel), bound, summary, true
+}
+
+func histogramKey(family string, lbls []mimirpb.LabelAdapter, timestampMs int64) string {
+	var sb strings.Builder
+	sb.WriteString(family)
+	sb.WriteByte('\xff')
+	for _, l := range lbls {
+		sb.WriteString(l.Name)
+		sb.WriteByte('\xff')
+		sb.WriteString(l.Value)
+		sb.WriteByte('\xff')
+	}
+	sb.WriteString(strconv.FormatInt(timestampMs, 10))
+	return sb.String()
+}
+
+// histogramSeries returns the series of the histogram or summary of g, and
+// records its metadata.
+func (c *converter) histogramSeries(g *histogramGroup) []mimirpb.TimeSeries {
+	sort.SliceStable(g.bounds, func(i, j int) bool {
+		return g.bounds[i].bound < g.bounds[j].bound
+	})
+
+	if g.summary {
+		c.recorder.measureHistograms("summary")
+		c.metadata.record(mimirpb.MetricMetadata{Type: mimirpb.SUMMARY, MetricFamilyName: g.family}, g.family, g.family+"_sum", g.family+"_count")
+		ts := make([]mimirpb.TimeSeries, 0, len(g.bounds)+2)
+		for _, b := range g.bounds {
+			ts = append(ts, g.series(g.family, quantileLabel, formatBound(b.bound), b.value))
+		}
+		return append(ts, g.sumAndCount()...)
+	}
+
+	if c.histograms == HistogramsNative {
+		if h, ok := g.nativeHistogram(); ok {
+			c.recorder.measureHistograms(HistogramsNative)
+			c.metadata.record(mimirpb.MetricMetadata{Type: mimirpb.HISTOGRAM, MetricFamilyName: g.family}, g.family)
+			return []mimirpb.TimeSeries{{
+				Labels:     withLabel(g.labels, labels.MetricName, g.family),
+				Histograms: []mimirpb.Histogram{mimirpb.FromFloatHistogramToHistogramProto(g.timestampMs, h)},
+			}}
+		}
+		c.recorder.measureHistograms("native_fallback")
+	} else {
+		c.recorder.measureHistograms(HistogramsClassic)
+	}
+
+	// Classic histograms need a +Inf bucket, which has the count of
+	// observations.
+	last := len(g.bounds) - 1
+	hasInf := last >= 0 && math.IsInf(g.bounds[last].bound, 1)
+	if !hasInf && g.hasCount {
+		g.bounds = append(g.bounds, histogramBound{bound: math.Inf(1), value: g.count})
+	} else if hasInf && !g.hasCount {
+		g.count, g.hasCount = g.bounds[last].value, true
+	}
+	c.metadata.record(mimirpb.MetricMetadata{Type: mimirpb.HISTOGRAM, MetricFamilyName: g.family}, g.family+"_bucket", g.family+"_sum", g.family+"_count")
+	ts := make([]mimirpb.TimeSeries, 0, len(g.bounds)+2)
+	for _, b := range g.bounds {
+		ts = append(ts, g.series(g.family+"_bucket", bucketLabel, formatBound(b.bound), b.value))
+	}
+	return append(ts, g.sumAndCount()...)
+}
+
+// nativeHistogram returns the native histogram with custom buckets of g. It
+// is only complete with cumulative buckets up to +Inf and a sum, and a count
+// matching the +Inf bucket if any.
+func (g *histogramGroup) nativeHistogram() (*histogram.FloatHistogram, bool) {
+	n := len(g.bounds)
+	if n == 0 || !math.IsInf(g.bounds[n-1].bound, 1) || !g.hasSum {
+		return nil, false
+	}
+	count := g.bounds[n-1].value
+	if g.hasCount && g.count != count {
+		return nil, false
+	}
+	h := &histogram.FloatHistogram{
+		Schema:          histogram.CustomBucketsSchema,
+		Count:           count,
+		Sum:             g.sum,
+		PositiveSpans:   []histogram.Span{{Offset: 0, Length: uint32(n)}},
+		PositiveBuckets: make([]float64, n),
+		CustomValues:    make([]float64, n-1),
+	}
+	var prev float64
+	for i, b := range g.bounds {
+		if b.value < prev || (i > 0 && b.bound == g.bounds[i-1].bound) {
+			return nil, false
+		}
+		h.PositiveBuckets[i] = b.value - prev
+		prev = b.value
+		if i < n-1 {
+			h.CustomValues[i] = b.bound
+		}
+	}
+	if err := h.Validate(); err != nil {
+		return nil, false
+	}
+	return h, true
+}
+
+// series returns the series of g called name with a sample of value, and the
+// bound label set to bound.
+func (g *histogramGroup) series(name, boundLabel, bound string, value float64) mimirpb.TimeSeries {
+	return mimirpb.TimeSeries{
+		Labels:  withLabel(withLabel(g.labels, boundLabel, bound), labels.MetricName, name),
+		Samples: []mimirpb.Sample{{TimestampMs: g.timestampMs, Value: value}},
+	}
+}
+
+func (g *histogramGroup) sumAndCount() []mimirpb.TimeSeries {
+	var ts []mimirpb.TimeSeries
+	if g.hasSum {
+		ts = append(ts, mimirpb.TimeSeries{
+			Labels:  withLabel(g.labels, labels.MetricName, g.family+"_sum"),
+			Samples: []mimirpb.Sample{{TimestampMs: g.timestampMs, Value: g.sum}},
+		})
+	}
+	if g.hasCount {
+		ts = append(ts, mimirpb.TimeSeries{
+			Labels:  withLabel(g.labels, labels.MetricName, g.family+"_count"),
+			Samples: []mimirpb.Sample{{TimestampMs: g.timestampMs, Value: g.count}},
+		})
+	}
+	return ts
+}
+
+// formatBound formats the bound of a bucket or quantile canonically, so that
+// "1.0" and "1" are the same bucket.
+func formatBound(bound float64) string {
+	return strconv.FormatFloat(bound, 'g', -1, 64)
+}
+
+// labelValue returns the value of the label called name of lbls.
+func labelValue(lbls []mimirpb.LabelAdapter, name string) (string, bool) {
+	for _, l := range lbls {
+		if l.Name == name {
+			return l.Value, true
+		}
+	}
+	return "", false
+}
+
+// withoutLabel returns a copy of lbls without the label called name.
+func withoutLabel(lbls []mimirpb.LabelAdapter, name string) []mimirpb.LabelAdapter {
+	without := make([]mimirpb.LabelAdapter, 0, len(lbls))
+	for _, l := range lbls {
+		if l.Name != name {
+			without = append(without, l)
+		}
+	}
+	return without
+}
+
+// withLabel returns a copy of the sorted labels lbls with the label called
+// name set to value, keeping them sorted.
+func withLabel(lbls []mimirpb.LabelAdapter, name, value string) []mimirpb.LabelAdapter {
+	with := make([]mimirpb.LabelAdapter, 0, len(lbls)+1)
+	added := false
+	for _, l := range lbls {
+		if l.Name == name {
+			continue
+		}
+		if !added && l.Name > name {
+			with = append(with, mimirpb.LabelAdapter{Name: name, Value: value})
+			added = true
+		}
+		with = append(with, l)
+	}
+	if !added {
+		with = append(with, mimirpb.LabelAdapter{Name: name, Value: value})
+	}
+	return with
+}
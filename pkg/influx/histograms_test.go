@@ -0,0 +1,272 @@
+package influx
+
+import (
+	"math"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/go-kit/log"
+	"github.com/grafana/mimir-graphite/v2/pkg/remotewrite/remotewritemock"
+	"github.com/grafana/mimir/pkg/mimirpb"
+	"github.com/prometheus/prometheus/model/histogram"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/mock"
+	"github.com/stretchr/testify/require"
+)
+
+const (
+	telegrafHistogram = `cpu,cpu=cpu0,le=0.0 usage_idle_bucket=0i 1000000000
+cpu,cpu=cpu0,le=50.0 usage_idle_bucket=2i 1000000000
+cpu,cpu=cpu0,le=+Inf usage_idle_bucket=3i 1000000000
+cpu,cpu=cpu0 usage_idle_sum=120,usage_idle_count=3i,usage_user=5 1000000000`
+	telegrafSummary = `prometheus,quantile=0.5 rpc_duration_seconds=0.1 1000000000
+prometheus,quantile=0.99 rpc_duration_seconds=0.3 1000000000
+prometheus rpc_duration_seconds_sum=10,rpc_duration_seconds_count=50i 1000000000`
+)
+
+func TestAssembleHistograms(t *testing.T) {
+	tests := []struct {
+		name     string
+		format   string
+		naming   string
+		data     string
+		expected map[string]float64
+		recorded string
+	}{
+		{
+			name:   "none",
+			format: HistogramsNone,
+			data:   telegrafHistogram,
+			expected: map[string]float64{
+				`{__name__="cpu_usage_idle_bucket", __proxy_source__="influx", cpu="cpu0", le="0.0"}`:  0,
+				`{__name__="cpu_usage_idle_bucket", __proxy_source__="influx", cpu="cpu0", le="50.0"}`: 2,
+				`{__name__="cpu_usage_idle_bucket", __proxy_source__="influx", cpu="cpu0", le="+Inf"}`: 3,
+				`{__name__="cpu_usage_idle_sum", __proxy_source__="influx", cpu="cpu0"}`:               120,
+				`{__name__="cpu_usage_idle_count", __proxy_source__="influx", cpu="cpu0"}`:             3,
+				`{__name__="cpu_usage_user", __proxy_source__="influx", cpu="cpu0"}`:                   5,
+			},
+		},
+		{
+			name:   "classic histogram",
+			format: HistogramsClassic,
+			data:   telegrafHistogram,
+			expected: map[string]float64{
+				`{__name__="cpu_usage_idle_bucket", __proxy_source__="influx", cpu="cpu0", le="0"}`:    0,
+				`{__name__="cpu_usage_idle_bucket", __proxy_source__="influx", cpu="cpu0", le="50"}`:   2,
+				`{__name__="cpu_usage_idle_bucket", __proxy_source__="influx", cpu="cpu0", le="+Inf"}`: 3,
+				`{__name__="cpu_usage_idle_sum", __proxy_source__="influx", cpu="cpu0"}`:               120,
+				`{__name__="cpu_usage_idle_count", __proxy_source__="influx", cpu="cpu0"}`:             3,
+				`{__name__="cpu_usage_user", __proxy_source__="influx", cpu="cpu0"}`:                   5,
+			},
+			recorded: HistogramsClassic,
+		},
+		{
+			name:   "classic histogram without +Inf bucket",
+			format: HistogramsClassic,
+			data: `cpu,le=50 usage_idle_bucket=2i 1000000000
+cpu usage_idle_count=3i 1000000000`,
+			expected: map[string]float64{
+				`{__name__="cpu_usage_idle_bucket", __proxy_source__="influx", le="50"}`:   2,
+				`{__name__="cpu_usage_idle_bucket", __proxy_source__="influx", le="+Inf"}`: 3,
+				`{__name__="cpu_usage_idle_count", __proxy_source__="influx"}`:             3,
+			},
+			recorded: HistogramsClassic,
+		},
+		{
+			name:   "summary",
+			format: HistogramsNative,
+			naming: NamingTelegrafV2,
+			data:   telegrafSummary,
+			expected: map[string]float64{
+				`{__name__="rpc_duration_seconds", __proxy_source__="influx", quantile="0.5"}`:  0.1,
+				`{__name__="rpc_duration_seconds", __proxy_source__="influx", quantile="0.99"}`: 0.3,
+				`{__name__="rpc_duration_seconds_sum", __proxy_source__="influx"}`:              10,
+				`{__name__="rpc_duration_seconds_count", __proxy_source__="influx"}`:            50,
+			},
+			recorded: "summary",
+		},
+		{
+			name:   "incomplete native histogram",
+			format: HistogramsNative,
+			data: `cpu,le=50 usage_idle_bucket=2i 1000000000
+cpu,le=+Inf usage_idle_bucket=3i 1000000000`,
+			expected: map[string]float64{
+				`{__name__="cpu_usage_idle_bucket", __proxy_source__="influx", le="50"}`:   2,
+				`{__name__="cpu_usage_idle_bucket", __proxy_source__="influx", le="+Inf"}`: 3,
+				`{__name__="cpu_usage_idle_count", __proxy_source__="influx"}`:             3,
+			},
+			recorded: "native_fallback",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			recorderMock := &MockRecorder{}
+			if tt.recorded != "" {
+				recorderMock.On("measureHistograms", tt.recorded).Return(nil)
+			}
+			conv := newConverter(ConversionConfig{Histograms: tt.format, Naming: NamingConfig{Scheme: tt.naming}}, log.NewNopLogger(), recorderMock)
+
+			points, err := parsePointsWithPrecision([]byte(tt.data), time.Now(), "ns")
+			require.NoError(t, err)
+			ts, err := conv.writeRequestFromInfluxPoints("fake", points, nil)
+			require.NoError(t, err)
+
+			ts = conv.assembleHistograms(ts)
+			values := map[string]float64{}
+			for _, s := range ts {
+				require.Len(t, s.Samples, 1)
+				assert.Equal(t, int64(1000), s.Samples[0].TimestampMs)
+				values[mimirpb.FromLabelAdaptersToLabels(s.Labels).String()] = s.Samples[0].Value
+			}
+			assert.Equal(t, tt.expected, values)
+			recorderMock.AssertExpectations(t)
+		})
+	}
+}
+
+func TestAssembleNativeHistograms(t *testing.T) {
+	recorderMock := &MockRecorder{}
+	recorderMock.On("measureHistograms", HistogramsNative).Return(nil)
+	conv := newConverter(ConversionConfig{Histograms: HistogramsNative}, log.NewNopLogger(), recorderMock)
+
+	points, err := parsePointsWithPrecision([]byte(telegrafHistogram), time.Now(), "ns")
+	require.NoError(t, err)
+	ts, err := conv.writeRequestFromInfluxPoints("fake", points, nil)
+	require.NoError(t, err)
+
+	ts = conv.assembleHistograms(ts)
+	require.Len(t, ts, 2)
+	assert.Equal(t, "cpu_usage_user", metricName(ts[0].Labels))
+	assert.Equal(t, []mimirpb.LabelAdapter{
+		{Name: "__name__", Value: "cpu_usage_idle"},
+		{Name: "__proxy_source__", Value: "influx"},
+		{Name: "cpu", Value: "cpu0"},
+	}, ts[1].Labels)
+	require.Len(t, ts[1].Histograms, 1)
+	assert.Equal(t, int64(1000), ts[1].Histograms[0].Timestamp)
+	assert.Equal(t, &histogram.FloatHistogram{
+		Schema:          histogram.CustomBucketsSchema,
+		Count:           3,
+		Sum:             120,
+		PositiveSpans:   []histogram.Span{{Offset: 0, Length: 3}},
+		PositiveBuckets: []float64{0, 2, 1},
+		CustomValues:    []float64{0, 50},
+	}, mimirpb.FromFloatHistogramProtoToFloatHistogram(&ts[1].Histograms[0]))
+
+	assert.Equal(t, []*mimirpb.MetricMetadata{{Type: mimirpb.HISTOGRAM, MetricFamilyName: "cpu_usage_idle"}}, conv.metadataDue("a", ts))
+}
+
+func TestHistogramCut(t *testing.T) {
+	recorderMock := &MockRecorder{}
+	conv := newConverter(ConversionConfig{Histograms: HistogramsNative}, log.NewNopLogger(), recorderMock)
+	points, err := parsePointsWithPrecision([]byte("mem used=1 1000000000\n"+telegrafHistogram), time.Now(), "ns")
+	require.NoError(t, err)
+	ts, err := conv.writeRequestFromInfluxPoints("fake", points, nil)
+	require.NoError(t, err)
+	require.Len(t, ts, 7)
+	// The histogram is followed by an unrelated series, which completes it.
+	closed := append(ts[:len(ts):len(ts)], ts[0])
+
+	for _, tt := range []struct {
+		name     string
+		ts       []mimirpb.TimeSeries
+		size     int
+		expected int
+	}{
+		{name: "no histogram", ts: ts, size: 1, expected: 1},
+		{name: "before the histogram", ts: ts, size: 3, expected: 1},
+		{name: "other fields of the point", ts: ts, size: 6, expected: 1},
+		{name: "histogram starting the batch", ts: ts[1:], size: 2, expected: 0},
+		{name: "complete histogram starting the batch", ts: closed[1:], size: 2, expected: 6},
+		{name: "complete histogram ending the batch", ts: closed, size: 7, expected: 7},
+	} {
+		t.Run(tt.name, func(t *testing.T) {
+			assert.Equal(t, tt.expected, conv.histogramCut(tt.ts, tt.size))
+		})
+	}
+
+	conv = newConverter(ConversionConfig{}, log.NewNopLogger(), recorderMock)
+	assert.Equal(t, 3, conv.histogramCut(ts, 3))
+}
+
+func TestAssembleHistogramsAcrossBatches(t *testing.T) {
+	// With batches of 4 series, the histogram would be split after its
+	// +Inf bucket, and is written in the next batch instead.
+	const data = "mem used=1 1000000000\n" + telegrafHistogram
+	isAssembled := func(req *mimirpb.WriteRequest) bool {
+		return len(req.Timeseries) == 2 && (len(req.Timeseries[0].Histograms) == 1 || len(req.Timeseries[1].Histograms) == 1)
+	}
+
+	t.Run("http", func(t *testing.T) {
+		remoteWriteMock := &remotewritemock.Client{}
+		remoteWriteMock.On("Write", mock.Anything, mock.MatchedBy(func(req *mimirpb.WriteRequest) bool {
+			return len(req.Timeseries) == 1
+		})).Return(nil).Once()
+		remoteWriteMock.On("Write", mock.Anything, mock.MatchedBy(isAssembled)).Return(nil).Once()
+		recorderMock := &MockRecorder{}
+		recorderMock.On("measureMetricsParsed", 7).Return(nil)
+		recorderMock.On("measureMetricsWritten", mock.Anything).Return(nil)
+		recorderMock.On("measureConversionDuration", mock.Anything).Return(nil)
+		recorderMock.On("measureHistograms", HistogramsNative).Return(nil).Once()
+		api, err := NewAPI(ProxyConfig{
+			Logger:           log.NewNopLogger(),
+			WriteBatchSize:   4,
+			ConversionConfig: ConversionConfig{Histograms: HistogramsNative},
+		}, remoteWriteMock, recorderMock)
+		require.NoError(t, err)
+
+		req := httptest.NewRequest("POST", "/api/v1/push/influx/write", strings.NewReader(data))
+		rec := httptest.NewRecorder()
+		api.handleSeriesPush(rec, req)
+
+		assert.Equal(t, http.StatusNoContent, rec.Code)
+		remoteWriteMock.AssertExpectations(t)
+		recorderMock.AssertExpectations(t)
+	})
+
+	t.Run("listener", func(t *testing.T) {
+		remoteWriteMock := &remotewritemock.Client{}
+		remoteWriteMock.On("Write", mock.Anything, mock.MatchedBy(func(req *mimirpb.WriteRequest) bool {
+			return len(req.Timeseries) == 1
+		})).Return(nil).Once()
+		remoteWriteMock.On("Write", mock.Anything, mock.MatchedBy(isAssembled)).Return(nil).Once()
+		recorderMock := &MockRecorder{}
+		recorderMock.On("measureMetricsWritten", mock.Anything).Return(nil)
+		recorderMock.On("measureHistograms", HistogramsNative).Return(nil).Once()
+		conv := newConverter(ConversionConfig{Histograms: HistogramsNative}, log.NewNopLogger(), recorderMock)
+
+		// Lines are added one by one, as the stream listeners do.
+		b := newSeriesBatcher("fake", 4, conv, remoteWriteMock, recorderMock, log.NewNopLogger())
+		for _, line := range strings.Split(data, "\n") {
+			points, err := parsePointsWithPrecision([]byte(line), time.Now(), "ns")
+			require.NoError(t, err)
+			ts, err := conv.writeRequestFromInfluxPoints("fake", points, nil)
+			require.NoError(t, err)
+			b.add(ts)
+		}
+		b.flush()
+
+		remoteWriteMock.AssertExpectations(t)
+		recorderMock.AssertExpectations(t)
+	})
+}
+
+func TestNativeHistogramIncomplete(t *testing.T) {
+	inf := math.Inf(1)
+	tests := map[string]histogramGroup{
+		"no buckets":       {hasSum: true},
+		"no +Inf bucket":   {bounds: []histogramBound{{bound: 1, value: 1}}, hasSum: true},
+		"no sum":           {bounds: []histogramBound{{bound: inf, value: 1}}},
+		"count mismatch":   {bounds: []histogramBound{{bound: inf, value: 1}}, hasSum: true, count: 2, hasCount: true},
+		"not cumulative":   {bounds: []histogramBound{{bound: 1, value: 2}, {bound: inf, value: 1}}, hasSum: true},
+		"duplicate bounds": {bounds: []histogramBound{{bound: 1, value: 1}, {bound: 1, value: 1}, {bound: inf, value: 1}}, hasSum: true},
+	}
+	for name, g := range tests {
+		_, ok := g.nativeHistogram()
+		assert.False(t, ok, name)
+	}
+}
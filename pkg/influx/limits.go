@@ -0,0 +1,383 @@
+package influx
+
+import (
+	"errors"
+	"flag"
+	"fmt"
+	"hash/fnv"
+	"net/http"
+	"sort"
+	"strings"
+	"unicode/utf8"
+
+	"github.com/grafana/mimir/pkg/mimirpb"
+	"github.com/influxdata/influxdb/v2/models"
+	"github.com/prometheus/prometheus/model/labels"
+)
+
+// Actions for the lines exceeding a limit.
+const (
+	// LimitsTruncate truncates the names and values that are too long, drops
+	// the labels beyond the limit, after the reserved and external ones, or
+	// drops the lines beyond the per-request limits without reporting them.
+	LimitsTruncate = "truncate"
+	// LimitsHash is LimitsTruncate with a hash of what is removed, so that
+	// distinct series stay distinct: names and values end with a hash of their
+	// whole, and the labels beyond the limit are replaced by their hash in
+	// the labelsHashLabel. It doesn't apply to the per-request limits.
+	LimitsHash = "hash"
+	// LimitsDropLine drops the line and reports it as invalid. It doesn't
+	// apply to the per-request limits.
+	LimitsDropLine = "drop-line"
+	// LimitsRejectRequest stops the request with an error reporting the line.
+	LimitsRejectRequest = "reject-request"
+)
+
+// Limits, as counted in the recorder and reported in errors once their
+// underscores are replaced by spaces.
+const (
+	limitPointsPerRequest = "points_per_request"
+	limitSeriesPerRequest = "series_per_request"
+	limitLabelsPerSeries  = "labels_per_series"
+	limitLabelNameLength  = "label_name_length"
+	limitLabelValueLength = "label_value_length"
+	limitMetricNameLength = "metric_name_length"
+
+	// labelsHashLabel holds the hash of the labels dropped by LimitsHash.
+	labelsHashLabel = "__labels_hash__"
+	// hashLength is the length of the hash appended by LimitsHash.
+	hashLength = len("_0123456789abcdef")
+)
+
+// LimitsConfig bounds the size of the requests and series converted from Influx
+// points, so that they are handled by the proxy rather than rejected by Mimir
+// deep in its write path.
+type LimitsConfig struct {
+	// PointsPerRequest limits the number of points of an HTTP request, a
+	// datagram, or the lines a stream connection sends within a batch timeout.
+	PointsPerRequest Limit `yaml:"points_per_request"`
+	// SeriesPerRequest limits the number of series converted from the points
+	// of a request, before the samples of the same series are merged.
+	SeriesPerRequest Limit `yaml:"series_per_request"`
+	// LabelsPerSeries limits the number of labels of a series, including its
+	// name.
+	LabelsPerSeries Limit `yaml:"labels_per_series"`
+	// LabelNameLength limits the length of label names in bytes.
+	LabelNameLength Limit `yaml:"label_name_length"`
+	// LabelValueLength limits the length of label values in bytes.
+	LabelValueLength Limit `yaml:"label_value_length"`
+	// MetricNameLength limits the length of series names in bytes.
+	MetricNameLength Limit `yaml:"metric_name_length"`
+}
+
+// Limit is a limit and the action for the lines exceeding it.
+type Limit struct {
+	// Max is the limit. Any value less than or equal to 0 means no limit.
+	Max int `yaml:"max"`
+	// Action is one of LimitsTruncate, LimitsHash, LimitsDropLine or
+	// LimitsRejectRequest. Empty means LimitsRejectRequest for the
+	// per-request limits, and LimitsDropLine for the others.
+	Action string `yaml:"action"`
+}
+
+func (c *LimitsConfig) RegisterFlags(flags *flag.FlagSet) {
+	requestActions := fmt.Sprintf("%s the request, or %s it to the lines within the limit", LimitsRejectRequest, LimitsTruncate)
+	lineActions := fmt.Sprintf("%s, %s, %s or %s", LimitsTruncate, LimitsHash, LimitsDropLine, LimitsRejectRequest)
+	c.PointsPerRequest.registerFlags(flags, "limits.points.per.request", "maximum number of points of an HTTP request, a datagram or the lines of a connection within a batch timeout", LimitsRejectRequest, requestActions)
+	c.SeriesPerRequest.registerFlags(flags, "limits.series.per.request", "maximum number of series converted from the points of a request", LimitsRejectRequest, requestActions)
+	c.LabelsPerSeries.registerFlags(flags, "limits.labels.per.series", "maximum number of labels of a series", LimitsDropLine, lineActions)
+	c.LabelNameLength.registerFlags(flags, "limits.label.name.length", "maximum length of label names in bytes", LimitsDropLine, lineActions)
+	c.LabelValueLength.registerFlags(flags, "limits.label.value.length", "maximum length of label values in bytes", LimitsDropLine, lineActions)
+	c.MetricNameLength.registerFlags(flags, "limits.metric.name.length", "maximum length of series names in bytes", LimitsDropLine, lineActions)
+}
+
+func (l *Limit) registerFlags(flags *flag.FlagSet, name, help, action, actions string) {
+	flags.IntVar(&l.Max, name, 0, help+" (0 for no limit)")
+	flags.StringVar(&l.Action, name+".action", action, fmt.Sprintf("what to do when -%s is exceeded: %s", name, actions))
+}
+
+// Validate checks the configuration is usable.
+func (c LimitsConfig) Validate() error {
+	for _, l := range []struct {
+		name  string
+		limit Limit
+	}{
+		{limitPointsPerRequest, c.PointsPerRequest},
+		{limitSeriesPerRequest, c.SeriesPerRequest},
+		{limitLabelsPerSeries, c.LabelsPerSeries},
+		{limitLabelNameLength, c.LabelNameLength},
+		{limitLabelValueLength, c.LabelValueLength},
+		{limitMetricNameLength, c.MetricNameLength},
+	} {
+		if err := l.limit.validate(l.name); err != nil {
+			return fmt.Errorf("invalid %s limit: %w", strings.ReplaceAll(l.name, "_", " "), err)
+		}
+	}
+	return nil
+}
+
+func (l Limit) validate(limit string) error {
+	perRequest := limit == limitPointsPerRequest || limit == limitSeriesPerRequest
+	switch l.Action {
+	case "", LimitsTruncate, LimitsRejectRequest:
+	case LimitsHash, LimitsDropLine:
+		if perRequest {
+			return fmt.Errorf("action %q only applies to lines", l.Action)
+		}
+	default:
+		return fmt.Errorf("invalid action %q", l.Action)
+	}
+	if l.Action != LimitsHash || l.Max <= 0 {
+		return nil
+	}
+	if limit == limitLabelsPerSeries && l.Max < 2 {
+		return errors.New("hash needs room for the name and hash of the series")
+	}
+	if limit != limitLabelsPerSeries && l.Max <= hashLength {
+		return fmt.Errorf("hash needs a limit larger than %d", hashLength)
+	}
+	return nil
+}
+
+// action returns the action of the limit named limit.
+func (l Limit) action(limit string) string {
+	if l.Action != "" {
+		return l.Action
+	}
+	if limit == limitPointsPerRequest || limit == limitSeriesPerRequest {
+		return LimitsRejectRequest
+	}
+	return LimitsDropLine
+}
+
+// limitError is returned when a line exceeds a limit.
+type limitError struct {
+	limit string
+	// subject names what exceeds the limit, if not the request.
+	subject string
+	size    int
+	max     int
+	// lineNum is the 1-based number of the line, once known.
+	lineNum int
+}
+
+func (e limitError) Error() string {
+	var sb strings.Builder
+	sb.WriteString(strings.ReplaceAll(e.limit, "_", " "))
+	if e.subject != "" {
+		sb.WriteString(" of " + e.subject)
+	}
+	fmt.Fprintf(&sb, ": %d exceeds the limit of %d", e.size, e.max)
+	if e.lineNum > 0 {
+		fmt.Fprintf(&sb, " (line %d)", e.lineNum)
+	}
+	return sb.String()
+}
+
+// status returns the HTTP status code and Influx error code of the rejected
+// request.
+func (e limitError) status() (int, string) {
+	if e.limit == limitPointsPerRequest || e.limit == limitSeriesPerRequest {
+		return http.StatusRequestEntityTooLarge, ETooLarge
+	}
+	return http.StatusUnprocessableEntity, EUnprocessableEntity
+}
+
+// withLineNum returns err with the number of the line that caused it, if it is
+// a limitError rejecting the request.
+func withLineNum(err error, lineNum int) error {
+	var limitErr limitError
+	if !errors.As(err, &limitErr) {
+		return err
+	}
+	limitErr.lineNum = lineNum
+	return limitErr
+}
+
+// exceeded counts a line exceeding the limit named limit and returns its
+// action, along with the error of the line if it isn't written.
+func (c *converter) exceeded(limit string, l Limit, subject string, size int) (string, error) {
+	action := l.action(limit)
+	c.recorder.measureLimitsExceeded(limit, action)
+	err := limitError{limit: limit, subject: subject, size: size, max: l.Max}
+	switch action {
+	case LimitsDropLine:
+		return action, invalidPointError{err}
+	case LimitsRejectRequest:
+		return action, err
+	}
+	return action, nil
+}
+
+// limitLabels applies the limits on names, values and labels to the sorted
+// labels lbls of a series, which it may modify.
+func (c *converter) limitLabels(lbls []mimirpb.LabelAdapter) ([]mimirpb.LabelAdapter, error) {
+	renamed := false
+	for i := range lbls {
+		l := &lbls[i]
+		if l.Name == labels.MetricName {
+			if max := c.limits.MetricNameLength.Max; max > 0 && len(l.Value) > max {
+				action, err := c.exceeded(limitMetricNameLength, c.limits.MetricNameLength, fmt.Sprintf("%.64q", l.Value), len(l.Value))
+				if err != nil {
+					return nil, err
+				}
+				l.Value = shorten(l.Value, max, action)
+			}
+			continue
+		}
+		// The names of the reserved and external labels are configured.
+		if max := c.limits.LabelNameLength.Max; max > 0 && len(l.Name) > max && !c.isKeptLabel(l.Name) {
+			action, err := c.exceeded(limitLabelNameLength, c.limits.LabelNameLength, fmt.Sprintf("%.64q", l.Name), len(l.Name))
+			if err != nil {
+				return nil, err
+			}
+			l.Name, renamed = shorten(l.Name, max, action), true
+		}
+		if max := c.limits.LabelValueLength.Max; max > 0 && len(l.Value) > max {
+			action, err := c.exceeded(limitLabelValueLength, c.limits.LabelValueLength, fmt.Sprintf("label %q", l.Name), len(l.Value))
+			if err != nil {
+				return nil, err
+			}
+			l.Value = shorten(l.Value, max, action)
+		}
+	}
+	if renamed {
+		// Shortened names may collide with each other: the first wins.
+		sort.SliceStable(lbls, func(i, j int) bool {
+			return lbls[i].Name < lbls[j].Name
+		})
+		deduped := lbls[:0]
+		for _, l := range lbls {
+			if len(deduped) == 0 || deduped[len(deduped)-1].Name != l.Name {
+				deduped = append(deduped, l)
+			}
+		}
+		lbls = deduped
+	}
+
+	max := c.limits.LabelsPerSeries.Max
+	if max <= 0 || len(lbls) <= max {
+		return lbls, nil
+	}
+	action, err := c.exceeded(limitLabelsPerSeries, c.limits.LabelsPerSeries, fmt.Sprintf("%q", metricName(lbls)), len(lbls))
+	if err != nil {
+		return nil, err
+	}
+	return c.dropLabels(lbls, max, action), nil
+}
+
+// dropLabels returns the sorted labels lbls without the labels beyond max,
+// keeping the reserved and external labels, and the labels with the smallest
+// names. With LimitsHash, the labels dropped are replaced by their hash.
+func (c *converter) dropLabels(lbls []mimirpb.LabelAdapter, max int, action string) []mimirpb.LabelAdapter {
+	keep := max
+	if action == LimitsHash {
+		keep--
+	}
+	for _, l := range lbls {
+		if c.isKeptLabel(l.Name) {
+			keep--
+		}
+	}
+
+	kept := make([]mimirpb.LabelAdapter, 0, max)
+	h := fnv.New64a()
+	for _, l := range lbls {
+		if c.isKeptLabel(l.Name) {
+			kept = append(kept, l)
+			continue
+		}
+		if keep > 0 {
+			kept = append(kept, l)
+			keep--
+			continue
+		}
+		_, _ = h.Write([]byte(l.Name))
+		_, _ = h.Write([]byte{'\xff'})
+		_, _ = h.Write([]byte(l.Value))
+		_, _ = h.Write([]byte{'\xff'})
+	}
+	if action == LimitsHash {
+		kept = append(kept, mimirpb.LabelAdapter{Name: labelsHashLabel, Value: fmt.Sprintf("%016x", h.Sum64())})
+		sort.Slice(kept, func(i, j int) bool {
+			return kept[i].Name < kept[j].Name
+		})
+	}
+	return kept
+}
+
+// isKeptLabel reports whether the label called name is kept whatever the
+// limit on labels per series.
+func (c *converter) isKeptLabel(name string) bool {
+	return c.isReservedLabel(name) || hasLabel(c.externalLabels, name)
+}
+
+// shorten truncates s to max bytes, without splitting UTF-8 characters. With
+// LimitsHash, s ends with a hash of its whole instead.
+func shorten(s string, max int, action string) string {
+	if action != LimitsHash {
+		return truncateUTF8(s, max)
+	}
+	h := fnv.New64a()
+	_, _ = h.Write([]byte(s))
+	return truncateUTF8(s, max-hashLength) + fmt.Sprintf("_%016x", h.Sum64())
+}
+
+// truncateUTF8 truncates s to at most max bytes, without splitting UTF-8
+// characters.
+func truncateUTF8(s string, max int) string {
+	if len(s) <= max {
+		return s
+	}
+	for max > 0 && !utf8.RuneStart(s[max]) {
+		max--
+	}
+	return s[:max]
+}
+
+// requestLimits checks the lines of an HTTP request, or of a datagram or a batch
+// of lines received by a listener, against the per-request limits of its
+// converter.
+type requestLimits struct {
+	c *converter
+	// tenant is the tenant the lines are written for.
+	tenant string
+	points int
+	series int
+}
+
+func (c *converter) newRequestLimits(tenant string) *requestLimits {
+	return &requestLimits{c: c, tenant: tenant}
+}
+
+// convert converts the points of a line like writeRequestFromInfluxPoints,
+// unless they exceed the per-request limits. The lines beyond the limits are
+// dropped without error with LimitsTruncate.
+func (l *requestLimits) convert(points []models.Point, extraLabels []mimirpb.LabelAdapter) ([]mimirpb.TimeSeries, error) {
+	return l.add(len(points), func() ([]mimirpb.TimeSeries, error) {
+		return l.c.writeRequestFromInfluxPoints(l.tenant, points, extraLabels)
+	})
+}
+
+// add converts the given number of points of a line with convert, unless they
+// exceed the per-request limits, for the points received with other protocols
+// than Influx line protocol. The lines beyond the limits are dropped without
+// error with LimitsTruncate.
+func (l *requestLimits) add(points int, convert func() ([]mimirpb.TimeSeries, error)) ([]mimirpb.TimeSeries, error) {
+	limits := l.c.limits
+	if max := limits.PointsPerRequest.Max; max > 0 && l.points+points > max {
+		_, err := l.c.exceeded(limitPointsPerRequest, limits.PointsPerRequest, "", l.points+points)
+		return nil, err
+	}
+	ts, err := convert()
+	if err != nil {
+		return nil, err
+	}
+	if max := limits.SeriesPerRequest.Max; max > 0 && l.series+len(ts) > max {
+		_, err := l.c.exceeded(limitSeriesPerRequest, limits.SeriesPerRequest, "", l.series+len(ts))
+		return nil, err
+	}
+	l.points += points
+	l.series += len(ts)
+	return ts, nil
+}
@@ -0,0 +1,451 @@
+package influx
+
+import (
+	"bytes"
+	"context"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"collectd.org/api"
+	"collectd.org/network"
+	"github.com/go-kit/log"
+	"github.com/grafana/dskit/services"
+	"github.com/grafana/mimir-graphite/v2/pkg/remotewrite/remotewritemock"
+	"github.com/grafana/mimir/pkg/mimirpb"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/mock"
+	"github.com/stretchr/testify/require"
+)
+
+func TestLimitLabels(t *testing.T) {
+	const data = `cpu,host=server01.example.com,region=eu,zone=a usage_idle=1 1000000000`
+	tests := []struct {
+		name        string
+		limits      LimitsConfig
+		expected    []mimirpb.LabelAdapter
+		measured    [][2]string
+		expectedErr string
+	}{
+		{
+			name: "within limits",
+			limits: LimitsConfig{
+				LabelsPerSeries:  Limit{Max: 5},
+				LabelNameLength:  Limit{Max: 16},
+				LabelValueLength: Limit{Max: 20},
+				MetricNameLength: Limit{Max: 14},
+			},
+			expected: []mimirpb.LabelAdapter{
+				{Name: "__name__", Value: "cpu_usage_idle"},
+				{Name: "__proxy_source__", Value: "influx"},
+				{Name: "host", Value: "server01.example.com"},
+				{Name: "region", Value: "eu"},
+				{Name: "zone", Value: "a"},
+			},
+		},
+		{
+			name: "truncate",
+			limits: LimitsConfig{
+				LabelsPerSeries:  Limit{Max: 4, Action: LimitsTruncate},
+				LabelNameLength:  Limit{Max: 3, Action: LimitsTruncate},
+				LabelValueLength: Limit{Max: 8, Action: LimitsTruncate},
+				MetricNameLength: Limit{Max: 9, Action: LimitsTruncate},
+			},
+			expected: []mimirpb.LabelAdapter{
+				{Name: "__name__", Value: "cpu_usage"},
+				{Name: "__proxy_source__", Value: "influx"},
+				{Name: "hos", Value: "server01"},
+				{Name: "reg", Value: "eu"},
+			},
+			measured: [][2]string{
+				{"metric_name_length", "truncate"},
+				{"label_name_length", "truncate"},
+				{"label_value_length", "truncate"},
+				{"label_name_length", "truncate"},
+				{"label_name_length", "truncate"},
+				{"labels_per_series", "truncate"},
+			},
+		},
+		{
+			name: "hash",
+			limits: LimitsConfig{
+				LabelsPerSeries:  Limit{Max: 4, Action: LimitsHash},
+				LabelValueLength: Limit{Max: 18, Action: LimitsHash},
+			},
+			expected: []mimirpb.LabelAdapter{
+				{Name: "__labels_hash__", Value: "c884f5dad9c1d148"},
+				{Name: "__name__", Value: "cpu_usage_idle"},
+				{Name: "__proxy_source__", Value: "influx"},
+				{Name: "host", Value: "s_432fc480a870d292"},
+			},
+			measured: [][2]string{
+				{"label_value_length", "hash"},
+				{"labels_per_series", "hash"},
+			},
+		},
+		{
+			name:        "drop line",
+			limits:      LimitsConfig{LabelValueLength: Limit{Max: 8}},
+			measured:    [][2]string{{"label_value_length", "drop-line"}},
+			expectedErr: `label value length of label "host": 20 exceeds the limit of 8`,
+		},
+		{
+			name:        "reject request",
+			limits:      LimitsConfig{LabelsPerSeries: Limit{Max: 3, Action: LimitsRejectRequest}},
+			measured:    [][2]string{{"labels_per_series", "reject-request"}},
+			expectedErr: `labels per series of "cpu_usage_idle": 5 exceeds the limit of 3`,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			recorderMock := &MockRecorder{}
+			for _, m := range tt.measured {
+				recorderMock.On("measureLimitsExceeded", m[0], m[1]).Return(nil)
+			}
+			conv := newConverter(ConversionConfig{Limits: tt.limits}, log.NewNopLogger(), recorderMock)
+
+			points, err := parsePointsWithPrecision([]byte(data), time.Now(), "ns")
+			require.NoError(t, err)
+			ts, err := conv.writeRequestFromInfluxPoints("fake", points, nil)
+			recorderMock.AssertNumberOfCalls(t, "measureLimitsExceeded", len(tt.measured))
+			if tt.expectedErr != "" {
+				assert.EqualError(t, err, tt.expectedErr)
+				assert.ErrorAs(t, err, &limitError{})
+				isInvalidPoint := assert.ErrorAs
+				if tt.limits.LabelsPerSeries.Action == LimitsRejectRequest {
+					isInvalidPoint = assert.NotErrorAs
+				}
+				isInvalidPoint(t, err, &invalidPointError{})
+				return
+			}
+			require.NoError(t, err)
+			require.Len(t, ts, 1)
+			assert.Equal(t, tt.expected, ts[0].Labels)
+		})
+	}
+}
+
+func TestLimitLabelsKeepsExternalLabels(t *testing.T) {
+	recorderMock := &MockRecorder{}
+	recorderMock.On("measureLimitsExceeded", "labels_per_series", "truncate").Return(nil)
+	conv := newConverter(ConversionConfig{
+		ExternalLabels: ExternalLabels{"zz": "1"},
+		Limits:         LimitsConfig{LabelsPerSeries: Limit{Max: 4, Action: LimitsTruncate}},
+	}, log.NewNopLogger(), recorderMock)
+
+	points, err := parsePointsWithPrecision([]byte(`m,a=1,b=2,c=3 v=1 1000000000`), time.Now(), "ns")
+	require.NoError(t, err)
+	ts, err := conv.writeRequestFromInfluxPoints("fake", points, nil)
+	require.NoError(t, err)
+	require.Len(t, ts, 1)
+	assert.Equal(t, []mimirpb.LabelAdapter{
+		{Name: "__name__", Value: "m_v"},
+		{Name: "__proxy_source__", Value: "influx"},
+		{Name: "a", Value: "1"},
+		{Name: "zz", Value: "1"},
+	}, ts[0].Labels)
+}
+
+func TestRequestLimits(t *testing.T) {
+	data := strings.Join([]string{
+		"m,host=a f=1 1465839830100400200",
+		"m,host=b f=2 1465839830100400200",
+		"m,host=a-very-long-host-name f=3 1465839830100400200",
+		"m,host=c f=4 1465839830100400200",
+	}, "\n")
+	tests := []struct {
+		name           string
+		limits         LimitsConfig
+		expectedWrites int
+		expectedCode   int
+		expectJsonBody string
+		measured       [][2]string
+	}{
+		{
+			name:           "points per request rejected",
+			limits:         LimitsConfig{PointsPerRequest: Limit{Max: 3}},
+			expectedCode:   http.StatusRequestEntityTooLarge,
+			expectJsonBody: `{"code": "request too large", "message": "points per request: 4 exceeds the limit of 3 (line 4)"}`,
+			measured:       [][2]string{{"points_per_request", "reject-request"}},
+		},
+		{
+			name:           "series per request truncated",
+			limits:         LimitsConfig{SeriesPerRequest: Limit{Max: 2, Action: LimitsTruncate}},
+			expectedWrites: 2,
+			expectedCode:   http.StatusNoContent,
+			measured:       [][2]string{{"series_per_request", "truncate"}, {"series_per_request", "truncate"}},
+		},
+		{
+			name:           "label value length line dropped",
+			limits:         LimitsConfig{LabelValueLength: Limit{Max: 8}},
+			expectedWrites: 3,
+			expectedCode:   http.StatusBadRequest,
+			expectJsonBody: `{"code": "invalid", "line": 3, "message": "partial write: label value length of label \"host\": 21 exceeds the limit of 8 (line 3) dropped=1"}`,
+			measured:       [][2]string{{"label_value_length", "drop-line"}},
+		},
+		{
+			name:           "label value length request rejected",
+			limits:         LimitsConfig{LabelValueLength: Limit{Max: 8, Action: LimitsRejectRequest}},
+			expectedCode:   http.StatusUnprocessableEntity,
+			expectJsonBody: `{"code": "unprocessable entity", "message": "label value length of label \"host\": 21 exceeds the limit of 8 (line 3)"}`,
+			measured:       [][2]string{{"label_value_length", "reject-request"}},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			remoteWriteMock := &remotewritemock.Client{}
+			remoteWriteMock.On("Write", mock.Anything, mock.MatchedBy(func(req *mimirpb.WriteRequest) bool {
+				return len(req.Timeseries) == tt.expectedWrites
+			})).Return(nil)
+			recorderMock := &MockRecorder{}
+			recorderMock.On("measureMetricsParsed", mock.Anything).Return(nil)
+			recorderMock.On("measureMetricsWritten", mock.Anything).Return(nil)
+			recorderMock.On("measureConversionDuration", mock.Anything).Return(nil)
+			recorderMock.On("measureProxyErrors", "influx.limitError").Return(nil)
+			recorderMock.On("measureFailedLines", "limit_exceeded", 1).Return(nil)
+			for _, m := range tt.measured {
+				recorderMock.On("measureLimitsExceeded", m[0], m[1]).Return(nil)
+			}
+			api, err := NewAPI(ProxyConfig{
+				Logger:           log.NewNopLogger(),
+				ConversionConfig: ConversionConfig{Limits: tt.limits},
+			}, remoteWriteMock, recorderMock)
+			require.NoError(t, err)
+
+			req := httptest.NewRequest("POST", "/api/v1/push/influx/write", bytes.NewReader([]byte(data)))
+			rec := httptest.NewRecorder()
+			api.handleSeriesPush(rec, req)
+
+			assert.Equal(t, tt.expectedCode, rec.Code)
+			if tt.expectJsonBody != "" {
+				assert.JSONEq(t, tt.expectJsonBody, rec.Body.String())
+			}
+			if tt.expectedWrites > 0 {
+				remoteWriteMock.AssertNumberOfCalls(t, "Write", 1)
+			} else {
+				remoteWriteMock.AssertNotCalled(t, "Write", mock.Anything, mock.Anything)
+			}
+			recorderMock.AssertNumberOfCalls(t, "measureLimitsExceeded", len(tt.measured))
+		})
+	}
+}
+
+func TestValidateLimits(t *testing.T) {
+	assert.NoError(t, ConversionConfig{Limits: LimitsConfig{
+		PointsPerRequest: Limit{Max: 1000, Action: LimitsTruncate},
+		LabelsPerSeries:  Limit{Max: 2, Action: LimitsHash},
+		LabelValueLength: Limit{Max: 32, Action: LimitsHash},
+	}}.Validate())
+	assert.Error(t, ConversionConfig{Limits: LimitsConfig{PointsPerRequest: Limit{Action: LimitsDropLine}}}.Validate())
+	assert.Error(t, ConversionConfig{Limits: LimitsConfig{SeriesPerRequest: Limit{Action: LimitsHash}}}.Validate())
+	assert.Error(t, ConversionConfig{Limits: LimitsConfig{LabelsPerSeries: Limit{Max: 1, Action: LimitsHash}}}.Validate())
+	assert.Error(t, ConversionConfig{Limits: LimitsConfig{LabelNameLength: Limit{Max: 16, Action: LimitsHash}}}.Validate())
+	assert.Error(t, ConversionConfig{Limits: LimitsConfig{MetricNameLength: Limit{Action: "drop"}}}.Validate())
+}
+
+func TestTruncateUTF8(t *testing.T) {
+	assert.Equal(t, "h", truncateUTF8("hé", 2))
+	assert.Equal(t, "hé", truncateUTF8("hé", 3))
+	assert.Equal(t, "", truncateUTF8("é", 1))
+}
+
+func TestRequestLimitsListeners(t *testing.T) {
+	t.Run("udp", func(t *testing.T) {
+		remoteWriteMock := &remotewritemock.Client{}
+		var written []mimirpb.PreallocTimeseries
+		remoteWriteMock.On("Write", mock.Anything, mock.Anything).Return(nil).Run(func(args mock.Arguments) {
+			written = append(written, args.Get(1).(*mimirpb.WriteRequest).Timeseries...)
+		})
+		recorderMock := &MockRecorder{}
+		recorderMock.On("measureMessagesReceived", "udp").Return(nil)
+		processed := make(chan struct{}, 2)
+		recorderMock.On("measureMetricsParsed", mock.Anything).Return(nil).Run(func(mock.Arguments) {
+			processed <- struct{}{}
+		})
+		recorderMock.On("measureMetricsWritten", 3).Return(nil)
+		recorderMock.On("measureConversionDuration", mock.Anything).Return(nil)
+		recorderMock.On("measureLimitsExceeded", "points_per_request", "truncate").Return(nil).Once()
+
+		cfg := UDPConfig{
+			ListenAddress: "127.0.0.1:0",
+			Tenant:        "udp-tenant",
+			Precision:     "s",
+			BatchSize:     10,
+			BatchTimeout:  time.Hour,
+			BatchPending:  10,
+		}
+		conv := newConverter(ConversionConfig{Limits: LimitsConfig{PointsPerRequest: Limit{Max: 2, Action: LimitsTruncate}}}, log.NewNopLogger(), recorderMock)
+		l := newUDPListener(udpListenerName, cfg, influxPacketParser(cfg.Precision), conv, log.NewNopLogger(), remoteWriteMock, recorderMock)
+		require.NoError(t, services.StartAndAwaitRunning(context.Background(), l))
+
+		conn, err := net.Dial("udp", l.Addr().String())
+		require.NoError(t, err)
+		defer conn.Close()
+
+		// The limits apply to each datagram: the third line of the first one
+		// is dropped, but not the line of the second one.
+		_, err = conn.Write([]byte("m,t=a f=1 1465839830\nm,t=b f=2 1465839830\nm,t=c f=3 1465839830"))
+		require.NoError(t, err)
+		_, err = conn.Write([]byte("m,t=d f=4 1465839830"))
+		require.NoError(t, err)
+		for i := 0; i < 2; i++ {
+			select {
+			case <-processed:
+			case <-time.After(5 * time.Second):
+				t.Fatal("datagram not processed")
+			}
+		}
+		require.NoError(t, services.StopAndAwaitTerminated(context.Background(), l))
+
+		require.Len(t, written, 3)
+		assert.Equal(t, "d", written[2].Labels[2].Value)
+		recorderMock.AssertExpectations(t)
+	})
+
+	t.Run("tcp", func(t *testing.T) {
+		remoteWriteMock, written := newStreamWriteMock(t)
+		recorderMock := newStreamRecorderMock("tcp")
+		recorderMock.On("measureLimitsExceeded", "label_value_length", "drop-line").Return(nil).Once()
+		recorderMock.On("measureLimitsExceeded", "points_per_request", "reject-request").Return(nil).Once()
+		recorderMock.On("measureMessagesDropped", "tcp", "limit_exceeded").Return(nil).Twice()
+
+		cfg := testStreamConfig()
+		cfg.BatchSize = 10
+		conv := newConverter(ConversionConfig{Limits: LimitsConfig{
+			PointsPerRequest: Limit{Max: 2, Action: LimitsRejectRequest},
+			LabelValueLength: Limit{Max: 8},
+		}}, log.NewNopLogger(), recorderMock)
+		l := newStreamListener("tcp", "tcp", "127.0.0.1:0", nil, cfg, influxLineParser(cfg.Precision), conv, log.NewNopLogger(), remoteWriteMock, recorderMock)
+		require.NoError(t, services.StartAndAwaitRunning(context.Background(), l))
+
+		// The dropped line doesn't count towards the points of the
+		// connection, the last one exceeds them.
+		conn, err := net.Dial("tcp", l.Addr().String())
+		require.NoError(t, err)
+		_, err = conn.Write([]byte("m,t=a f=1 1465839830\nm,t=long-value f=2 1465839830\nm,t=b f=3 1465839830\nm,t=c f=4 1465839830\n"))
+		require.NoError(t, err)
+		require.NoError(t, conn.Close())
+		require.NoError(t, services.StopAndAwaitTerminated(context.Background(), l))
+
+		ts := written()
+		require.Len(t, ts, 2)
+		assert.Equal(t, "a", ts[0].Labels[2].Value)
+		assert.Equal(t, "b", ts[1].Labels[2].Value)
+		recorderMock.AssertExpectations(t)
+	})
+}
+
+func TestLimitsOtherProtocols(t *testing.T) {
+	limits := LimitsConfig{
+		PointsPerRequest: Limit{Max: 2, Action: LimitsRejectRequest},
+		LabelValueLength: Limit{Max: 8},
+	}
+
+	t.Run("opentsdb", func(t *testing.T) {
+		tests := []struct {
+			name           string
+			body           string
+			expectedWrites int
+			expectedCode   int
+			measured       [][2]string
+		}{
+			{
+				name: "label value length point dropped",
+				body: `[
+					{"metric": "m", "timestamp": 1465839830, "value": 1, "tags": {"host": "a"}},
+					{"metric": "m", "timestamp": 1465839830, "value": 2, "tags": {"host": "a-long-host"}}
+				]`,
+				expectedWrites: 1,
+				expectedCode:   http.StatusBadRequest,
+				measured:       [][2]string{{"label_value_length", "drop-line"}},
+			},
+			{
+				name: "points per request rejected",
+				body: `[
+					{"metric": "m", "timestamp": 1465839830, "value": 1, "tags": {"host": "a"}},
+					{"metric": "m", "timestamp": 1465839830, "value": 2, "tags": {"host": "b"}},
+					{"metric": "m", "timestamp": 1465839830, "value": 3, "tags": {"host": "c"}}
+				]`,
+				expectedCode: http.StatusRequestEntityTooLarge,
+				measured:     [][2]string{{"points_per_request", "reject-request"}},
+			},
+		}
+
+		for _, tt := range tests {
+			t.Run(tt.name, func(t *testing.T) {
+				remoteWriteMock := &remotewritemock.Client{}
+				remoteWriteMock.On("Write", mock.Anything, mock.MatchedBy(func(req *mimirpb.WriteRequest) bool {
+					return len(req.Timeseries) == tt.expectedWrites
+				})).Return(nil)
+				recorderMock := &MockRecorder{}
+				recorderMock.On("measureMetricsParsed", mock.Anything).Return(nil)
+				recorderMock.On("measureMetricsWritten", mock.Anything).Return(nil)
+				recorderMock.On("measureConversionDuration", mock.Anything).Return(nil)
+				recorderMock.On("measureOpenTSDBPoints", "http", mock.Anything, mock.Anything).Return(nil)
+				recorderMock.On("measureProxyErrors", mock.Anything).Return(nil)
+				for _, m := range tt.measured {
+					recorderMock.On("measureLimitsExceeded", m[0], m[1]).Return(nil).Once()
+				}
+				api, err := NewAPI(ProxyConfig{
+					Logger:           log.NewNopLogger(),
+					ConversionConfig: ConversionConfig{Limits: limits},
+				}, remoteWriteMock, recorderMock)
+				require.NoError(t, err)
+
+				req := httptest.NewRequest("POST", "/api/put", strings.NewReader(tt.body))
+				rec := httptest.NewRecorder()
+				api.handleOpenTSDBPut(rec, req)
+
+				assert.Equal(t, tt.expectedCode, rec.Code)
+				if tt.expectedWrites > 0 {
+					remoteWriteMock.AssertNumberOfCalls(t, "Write", 1)
+				} else {
+					remoteWriteMock.AssertNotCalled(t, "Write", mock.Anything, mock.Anything)
+				}
+				recorderMock.AssertNumberOfCalls(t, "measureLimitsExceeded", len(tt.measured))
+			})
+		}
+	})
+
+	t.Run("collectd", func(t *testing.T) {
+		recorderMock := &MockRecorder{}
+		recorderMock.On("measureLimitsExceeded", "label_value_length", "drop-line").Return(nil).Once()
+		recorderMock.On("measureLimitsExceeded", "points_per_request", "reject-request").Return(nil).Once()
+		conv := newConverter(ConversionConfig{Limits: limits}, log.NewNopLogger(), recorderMock)
+		parse := collectdPacketParser(network.ParseOpts{})
+
+		packet := func(vls ...*api.ValueList) []byte {
+			buf := network.NewBuffer(network.DefaultBufferSize)
+			for _, vl := range vls {
+				require.NoError(t, buf.Write(context.Background(), vl))
+			}
+			b, err := buf.Bytes()
+			require.NoError(t, err)
+			return b
+		}
+		valueList := func(host string) *api.ValueList {
+			return &api.ValueList{
+				Identifier: api.Identifier{Host: host, Plugin: "memory", Type: "memory"},
+				Time:       time.Unix(1465839830, 0),
+				Values:     []api.Value{api.Gauge(1)},
+			}
+		}
+
+		// The value list with a too long host is dropped, and doesn't count
+		// towards the points of the datagram.
+		ts, err := parse(conv, conv.newRequestLimits("collectd-tenant"), packet(valueList("web01"), valueList("a-long-host"), valueList("web02")))
+		assert.ErrorAs(t, err, &invalidPointError{})
+		require.Len(t, ts, 2)
+		assert.Equal(t, "web02", ts[1].Labels[2].Value)
+
+		ts, err = parse(conv, conv.newRequestLimits("collectd-tenant"), packet(valueList("web01"), valueList("web02"), valueList("web03")))
+		assert.EqualError(t, err, "points per request: 3 exceeds the limit of 2")
+		assert.Empty(t, ts)
+		recorderMock.AssertExpectations(t)
+	})
+}
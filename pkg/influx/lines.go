@@ -0,0 +1,186 @@
+package influx
+
+import (
+	"bufio"
+	"bytes"
+	"errors"
+	"fmt"
+	"io"
+)
+
+var errLineTooLong = errors.New("line too long")
+
+// lineReader splits line protocol read from an io.Reader into lines. Unlike a
+// bufio.Scanner, it knows that a newline inside a quoted string field value
+// does not end the line.
+type lineReader struct {
+	r *bufio.Reader
+	// maxLineLength limits the length of a line. Any value less than or equal
+	// to 0 means no limit.
+	maxLineLength int
+	// lineNum is the 1-based number of the last line returned by next.
+	lineNum int
+	// bytesRead is the number of bytes read so far.
+	bytesRead int
+	// eof reports whether the last line returned by next ended the input
+	// rather than a newline.
+	eof bool
+	buf []byte
+}
+
+func newLineReader(r io.Reader, maxLineLength int) *lineReader {
+	return &lineReader{
+		r:             bufio.NewReader(r),
+		maxLineLength: maxLineLength,
+	}
+}
+
+// next returns the next line without its trailing newline. The returned slice
+// is only valid until the following call. Lines longer than maxLineLength are
+// skipped and reported with errLineTooLong. At the end of the input, next
+// returns io.EOF.
+func (lr *lineReader) next() ([]byte, error) {
+	if lr.eof {
+		return nil, io.EOF
+	}
+	lr.buf = lr.buf[:0]
+	tooLong := false
+	for {
+		chunk, err := lr.r.ReadSlice('\n')
+		lr.bytesRead += len(chunk)
+		if !tooLong {
+			lr.buf = append(lr.buf, chunk...)
+		}
+		if lr.maxLineLength > 0 && len(lr.buf) > lr.maxLineLength+1 {
+			tooLong = true
+			lr.buf = lr.buf[:0]
+		}
+
+		switch {
+		case errors.Is(err, bufio.ErrBufferFull):
+			continue
+		case errors.Is(err, io.EOF):
+			if len(chunk) == 0 && len(lr.buf) == 0 && !tooLong {
+				return nil, io.EOF
+			}
+			lr.eof = true
+		case err != nil:
+			return nil, err
+		default:
+			// A newline inside a quoted string doesn't end the line.
+			if !tooLong {
+				if end, _ := scanLine(lr.buf, 0); end == len(lr.buf) {
+					continue
+				}
+			}
+		}
+
+		lr.lineNum++
+		if tooLong {
+			return nil, fmt.Errorf("%w: more than %d bytes", errLineTooLong, lr.maxLineLength)
+		}
+		return bytes.TrimSuffix(lr.buf, []byte{'\n'}), nil
+	}
+}
+
+// maxKeptLineErrors limits the number of invalid lines of a request kept to be
+// reported, so that the memory used by a request doesn't grow with the number
+// of its invalid lines.
+const maxKeptLineErrors = 100
+
+// lineError is a line of line protocol that could not be parsed.
+type lineError struct {
+	lineNum int
+	line    string
+	err     error
+}
+
+// lineErrors are the invalid lines of a request. Only the first
+// maxKeptLineErrors are kept, the others are only counted.
+type lineErrors struct {
+	errs []lineError
+	// reasons counts the invalid lines, kept or not, by the reason they are
+	// measured with.
+	reasons map[string]int
+}
+
+// add records the invalid line le, keeping it if there is room left.
+func (e *lineErrors) add(le lineError) {
+	if len(e.errs) < maxKeptLineErrors {
+		e.errs = append(e.errs, le)
+	}
+	if e.reasons == nil {
+		e.reasons = map[string]int{}
+	}
+	e.reasons[lineErrorReason(le.err)]++
+}
+
+// count returns the number of invalid lines, kept or not.
+func (e lineErrors) count() int {
+	n := 0
+	for _, c := range e.reasons {
+		n += c
+	}
+	return n
+}
+
+// scanLine returns the end of the line starting at buf[i], which is either
+// the position of the newline ending it or len(buf).
+//
+// Copied from the unexported scanLine of https://github.com/influxdata/influxdb/blob/v2.7.12/models/points.go
+func scanLine(buf []byte, i int) (int, []byte) {
+	start := i
+	quoted := false
+	fields := false
+
+	// tracks how many '=' and commas we've seen
+	// this duplicates some of the functionality in scanFields
+	equals := 0
+	commas := 0
+	for {
+		// reached the end of buf?
+		if i >= len(buf) {
+			break
+		}
+
+		// skip past escaped characters
+		if buf[i] == '\\' && i+2 < len(buf) {
+			i += 2
+			continue
+		}
+
+		if buf[i] == ' ' {
+			fields = true
+		}
+
+		// If we see a double quote, makes sure it is not escaped
+		if fields {
+			if !quoted && buf[i] == '=' {
+				i++
+				equals++
+				continue
+			} else if !quoted && buf[i] == ',' {
+				i++
+				commas++
+				continue
+			} else if buf[i] == '"' && equals > commas {
+				i++
+				quoted = !quoted
+				continue
+			}
+		}
+
+		if buf[i] == '\n' && !quoted {
+			break
+		}
+		i++
+	}
+
+	return i, buf[start:i]
+}
+
+// isBlankOrComment reports whether line holds no point.
+func isBlankOrComment(line []byte) bool {
+	line = bytes.TrimLeft(line, " \t\r")
+	return len(line) == 0 || line[0] == '#'
+}
@@ -0,0 +1,63 @@
+package influx
+
+import (
+	"errors"
+	"io"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestLineReader(t *testing.T) {
+	tests := map[string]struct {
+		data          string
+		maxLineLength int
+		expectedLines []string
+		expectedErrs  []int
+	}{
+		"simple lines": {
+			data:          "m f=1\nm f=2\n",
+			expectedLines: []string{"m f=1", "m f=2"},
+		},
+		"no trailing newline": {
+			data:          "m f=1\nm f=2",
+			expectedLines: []string{"m f=1", "m f=2"},
+		},
+		"newline in quoted string": {
+			data:          "m f=\"a\nb\",g=1\nm f=2",
+			expectedLines: []string{"m f=\"a\nb\",g=1", "m f=2"},
+		},
+		"line too long": {
+			data:          "m f=1\nm f=1234567890\nm f=2",
+			maxLineLength: 8,
+			expectedLines: []string{"m f=1", "", "m f=2"},
+			expectedErrs:  []int{2},
+		},
+	}
+
+	for name, tt := range tests {
+		t.Run(name, func(t *testing.T) {
+			lr := newLineReader(strings.NewReader(tt.data), tt.maxLineLength)
+			var lines []string
+			var errLines []int
+			for {
+				line, err := lr.next()
+				if errors.Is(err, io.EOF) {
+					break
+				}
+				if errors.Is(err, errLineTooLong) {
+					errLines = append(errLines, lr.lineNum)
+				} else {
+					require.NoError(t, err)
+				}
+				lines = append(lines, string(line))
+			}
+			assert.Equal(t, tt.expectedLines, lines)
+			assert.Equal(t, tt.expectedErrs, errLines)
+			assert.Equal(t, len(tt.data), lr.bytesRead)
+			assert.Equal(t, !strings.HasSuffix(tt.data, "\n"), lr.eof)
+		})
+	}
+}
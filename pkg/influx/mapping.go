@@ -0,0 +1,108 @@
+package influx
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"strings"
+
+	"github.com/grafana/dskit/tenant"
+	"github.com/grafana/dskit/user"
+	"github.com/grafana/mimir-graphite/v2/pkg/errorx"
+	"github.com/grafana/mimir/pkg/mimirpb"
+)
+
+// Modes for mapping a write query parameter, such as the InfluxDB 1.x
+// database or the InfluxDB 2.x bucket, onto the written series.
+const (
+	// MappingIgnore discards the parameter.
+	MappingIgnore = "ignore"
+	// MappingLabel adds the parameter value as a label to every series.
+	MappingLabel = "label"
+	// MappingTenant uses the parameter value as the tenant (X-Scope-OrgID) of
+	// the write, replacing the tenant of the incoming request. It can't be used
+	// when authentication is enabled, as clients could then write to any
+	// tenant.
+	MappingTenant = "tenant"
+)
+
+// ParamMapping configures what the proxy does with the value of a write query
+// parameter.
+type ParamMapping struct {
+	// Mode is one of MappingIgnore, MappingLabel or MappingTenant. Empty means
+	// MappingIgnore.
+	Mode string
+	// Label is the name of the label added when Mode is MappingLabel.
+	Label string
+}
+
+// RegisterFlagsWithPrefix registers the flags of the mapping for the query
+// parameter called param.
+func (m *ParamMapping) RegisterFlagsWithPrefix(prefix, param string, flags *flag.FlagSet) {
+	flags.StringVar(&m.Mode, prefix+".mapping", MappingIgnore, fmt.Sprintf("what to do with the %s query parameter: %s, %s or %s", param, MappingIgnore, MappingLabel, MappingTenant))
+	flags.StringVar(&m.Label, prefix+".label", param, fmt.Sprintf("label name to use for the %s query parameter when its mapping is %s", param, MappingLabel))
+}
+
+// Validate checks the mapping is usable.
+func (m ParamMapping) Validate() error {
+	switch m.Mode {
+	case "", MappingIgnore, MappingTenant:
+		return nil
+	case MappingLabel:
+		name := m.Label
+		replaceInvalidChars(&name)
+		if name == "" || name != m.Label || strings.HasPrefix(name, "__") {
+			return fmt.Errorf("invalid label name %q", m.Label)
+		}
+		return nil
+	}
+	return fmt.Errorf("invalid mapping %q", m.Mode)
+}
+
+// validateMappings checks that the given mappings are valid and that at most
+// one of them chooses the tenant.
+func validateMappings(mappings map[string]ParamMapping) error {
+	tenants := 0
+	for param, m := range mappings {
+		if err := m.Validate(); err != nil {
+			return fmt.Errorf("%s: %w", param, err)
+		}
+		if m.Mode == MappingTenant {
+			tenants++
+		}
+	}
+	if tenants > 1 {
+		return fmt.Errorf("at most one query parameter can be mapped to the tenant")
+	}
+	return nil
+}
+
+// mapsTenant reports whether any of the mappings chooses the tenant.
+func mapsTenant(mappings ...ParamMapping) bool {
+	for _, m := range mappings {
+		if m.Mode == MappingTenant {
+			return true
+		}
+	}
+	return false
+}
+
+// apply maps value according to the mapping. Empty values are ignored. It
+// returns the context carrying the tenant of the write, and the labels with any
+// new label appended. A value that isn't a valid tenant ID is rejected when it
+// chooses the tenant, rather than failing later in the remote write.
+func (m ParamMapping) apply(ctx context.Context, value string, lbls []mimirpb.LabelAdapter) (context.Context, []mimirpb.LabelAdapter, error) {
+	if value == "" {
+		return ctx, lbls, nil
+	}
+	switch m.Mode {
+	case MappingLabel:
+		lbls = append(lbls, mimirpb.LabelAdapter{Name: m.Label, Value: value})
+	case MappingTenant:
+		if err := tenant.ValidTenantID(value); err != nil {
+			return nil, nil, errorx.BadRequest{Msg: fmt.Sprintf("invalid tenant: %v", err), Err: err}
+		}
+		ctx = user.InjectOrgID(ctx, value)
+	}
+	return ctx, lbls, nil
+}
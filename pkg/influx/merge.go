@@ -0,0 +1,76 @@
+package influx
+
+import (
+	"sort"
+	"strings"
+
+	"github.com/grafana/mimir/pkg/mimirpb"
+)
+
+// mergeSeries merges the series with the same labels into the first of them,
+// keeping the order in which they first appear. The samples of each merged
+// series are sorted by timestamp, and the last one written wins among those
+// with the same timestamp, like lastSamples does, as InfluxDB overwrites them
+// and Mimir would reject them. Histogram samples are only sorted.
+// The labels of each series must be sorted, as the converter returns them.
+func mergeSeries(ts []mimirpb.TimeSeries) []mimirpb.TimeSeries {
+	if len(ts) < 2 {
+		return ts
+	}
+
+	merged := make([]mimirpb.TimeSeries, 0, len(ts))
+	index := make(map[string]int, len(ts))
+	// unsorted are the merged series whose samples need sorting.
+	unsorted := map[int]bool{}
+	var sb strings.Builder
+	for _, s := range ts {
+		sb.Reset()
+		writeSeriesKey(&sb, s.Labels)
+		key := sb.String()
+		i, ok := index[key]
+		if !ok {
+			index[key] = len(merged)
+			merged = append(merged, s)
+			continue
+		}
+		merged[i].Samples = append(merged[i].Samples, s.Samples...)
+		merged[i].Histograms = append(merged[i].Histograms, s.Histograms...)
+		merged[i].Exemplars = append(merged[i].Exemplars, s.Exemplars...)
+		unsorted[i] = true
+	}
+
+	for i := range unsorted {
+		merged[i].Samples = sortSamples(merged[i].Samples)
+		sort.SliceStable(merged[i].Histograms, func(j, k int) bool {
+			return merged[i].Histograms[j].Timestamp < merged[i].Histograms[k].Timestamp
+		})
+	}
+	return merged
+}
+
+// writeSeriesKey writes a key identifying the sorted labels lbls to sb.
+func writeSeriesKey(sb *strings.Builder, lbls []mimirpb.LabelAdapter) {
+	for _, l := range lbls {
+		sb.WriteString(l.Name)
+		sb.WriteByte('\xff')
+		sb.WriteString(l.Value)
+		sb.WriteByte('\xff')
+	}
+}
+
+// sortSamples sorts samples by timestamp, keeping the last of the samples with
+// the same timestamp in the order they were written.
+func sortSamples(samples []mimirpb.Sample) []mimirpb.Sample {
+	sort.SliceStable(samples, func(i, j int) bool {
+		return samples[i].TimestampMs < samples[j].TimestampMs
+	})
+	deduped := samples[:0]
+	for _, s := range samples {
+		if n := len(deduped); n > 0 && deduped[n-1].TimestampMs == s.TimestampMs {
+			deduped[n-1] = s
+			continue
+		}
+		deduped = append(deduped, s)
+	}
+	return deduped
+}
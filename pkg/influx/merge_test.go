@@ -0,0 +1,115 @@
+package influx
+
+import (
+	"math"
+	"testing"
+	"time"
+
+	"github.com/grafana/mimir/pkg/mimirpb"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestMergeSeries(t *testing.T) {
+	a := []mimirpb.LabelAdapter{{Name: "__name__", Value: "a"}}
+	b := []mimirpb.LabelAdapter{{Name: "__name__", Value: "b"}}
+	ab := []mimirpb.LabelAdapter{{Name: "__name__", Value: "a"}, {Name: "b", Value: ""}}
+	series := func(lbls []mimirpb.LabelAdapter, samples ...mimirpb.Sample) mimirpb.TimeSeries {
+		return mimirpb.TimeSeries{Labels: lbls, Samples: samples}
+	}
+
+	tests := []struct {
+		name     string
+		ts       []mimirpb.TimeSeries
+		expected []mimirpb.TimeSeries
+	}{
+		{
+			name: "none",
+		},
+		{
+			name:     "distinct series",
+			ts:       []mimirpb.TimeSeries{series(b, mimirpb.Sample{TimestampMs: 1, Value: 1}), series(a, mimirpb.Sample{TimestampMs: 1, Value: 2}), series(ab, mimirpb.Sample{TimestampMs: 1, Value: 3})},
+			expected: []mimirpb.TimeSeries{series(b, mimirpb.Sample{TimestampMs: 1, Value: 1}), series(a, mimirpb.Sample{TimestampMs: 1, Value: 2}), series(ab, mimirpb.Sample{TimestampMs: 1, Value: 3})},
+		},
+		{
+			name: "sorted by timestamp",
+			ts: []mimirpb.TimeSeries{
+				series(a, mimirpb.Sample{TimestampMs: 3, Value: 3}),
+				series(b, mimirpb.Sample{TimestampMs: 1, Value: 1}),
+				series(a, mimirpb.Sample{TimestampMs: 1, Value: 1}),
+				series(a, mimirpb.Sample{TimestampMs: 2, Value: 2}),
+			},
+			expected: []mimirpb.TimeSeries{
+				series(a, mimirpb.Sample{TimestampMs: 1, Value: 1}, mimirpb.Sample{TimestampMs: 2, Value: 2}, mimirpb.Sample{TimestampMs: 3, Value: 3}),
+				series(b, mimirpb.Sample{TimestampMs: 1, Value: 1}),
+			},
+		},
+		{
+			name: "exact duplicates dropped",
+			ts: []mimirpb.TimeSeries{
+				series(a, mimirpb.Sample{TimestampMs: 1, Value: 1}),
+				series(a, mimirpb.Sample{TimestampMs: 1, Value: 1}),
+				series(a, mimirpb.Sample{TimestampMs: 2, Value: math.Inf(1)}),
+				series(a, mimirpb.Sample{TimestampMs: 2, Value: math.Inf(1)}),
+			},
+			expected: []mimirpb.TimeSeries{
+				series(a, mimirpb.Sample{TimestampMs: 1, Value: 1}, mimirpb.Sample{TimestampMs: 2, Value: math.Inf(1)}),
+			},
+		},
+		{
+			name: "last sample with the same timestamp wins",
+			ts: []mimirpb.TimeSeries{
+				series(a, mimirpb.Sample{TimestampMs: 2, Value: 1}),
+				series(a, mimirpb.Sample{TimestampMs: 1, Value: 1}),
+				series(a, mimirpb.Sample{TimestampMs: 2, Value: 3}),
+				series(a, mimirpb.Sample{TimestampMs: 1, Value: 2}),
+				series(a, mimirpb.Sample{TimestampMs: 2, Value: 2}),
+			},
+			expected: []mimirpb.TimeSeries{
+				series(a, mimirpb.Sample{TimestampMs: 1, Value: 2}, mimirpb.Sample{TimestampMs: 2, Value: 2}),
+			},
+		},
+		{
+			name: "histograms sorted by timestamp",
+			ts: []mimirpb.TimeSeries{
+				{Labels: a, Histograms: []mimirpb.Histogram{{Timestamp: 2, Sum: 2}}},
+				{Labels: a, Histograms: []mimirpb.Histogram{{Timestamp: 1, Sum: 1}}},
+			},
+			expected: []mimirpb.TimeSeries{
+				{Labels: a, Histograms: []mimirpb.Histogram{{Timestamp: 1, Sum: 1}, {Timestamp: 2, Sum: 2}}},
+			},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			assert.Equal(t, tt.expected, mergeSeries(tt.ts))
+		})
+	}
+}
+
+func TestMergeSeriesNaN(t *testing.T) {
+	a := []mimirpb.LabelAdapter{{Name: "__name__", Value: "a"}}
+	merged := mergeSeries([]mimirpb.TimeSeries{
+		{Labels: a, Samples: []mimirpb.Sample{{TimestampMs: 1, Value: math.NaN()}}},
+		{Labels: a, Samples: []mimirpb.Sample{{TimestampMs: 1, Value: math.NaN()}}},
+	})
+	assert.Len(t, merged, 1)
+	assert.Len(t, merged[0].Samples, 1)
+}
+
+func TestNewWriteRequestMergesSeries(t *testing.T) {
+	points, err := parsePointsWithPrecision([]byte("m,t=1 f=1,g=1 1\nm,t=1 f=2 2\nm,t=1 f=3 1\n"), time.Now(), "s")
+	require.NoError(t, err)
+	ts, err := testConverter().writeRequestFromInfluxPoints("fake", points, nil)
+	require.NoError(t, err)
+	require.Len(t, ts, 4)
+
+	req := newWriteRequest(ts, nil)
+	require.Len(t, req.Timeseries, 2)
+	for _, s := range req.Timeseries {
+		if s.Labels[0].Value == "m_f" {
+			assert.Equal(t, []mimirpb.Sample{{TimestampMs: 1000, Value: 3}, {TimestampMs: 2000, Value: 2}}, s.Samples)
+		}
+	}
+}
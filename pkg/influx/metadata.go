@@ -0,0 +1,281 @@
+package influx
+
+import (
+	"flag"
+	"fmt"
+	"regexp"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/grafana/mimir/pkg/mimirpb"
+)
+
+const (
+	// maxTrackedMetadata bounds the memory used by the metadata of the metric
+	// families and the times it was sent: beyond it, new families get no
+	// metadata, and metadata is sent with every write.
+	maxTrackedMetadata = 10000
+
+	// DefaultMetadataSendInterval matches the default of Prometheus.
+	DefaultMetadataSendInterval = time.Minute
+)
+
+// metricTypes are the metric types of the metadata rules.
+var metricTypes = map[string]mimirpb.MetricMetadata_MetricType{
+	"counter":        mimirpb.COUNTER,
+	"gauge":          mimirpb.GAUGE,
+	"histogram":      mimirpb.HISTOGRAM,
+	"gaugehistogram": mimirpb.GAUGEHISTOGRAM,
+	"summary":        mimirpb.SUMMARY,
+	"info":           mimirpb.INFO,
+	"stateset":       mimirpb.STATESET,
+	"unknown":        mimirpb.UNKNOWN,
+}
+
+// MetadataConfig configures the metadata (type, unit and help) sent with the
+// series converted from Influx points.
+type MetadataConfig struct {
+	// Rules assign metadata to the series of the fields they match. The first
+	// matching rule applies.
+	Rules []MetadataRule `yaml:"rules"`
+	// TelegrafCatalog applies the built-in rules of the common Telegraf input
+	// plugins after Rules, which can override them.
+	TelegrafCatalog bool `yaml:"telegraf_catalog"`
+	// EnforceSuffixes adds the suffixes required by OpenMetrics to the names
+	// of the series with metadata: the unit, and _total for counters or _info
+	// for info metrics.
+	EnforceSuffixes bool `yaml:"enforce_suffixes"`
+	// SendInterval is how often the metadata of a metric family is sent for
+	// each tenant. Any value less than or equal to 0 means
+	// DefaultMetadataSendInterval.
+	SendInterval time.Duration `yaml:"send_interval"`
+}
+
+func (c *MetadataConfig) RegisterFlags(flags *flag.FlagSet) {
+	flags.BoolVar(&c.TelegrafCatalog, "metadata.telegraf.catalog", false, "send the metadata of the fields of the common Telegraf input plugins, after the configured rules")
+	flags.BoolVar(&c.EnforceSuffixes, "metadata.enforce.suffixes", false, "add the OpenMetrics unit and _total or _info suffixes to the names of series with metadata")
+	flags.DurationVar(&c.SendInterval, "metadata.send.interval", DefaultMetadataSendInterval, "how often the metadata of a metric family is sent for each tenant")
+}
+
+// Validate checks the configuration is usable.
+func (c MetadataConfig) Validate() error {
+	for i, rule := range c.Rules {
+		if _, err := rule.compile(); err != nil {
+			return fmt.Errorf("invalid metadata rule %d: %w", i+1, err)
+		}
+	}
+	return nil
+}
+
+// MetadataRule assigns metadata to the series of some fields.
+type MetadataRule struct {
+	// Measurement is a regular expression matching the whole measurement.
+	// Empty matches any.
+	Measurement string `yaml:"measurement"`
+	// Field is a regular expression matching the whole field. Empty matches
+	// any.
+	Field string `yaml:"field"`
+	// Type is one of counter, gauge, histogram, gaugehistogram, summary, info,
+	// stateset or unknown. Empty means unknown.
+	Type string `yaml:"type"`
+	// Unit is the unit of the series, such as seconds or bytes.
+	Unit string `yaml:"unit"`
+	// Help describes the series.
+	Help string `yaml:"help"`
+}
+
+// metadataRule is a compiled MetadataRule.
+type metadataRule struct {
+	measurement, field *regexp.Regexp
+	metricType         mimirpb.MetricMetadata_MetricType
+	unit, help         string
+}
+
+func (r MetadataRule) compile() (metadataRule, error) {
+	compiled := metadataRule{unit: r.Unit, help: r.Help}
+	var err error
+	if compiled.measurement, err = compileAnchored(r.Measurement); err != nil {
+		return compiled, fmt.Errorf("invalid measurement: %w", err)
+	}
+	if compiled.field, err = compileAnchored(r.Field); err != nil {
+		return compiled, fmt.Errorf("invalid field: %w", err)
+	}
+	var ok bool
+	if compiled.metricType, ok = metricTypes[strings.ToLower(r.Type)]; !ok && r.Type != "" {
+		return compiled, fmt.Errorf("invalid type %q", r.Type)
+	}
+	unit := r.Unit
+	replaceInvalidChars(&unit)
+	if unit != r.Unit {
+		return compiled, fmt.Errorf("invalid unit %q", r.Unit)
+	}
+	return compiled, nil
+}
+
+// compileAnchored compiles a regular expression matching whole strings, or
+// returns nil for an empty one.
+func compileAnchored(expr string) (*regexp.Regexp, error) {
+	if expr == "" {
+		return nil, nil
+	}
+	return regexp.Compile("^(?:" + expr + ")$")
+}
+
+func (r metadataRule) matches(measurement, field string) bool {
+	return (r.measurement == nil || r.measurement.MatchString(measurement)) &&
+		(r.field == nil || r.field.MatchString(field))
+}
+
+// metadataTracker assigns metadata to the series converted by a converter,
+// and tells when it is due to be sent for each tenant. It is safe for
+// concurrent use.
+type metadataTracker struct {
+	rules           []metadataRule
+	enforceSuffixes bool
+	sendInterval    time.Duration
+
+	mu sync.RWMutex
+	// families are the metadata of the metric families named by the rules, or
+	// assembled from histograms and summaries, by the names of their series.
+	families map[string]mimirpb.MetricMetadata
+	// sent are the times the metadata of a family was last sent, by tenant and
+	// family.
+	sent map[string]time.Time
+}
+
+func newMetadataTracker(cfg MetadataConfig) *metadataTracker {
+	t := &metadataTracker{
+		enforceSuffixes: cfg.EnforceSuffixes,
+		sendInterval:    cfg.SendInterval,
+		families:        map[string]mimirpb.MetricMetadata{},
+		sent:            map[string]time.Time{},
+	}
+	if t.sendInterval <= 0 {
+		t.sendInterval = DefaultMetadataSendInterval
+	}
+	rules := cfg.Rules
+	if cfg.TelegrafCatalog {
+		rules = append(rules[:len(rules):len(rules)], telegrafCatalog...)
+	}
+	for _, rule := range rules {
+		// Validated with the config, or by the tests of the catalog.
+		compiled, _ := rule.compile()
+		t.rules = append(t.rules, compiled)
+	}
+	return t
+}
+
+// name applies the first rule matching field in measurement to the name of its
+// series, returning the name with the suffixes it enforces. The metadata of
+// the series is recorded to be sent along with it.
+func (t *metadataTracker) name(measurement, field, name string) string {
+	for _, rule := range t.rules {
+		if !rule.matches(measurement, field) {
+			continue
+		}
+		if t.enforceSuffixes {
+			name = withSuffixes(name, rule.metricType, rule.unit)
+		}
+		t.record(mimirpb.MetricMetadata{Type: rule.metricType, MetricFamilyName: name, Help: rule.help, Unit: rule.unit})
+		return name
+	}
+	return name
+}
+
+// record records the metadata of the metric family whose series have the given
+// names, or are named after the family.
+func (t *metadataTracker) record(md mimirpb.MetricMetadata, names ...string) {
+	if len(names) == 0 {
+		names = []string{md.MetricFamilyName}
+	}
+	if t.recorded(md, names) {
+		return
+	}
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	for _, name := range names {
+		if _, ok := t.families[name]; ok || len(t.families) < maxTrackedMetadata {
+			t.families[name] = md
+		}
+	}
+}
+
+// recorded tells whether md is already recorded for all the names, so that
+// the series converted for known families don't contend for the lock.
+func (t *metadataTracker) recorded(md mimirpb.MetricMetadata, names []string) bool {
+	t.mu.RLock()
+	defer t.mu.RUnlock()
+	for _, name := range names {
+		if recorded, ok := t.families[name]; !ok || recorded != md {
+			return false
+		}
+	}
+	return true
+}
+
+// due returns the metadata of the metric families of ts that wasn't sent for
+// tenant in the last send interval, and records it as sent at now.
+func (t *metadataTracker) due(tenant string, ts []mimirpb.TimeSeries, now time.Time) []*mimirpb.MetricMetadata {
+	var due []*mimirpb.MetricMetadata
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	if len(t.families) == 0 {
+		return nil
+	}
+	for _, s := range ts {
+		md, ok := t.families[metricName(s.Labels)]
+		if !ok {
+			continue
+		}
+		key := tenant + "\xff" + md.MetricFamilyName
+		if last, ok := t.sent[key]; ok && now.Sub(last) < t.sendInterval {
+			continue
+		}
+		if len(t.sent) >= maxTrackedMetadata {
+			t.expire(now)
+		}
+		if len(t.sent) < maxTrackedMetadata {
+			t.sent[key] = now
+		}
+		due = append(due, &md)
+	}
+	return due
+}
+
+// expire forgets the families whose metadata is due to be sent again anyway.
+func (t *metadataTracker) expire(now time.Time) {
+	for key, last := range t.sent {
+		if now.Sub(last) >= t.sendInterval {
+			delete(t.sent, key)
+		}
+	}
+}
+
+// withSuffixes returns name with the unit suffix and the suffix of the metric
+// type required by OpenMetrics, if it lacks them.
+func withSuffixes(name string, metricType mimirpb.MetricMetadata_MetricType, unit string) string {
+	var typeSuffix string
+	switch metricType {
+	case mimirpb.COUNTER:
+		typeSuffix = "_total"
+	case mimirpb.INFO:
+		typeSuffix = "_info"
+	}
+	base := strings.TrimSuffix(name, typeSuffix)
+	if unit != "" && !strings.HasSuffix(base, "_"+unit) {
+		base += "_" + unit
+	}
+	return base + typeSuffix
+}
+
+// metricName returns the value of the __name__ label of the sorted labels
+// lbls.
+func metricName(lbls []mimirpb.LabelAdapter) string {
+	for _, l := range lbls {
+		if l.Name == "__name__" {
+			return l.Value
+		}
+	}
+	return ""
+}
@@ -0,0 +1,114 @@
+package influx
+
+import (
+	"bytes"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/go-kit/log"
+	"github.com/grafana/dskit/user"
+	"github.com/grafana/mimir-graphite/v2/pkg/remotewrite/remotewritemock"
+	"github.com/grafana/mimir/pkg/mimirpb"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/mock"
+	"github.com/stretchr/testify/require"
+)
+
+func TestMetadataTracker(t *testing.T) {
+	cfg := MetadataConfig{
+		Rules: []MetadataRule{
+			{Measurement: "net", Field: "bytes_.*", Type: "counter", Unit: "bytes", Help: "Bytes transferred."},
+			{Measurement: "net", Type: "gauge"},
+			{Measurement: "cpu", Field: "usage_.*", Type: "gauge", Unit: "percent"},
+		},
+		EnforceSuffixes: true,
+		SendInterval:    time.Minute,
+	}
+	require.NoError(t, cfg.Validate())
+	tracker := newMetadataTracker(cfg)
+
+	assert.Equal(t, "net_bytes_recv_bytes_total", tracker.name("net", "bytes_recv", "net_bytes_recv"))
+	assert.Equal(t, "net_bytes_sent_bytes_total", tracker.name("net", "bytes_sent", "net_bytes_sent_total"))
+	assert.Equal(t, "net_drop_in", tracker.name("net", "drop_in", "net_drop_in"))
+	assert.Equal(t, "cpu_usage_idle_percent", tracker.name("cpu", "usage_idle", "cpu_usage_idle"))
+	assert.Equal(t, "mem_used", tracker.name("mem", "used", "mem_used"))
+
+	ts := []mimirpb.TimeSeries{
+		{Labels: []mimirpb.LabelAdapter{{Name: "__name__", Value: "net_bytes_recv_bytes_total"}, {Name: "host", Value: "a"}}},
+		{Labels: []mimirpb.LabelAdapter{{Name: "__name__", Value: "net_bytes_recv_bytes_total"}, {Name: "host", Value: "b"}}},
+		{Labels: []mimirpb.LabelAdapter{{Name: "__name__", Value: "net_drop_in"}}},
+		{Labels: []mimirpb.LabelAdapter{{Name: "__name__", Value: "mem_used"}}},
+	}
+	now := time.Now()
+	expected := []*mimirpb.MetricMetadata{
+		{Type: mimirpb.COUNTER, MetricFamilyName: "net_bytes_recv_bytes_total", Help: "Bytes transferred.", Unit: "bytes"},
+		{Type: mimirpb.GAUGE, MetricFamilyName: "net_drop_in"},
+	}
+	assert.Equal(t, expected, tracker.due("a", ts, now))
+	// Sent once per interval for each tenant.
+	assert.Empty(t, tracker.due("a", ts, now.Add(time.Second)))
+	assert.Equal(t, expected, tracker.due("b", ts, now.Add(time.Second)))
+	assert.Equal(t, expected, tracker.due("a", ts, now.Add(time.Minute)))
+}
+
+func TestWithSuffixes(t *testing.T) {
+	tests := []struct {
+		name, unit, expected string
+		metricType           mimirpb.MetricMetadata_MetricType
+	}{
+		{name: "requests", metricType: mimirpb.COUNTER, expected: "requests_total"},
+		{name: "requests_total", metricType: mimirpb.COUNTER, expected: "requests_total"},
+		{name: "io_time", metricType: mimirpb.COUNTER, unit: "seconds", expected: "io_time_seconds_total"},
+		{name: "io_time_seconds_total", metricType: mimirpb.COUNTER, unit: "seconds", expected: "io_time_seconds_total"},
+		{name: "mem_used", metricType: mimirpb.GAUGE, unit: "bytes", expected: "mem_used_bytes"},
+		{name: "build", metricType: mimirpb.INFO, expected: "build_info"},
+		{name: "temp", metricType: mimirpb.UNKNOWN, expected: "temp"},
+	}
+	for _, tt := range tests {
+		assert.Equal(t, tt.expected, withSuffixes(tt.name, tt.metricType, tt.unit), tt.name)
+	}
+}
+
+func TestMetadataConfigValidate(t *testing.T) {
+	assert.NoError(t, MetadataConfig{Rules: []MetadataRule{{}}}.Validate())
+	assert.Error(t, MetadataConfig{Rules: []MetadataRule{{Measurement: "("}}}.Validate())
+	assert.Error(t, MetadataConfig{Rules: []MetadataRule{{Field: "("}}}.Validate())
+	assert.Error(t, MetadataConfig{Rules: []MetadataRule{{Type: "rate"}}}.Validate())
+	assert.Error(t, MetadataConfig{Rules: []MetadataRule{{Unit: "kilo bytes"}}}.Validate())
+}
+
+func TestHandleSeriesPushMetadata(t *testing.T) {
+	remoteWriteMock := &remotewritemock.Client{}
+	var written []*mimirpb.WriteRequest
+	remoteWriteMock.On("Write", mock.Anything, mock.Anything).Return(nil).Run(func(args mock.Arguments) {
+		written = append(written, args.Get(1).(*mimirpb.WriteRequest))
+	})
+	recorderMock := &MockRecorder{}
+	recorderMock.On("measureMetricsParsed", 1).Return(nil)
+	recorderMock.On("measureMetricsWritten", 1).Return(nil)
+	recorderMock.On("measureConversionDuration", mock.Anything).Return(nil)
+
+	conf := ProxyConfig{
+		Logger:        log.NewNopLogger(),
+		OverridesFile: writeOverrides(t, "defaults:\n  metadata:\n    enforce_suffixes: true\n    rules:\n      - measurement: http\n        field: requests\n        type: counter\n"),
+	}
+	api, err := NewAPI(conf, remoteWriteMock, recorderMock)
+	require.NoError(t, err)
+
+	for _, tenant := range []string{"a", "a", "b"} {
+		req := httptest.NewRequest("POST", "/api/v1/push/influx/write", bytes.NewReader([]byte("http requests=10i 1465839830100400200")))
+		req = req.WithContext(user.InjectOrgID(req.Context(), tenant))
+		rec := httptest.NewRecorder()
+		api.handleSeriesPush(rec, req)
+		require.Equal(t, http.StatusNoContent, rec.Code)
+	}
+
+	require.Len(t, written, 3)
+	expected := []*mimirpb.MetricMetadata{{Type: mimirpb.COUNTER, MetricFamilyName: "http_requests_total"}}
+	assert.Equal(t, "http_requests_total", written[0].Timeseries[0].Labels[0].Value)
+	assert.Equal(t, expected, written[0].Metadata)
+	assert.Empty(t, written[1].Metadata)
+	assert.Equal(t, expected, written[2].Metadata)
+}
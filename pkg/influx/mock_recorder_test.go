@@ -32,6 +32,46 @@ func (_m *MockRecorder) measureConversionDuration(duration time.Duration) {
 	_m.Called(duration)
 }
 
+// measureFailedLines provides a mock function with given fields: reason, count
+func (_m *MockRecorder) measureFailedLines(reason string, count int) {
+	_m.Called(reason, count)
+}
+
+// measureHistograms provides a mock function with given fields: format
+func (_m *MockRecorder) measureHistograms(format string) {
+	_m.Called(format)
+}
+
+// measureLabelCollisions provides a mock function with given fields: policy
+func (_m *MockRecorder) measureLabelCollisions(policy string) {
+	_m.Called(policy)
+}
+
+// measureLimitsExceeded provides a mock function with given fields: limit, action
+func (_m *MockRecorder) measureLimitsExceeded(limit string, action string) {
+	_m.Called(limit, action)
+}
+
+// measureLargeIntegers provides a mock function with given fields: policy
+func (_m *MockRecorder) measureLargeIntegers(policy string) {
+	_m.Called(policy)
+}
+
+// measureMessagesDropped provides a mock function with given fields: listener, reason
+func (_m *MockRecorder) measureMessagesDropped(listener string, reason string) {
+	_m.Called(listener, reason)
+}
+
+// measureReadErrors provides a mock function with given fields: listener
+func (_m *MockRecorder) measureReadErrors(listener string) {
+	_m.Called(listener)
+}
+
+// measureMessagesReceived provides a mock function with given fields: listener
+func (_m *MockRecorder) measureMessagesReceived(listener string) {
+	_m.Called(listener)
+}
+
 // measureMetricsParsed provides a mock function with given fields: count
 func (_m *MockRecorder) measureMetricsParsed(count int) {
 	_m.Called(count)
@@ -42,7 +82,32 @@ func (_m *MockRecorder) measureMetricsWritten(count int) {
 	_m.Called(count)
 }
 
+// measureOpenTSDBPoints provides a mock function with given fields: transport, result, count
+func (_m *MockRecorder) measureOpenTSDBPoints(transport string, result string, count int) {
+	_m.Called(transport, result, count)
+}
+
 // measureProxyErrors provides a mock function with given fields: reason
 func (_m *MockRecorder) measureProxyErrors(reason string) {
 	_m.Called(reason)
 }
+
+// measureRelabelDropped provides a mock function with given fields: tenant, rule, action
+func (_m *MockRecorder) measureRelabelDropped(tenant string, rule string, action string) {
+	_m.Called(tenant, rule, action)
+}
+
+// measureRequestTooLarge provides a mock function with given fields: reason
+func (_m *MockRecorder) measureRequestTooLarge(reason string) {
+	_m.Called(reason)
+}
+
+// measureSampleValidation provides a mock function with given fields: tenant, reason, action
+func (_m *MockRecorder) measureSampleValidation(tenant string, reason string, action string) {
+	_m.Called(tenant, reason, action)
+}
+
+// measureStringFieldsDropped provides a mock function with given fields: reason
+func (_m *MockRecorder) measureStringFieldsDropped(reason string) {
+	_m.Called(reason)
+}
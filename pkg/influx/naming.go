@@ -0,0 +1,261 @@
+package influx
+
+import (
+	"flag"
+	"fmt"
+	"strings"
+
+	"github.com/grafana/mimir/pkg/mimirpb"
+	"github.com/prometheus/common/model"
+)
+
+// Schemes for naming the series converted from Influx points.
+const (
+	// NamingDefault names series measurement_field, or measurement for the
+	// fields called value, with the separator, prefix and suffix of the
+	// NamingConfig.
+	NamingDefault = "default"
+	// NamingFieldLabel names series after their measurement and adds the name
+	// of the field as a label.
+	NamingFieldLabel = "field-label"
+	// NamingTelegrafV1 names series the way metric_version = 1 of Telegraf's
+	// outputs.prometheus_client does.
+	NamingTelegrafV1 = "telegraf-v1"
+	// NamingTelegrafV2 names series the way metric_version = 2 of Telegraf's
+	// outputs.prometheus_client does.
+	NamingTelegrafV2 = "telegraf-v2"
+
+	// escapingLabel records the escaping of the names of the series converted
+	// with an escaping other than underscores, so that it can be reversed.
+	escapingLabel = "__name_escaping__"
+)
+
+// NamingConfig configures how the series converted from Influx points are
+// named.
+type NamingConfig struct {
+	// Scheme is one of NamingDefault, NamingFieldLabel, NamingTelegrafV1 or
+	// NamingTelegrafV2. Empty means NamingDefault.
+	Scheme string `yaml:"scheme"`
+	// Separator joins measurements and fields with NamingDefault. Empty means
+	// an underscore.
+	Separator string `yaml:"separator"`
+	// Prefix is prepended to the name of every series, such as a namespace
+	// followed by an underscore.
+	Prefix string `yaml:"prefix"`
+	// Suffix is appended to the name of every series.
+	Suffix string `yaml:"suffix"`
+	// FieldLabel is the name of the label of the field with NamingFieldLabel.
+	// Empty means field.
+	FieldLabel string `yaml:"field_label"`
+	// Escaping is how NamingDefault and NamingFieldLabel escape the
+	// characters of measurements, fields and tags that are invalid in legacy
+	// Prometheus names: one of the Prometheus escaping schemes "underscores",
+	// "dots" or "values", or "allow-utf-8" to keep UTF-8 names as they are.
+	// Empty means underscores.
+	Escaping string `yaml:"escaping"`
+}
+
+func (c *NamingConfig) RegisterFlags(flags *flag.FlagSet) {
+	flags.StringVar(&c.Scheme, "naming.scheme", NamingDefault, fmt.Sprintf("how series are named: %s (measurement_field), %s (measurement with a label for the field), %s or %s (like Telegraf's outputs.prometheus_client metric versions)", NamingDefault, NamingFieldLabel, NamingTelegrafV1, NamingTelegrafV2))
+	flags.StringVar(&c.Separator, "naming.separator", "_", fmt.Sprintf("separator between measurements and fields in series names with the %s naming scheme", NamingDefault))
+	flags.StringVar(&c.Prefix, "naming.prefix", "", "prefix of the series names, such as a namespace followed by an underscore")
+	flags.StringVar(&c.Suffix, "naming.suffix", "", "suffix of the series names")
+	flags.StringVar(&c.FieldLabel, "naming.field.label", "field", fmt.Sprintf("label holding the field of series with the %s naming scheme", NamingFieldLabel))
+	flags.StringVar(&c.Escaping, "naming.escaping", model.EscapeUnderscores, fmt.Sprintf("how the %s and %s naming schemes escape the characters invalid in legacy Prometheus names: %s, %s or %s, or %s to keep UTF-8 names", NamingDefault, NamingFieldLabel, model.EscapeUnderscores, model.EscapeDots, model.EscapeValues, model.AllowUTF8))
+}
+
+// Validate checks the configuration is usable.
+func (c NamingConfig) Validate() error {
+	if c.Escaping != "" {
+		if _, err := model.ToEscapingScheme(c.Escaping); err != nil {
+			return fmt.Errorf("invalid escaping %q", c.Escaping)
+		}
+		if c.Escaping != model.EscapeUnderscores && (c.Scheme == NamingTelegrafV1 || c.Scheme == NamingTelegrafV2) {
+			return fmt.Errorf("escaping %q can't be used with the %s naming scheme", c.Escaping, c.Scheme)
+		}
+	}
+	switch c.Scheme {
+	case "", NamingDefault, NamingTelegrafV1, NamingTelegrafV2:
+		return nil
+	case NamingFieldLabel:
+		name := c.FieldLabel
+		replaceInvalidChars(&name)
+		if name != c.FieldLabel || strings.HasPrefix(name, "__") {
+			return fmt.Errorf("invalid field label name %q", c.FieldLabel)
+		}
+		return nil
+	}
+	return fmt.Errorf("invalid naming scheme %q", c.Scheme)
+}
+
+// namer names the series converted from Influx points.
+type namer interface {
+	// metricName returns the name of the series of field in measurement and,
+	// with schemes keeping the field in a label, that label. ok is false if
+	// the series can't be given a valid name.
+	metricName(measurement, field string) (name string, fieldLabel mimirpb.LabelAdapter, ok bool)
+	// labelName returns the label name of a tag or string field. ok is false
+	// if it can't be given a valid name.
+	labelName(key string) (name string, ok bool)
+}
+
+func newNamer(cfg NamingConfig) namer {
+	switch cfg.Scheme {
+	case NamingFieldLabel:
+		fieldLabel := cfg.FieldLabel
+		if fieldLabel == "" {
+			fieldLabel = "field"
+		}
+		return templateNamer{prefix: cfg.Prefix, suffix: cfg.Suffix, fieldLabel: fieldLabel, escaping: nameEscaping(cfg)}
+	case NamingTelegrafV1:
+		return telegrafNamer{version: 1, prefix: cfg.Prefix, suffix: cfg.Suffix}
+	case NamingTelegrafV2:
+		return telegrafNamer{version: 2, prefix: cfg.Prefix, suffix: cfg.Suffix}
+	}
+	separator := cfg.Separator
+	if separator == "" {
+		separator = "_"
+	}
+	return templateNamer{separator: separator, prefix: cfg.Prefix, suffix: cfg.Suffix, escaping: nameEscaping(cfg)}
+}
+
+// nameEscaping returns the escaping of the names of the series named with cfg
+// that is recorded in their escapingLabel, or empty for underscores.
+func nameEscaping(cfg NamingConfig) string {
+	if cfg.Escaping == model.EscapeUnderscores || cfg.Scheme == NamingTelegrafV1 || cfg.Scheme == NamingTelegrafV2 {
+		return ""
+	}
+	return cfg.Escaping
+}
+
+// templateNamer implements NamingDefault, and NamingFieldLabel when
+// fieldLabel is set.
+type templateNamer struct {
+	separator, prefix, suffix string
+	fieldLabel                string
+	// escaping is the Prometheus escaping scheme of the names, or empty for
+	// the underscores of replaceInvalidChars.
+	escaping string
+}
+
+func (n templateNamer) metricName(measurement, field string) (string, mimirpb.LabelAdapter, bool) {
+	if n.fieldLabel != "" {
+		name := n.escape(n.prefix + measurement + n.suffix)
+		return name, mimirpb.LabelAdapter{Name: n.fieldLabel, Value: field}, true
+	}
+
+	name := measurement + n.separator + field
+	if field == "value" {
+		name = measurement
+	}
+	return n.escape(n.prefix + name + n.suffix), mimirpb.LabelAdapter{}, true
+}
+
+func (n templateNamer) labelName(key string) (string, bool) {
+	return n.escape(key), true
+}
+
+// escape escapes the characters of name that are invalid in legacy Prometheus
+// names. Unlike the underscores escaping scheme of Prometheus, the default
+// prefixes names starting with a digit with an underscore rather than
+// replacing it.
+func (n templateNamer) escape(name string) string {
+	switch n.escaping {
+	case "":
+		replaceInvalidChars(&name)
+		return name
+	case model.AllowUTF8:
+		return strings.ToValidUTF8(name, "\uFFFD")
+	}
+	// Validated with the config.
+	scheme, _ := model.ToEscapingScheme(n.escaping)
+	return model.EscapeName(name, scheme)
+}
+
+// telegrafNamer implements the Telegraf naming schemes. Version 1 names series
+// like templateNamer, but only replaces invalid characters. Version 2 doesn't
+// handle fields called value specially, names the series of the prometheus
+// measurement after their field only, drops the invalid first character of
+// names and trims underscores from names it had to sanitize. Label names are
+// sanitized the same way, and the tags whose name starts with an invalid
+// character are dropped by version 1.
+type telegrafNamer struct {
+	version        int
+	prefix, suffix string
+}
+
+func (n telegrafNamer) metricName(measurement, field string) (string, mimirpb.LabelAdapter, bool) {
+	var name string
+	switch {
+	case n.version == 1 && field == "value":
+		name = measurement
+	case n.version == 2 && measurement == "prometheus":
+		name = field
+	default:
+		name = measurement + "_" + field
+	}
+	name = n.prefix + name + n.suffix
+	if n.version == 1 {
+		return strings.Map(telegrafV1Rune(true), name), mimirpb.LabelAdapter{}, true
+	}
+	name, ok := telegrafV2Sanitize(name, true)
+	return name, mimirpb.LabelAdapter{}, ok
+}
+
+func (n telegrafNamer) labelName(key string) (string, bool) {
+	if n.version == 1 {
+		if key == "" || !isLabelNameStart(rune(key[0])) {
+			return "", false
+		}
+		return strings.Map(telegrafV1Rune(false), key), true
+	}
+	return telegrafV2Sanitize(key, false)
+}
+
+// telegrafV1Rune returns the mapping replacing the characters that are
+// invalid in metric names, or in label names if metric is false, by
+// underscores.
+func telegrafV1Rune(metric bool) func(rune) rune {
+	return func(r rune) rune {
+		if isLabelNameStart(r) || (r >= '0' && r <= '9') || (metric && r == ':') {
+			return r
+		}
+		return '_'
+	}
+}
+
+// telegrafV2Sanitize returns name unchanged if it is a valid metric name, or
+// label name if metric is false. Otherwise it drops an invalid first
+// character, replaces the other invalid characters by underscores and trims
+// underscores. ok is false if nothing is left.
+func telegrafV2Sanitize(name string, metric bool) (string, bool) {
+	valid := func(i int, r rune) bool {
+		return isLabelNameStart(r) || (i > 0 && r >= '0' && r <= '9') || (metric && r == ':')
+	}
+	isValid := name != ""
+	for i, r := range name {
+		if !valid(i, r) {
+			isValid = false
+			break
+		}
+	}
+	if isValid {
+		return name, true
+	}
+
+	var sb strings.Builder
+	for i, r := range name {
+		switch {
+		case valid(i, r):
+			sb.WriteRune(r)
+		case i > 0:
+			sb.WriteByte('_')
+		}
+	}
+	name = strings.Trim(sb.String(), "_")
+	return name, name != ""
+}
+
+func isLabelNameStart(r rune) bool {
+	return (r >= 'a' && r <= 'z') || (r >= 'A' && r <= 'Z') || r == '_'
+}
@@ -0,0 +1,158 @@
+package influx
+
+import (
+	"sort"
+	"testing"
+	"time"
+
+	"github.com/go-kit/log"
+	"github.com/grafana/mimir/pkg/mimirpb"
+	"github.com/prometheus/common/model"
+	"github.com/prometheus/prometheus/model/labels"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestNaming(t *testing.T) {
+	tests := []struct {
+		name     string
+		cfg      NamingConfig
+		data     string
+		expected [][]mimirpb.LabelAdapter
+	}{
+		{
+			name: "default",
+			data: `cpu,host=a usage_idle=1,value=2 1465839830100400200`,
+			expected: [][]mimirpb.LabelAdapter{
+				{{Name: "__name__", Value: "cpu"}, {Name: "__proxy_source__", Value: "influx"}, {Name: "host", Value: "a"}},
+				{{Name: "__name__", Value: "cpu_usage_idle"}, {Name: "__proxy_source__", Value: "influx"}, {Name: "host", Value: "a"}},
+			},
+		},
+		{
+			name: "template",
+			cfg:  NamingConfig{Separator: ":", Prefix: "influx_", Suffix: "_total"},
+			data: `cpu,host=a usage_idle=1,value=2 1465839830100400200`,
+			expected: [][]mimirpb.LabelAdapter{
+				{{Name: "__name__", Value: "influx_cpu_total"}, {Name: "__proxy_source__", Value: "influx"}, {Name: "host", Value: "a"}},
+				{{Name: "__name__", Value: "influx_cpu_usage_idle_total"}, {Name: "__proxy_source__", Value: "influx"}, {Name: "host", Value: "a"}},
+			},
+		},
+		{
+			name: "field label",
+			cfg:  NamingConfig{Scheme: NamingFieldLabel, Prefix: "influx_"},
+			data: `cpu,host=a,field=b usage_idle=1,value=2 1465839830100400200`,
+			expected: [][]mimirpb.LabelAdapter{
+				{{Name: "__name__", Value: "influx_cpu"}, {Name: "__proxy_source__", Value: "influx"}, {Name: "field", Value: "usage_idle"}, {Name: "host", Value: "a"}},
+				{{Name: "__name__", Value: "influx_cpu"}, {Name: "__proxy_source__", Value: "influx"}, {Name: "field", Value: "value"}, {Name: "host", Value: "a"}},
+			},
+		},
+		{
+			name: "utf-8",
+			cfg:  NamingConfig{Escaping: model.AllowUTF8},
+			data: `disk.io,k8s.pod.name=a read.bytes=1 1465839830100400200`,
+			expected: [][]mimirpb.LabelAdapter{
+				{{Name: "__name__", Value: "disk.io_read.bytes"}, {Name: "__name_escaping__", Value: "allow-utf-8"}, {Name: "__proxy_source__", Value: "influx"}, {Name: "k8s.pod.name", Value: "a"}},
+			},
+		},
+		{
+			name: "dots escaping",
+			cfg:  NamingConfig{Escaping: model.EscapeDots},
+			data: `disk.io,k8s.pod=a read_bytes=1 1465839830100400200`,
+			expected: [][]mimirpb.LabelAdapter{
+				{{Name: "__name__", Value: "disk_dot_io__read__bytes"}, {Name: "__name_escaping__", Value: "dots"}, {Name: "__proxy_source__", Value: "influx"}, {Name: "k8s_dot_pod", Value: "a"}},
+			},
+		},
+		{
+			name: "values escaping",
+			cfg:  NamingConfig{Scheme: NamingFieldLabel, Escaping: model.EscapeValues},
+			data: `disk.io,host=a read.bytes=1 1465839830100400200`,
+			expected: [][]mimirpb.LabelAdapter{
+				{{Name: "__name__", Value: "U__disk_2e_io"}, {Name: "__name_escaping__", Value: "values"}, {Name: "__proxy_source__", Value: "influx"}, {Name: "field", Value: "read.bytes"}, {Name: "host", Value: "a"}},
+			},
+		},
+		{
+			name: "underscores escaping",
+			cfg:  NamingConfig{Escaping: model.EscapeUnderscores},
+			data: `disk.io,k8s.pod=a read.bytes=1 1465839830100400200`,
+			expected: [][]mimirpb.LabelAdapter{
+				{{Name: "__name__", Value: "disk_io_read_bytes"}, {Name: "__proxy_source__", Value: "influx"}, {Name: "k8s_pod", Value: "a"}},
+			},
+		},
+		{
+			name: "telegraf v1",
+			cfg:  NamingConfig{Scheme: NamingTelegrafV1},
+			data: `disk.io,host-name=a,1st=b read:bytes=1,value=2 1465839830100400200`,
+			expected: [][]mimirpb.LabelAdapter{
+				{{Name: "__name__", Value: "disk_io"}, {Name: "__proxy_source__", Value: "influx"}, {Name: "host_name", Value: "a"}},
+				{{Name: "__name__", Value: "disk_io_read:bytes"}, {Name: "__proxy_source__", Value: "influx"}, {Name: "host_name", Value: "a"}},
+			},
+		},
+		{
+			name: "telegraf v2",
+			cfg:  NamingConfig{Scheme: NamingTelegrafV2},
+			data: `1disk.io,host-name=a,1st=b read.bytes=1,value=2 1465839830100400200`,
+			expected: [][]mimirpb.LabelAdapter{
+				{{Name: "__name__", Value: "disk_io_read_bytes"}, {Name: "__proxy_source__", Value: "influx"}, {Name: "host_name", Value: "a"}, {Name: "st", Value: "b"}},
+				{{Name: "__name__", Value: "disk_io_value"}, {Name: "__proxy_source__", Value: "influx"}, {Name: "host_name", Value: "a"}, {Name: "st", Value: "b"}},
+			},
+		},
+		{
+			name: "telegraf v2 prometheus measurement",
+			cfg:  NamingConfig{Scheme: NamingTelegrafV2},
+			data: `prometheus,job=a go_goroutines=12 1465839830100400200`,
+			expected: [][]mimirpb.LabelAdapter{
+				{{Name: "__name__", Value: "go_goroutines"}, {Name: "__proxy_source__", Value: "influx"}, {Name: "job", Value: "a"}},
+			},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			require.NoError(t, tt.cfg.Validate())
+			conv := newConverter(ConversionConfig{Naming: tt.cfg}, log.NewNopLogger(), &MockRecorder{})
+			points, err := parsePointsWithPrecision([]byte(tt.data), time.Now(), "ns")
+			require.NoError(t, err)
+			ts, err := conv.writeRequestFromInfluxPoints("fake", points, nil)
+			require.NoError(t, err)
+
+			lbls := make([][]mimirpb.LabelAdapter, 0, len(ts))
+			for _, s := range ts {
+				lbls = append(lbls, s.Labels)
+			}
+			sort.Slice(lbls, func(i, j int) bool {
+				return labels.Compare(mimirpb.FromLabelAdaptersToLabels(lbls[i]), mimirpb.FromLabelAdaptersToLabels(lbls[j])) < 0
+			})
+			assert.Equal(t, tt.expected, lbls)
+		})
+	}
+}
+
+func TestTelegrafV2Sanitize(t *testing.T) {
+	tests := []struct {
+		name, expected string
+		metric, ok     bool
+	}{
+		{name: "valid_name:total", metric: true, expected: "valid_name:total", ok: true},
+		{name: "invalid:label", expected: "invalid_label", ok: true},
+		{name: "-leading.dash-", expected: "leading_dash", ok: true},
+		{name: "9", expected: "", ok: false},
+		{name: "", expected: "", ok: false},
+	}
+	for _, tt := range tests {
+		name, ok := telegrafV2Sanitize(tt.name, tt.metric)
+		assert.Equal(t, tt.expected, name, tt.name)
+		assert.Equal(t, tt.ok, ok, tt.name)
+	}
+}
+
+func TestNamingConfigValidate(t *testing.T) {
+	assert.NoError(t, NamingConfig{}.Validate())
+	assert.NoError(t, NamingConfig{Scheme: NamingFieldLabel}.Validate())
+	assert.Error(t, NamingConfig{Scheme: "camel"}.Validate())
+	assert.Error(t, NamingConfig{Scheme: NamingFieldLabel, FieldLabel: "__field"}.Validate())
+	assert.Error(t, NamingConfig{Scheme: NamingFieldLabel, FieldLabel: "field-name"}.Validate())
+	assert.NoError(t, NamingConfig{Escaping: model.EscapeDots}.Validate())
+	assert.NoError(t, NamingConfig{Scheme: NamingTelegrafV2, Escaping: model.EscapeUnderscores}.Validate())
+	assert.Error(t, NamingConfig{Escaping: "hex"}.Validate())
+	assert.Error(t, NamingConfig{Scheme: NamingTelegrafV1, Escaping: model.AllowUTF8}.Validate())
+}
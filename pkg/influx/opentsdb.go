@@ -0,0 +1,382 @@
+package influx
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/go-kit/log"
+	"github.com/go-kit/log/level"
+	"github.com/grafana/dskit/user"
+	"github.com/grafana/mimir-graphite/v2/pkg/errorx"
+	"github.com/grafana/mimir/pkg/mimirpb"
+	"github.com/opentracing/opentracing-go"
+	"github.com/opentracing/opentracing-go/ext"
+	"github.com/prometheus/prometheus/model/labels"
+)
+
+const (
+	openTSDBListenerName = "opentsdb"
+	// openTSDBSource is the value of the source label of OpenTSDB series.
+	openTSDBSource = "opentsdb"
+
+	openTSDBTransportHTTP   = "http"
+	openTSDBTransportTelnet = "telnet"
+)
+
+// OpenTSDBConfig configures the optional OpenTSDB telnet listener, which
+// accepts "put" commands like the OpenTSDB service of InfluxDB 1.x.
+type OpenTSDBConfig struct {
+	// ListenAddress is the host:port the listener binds to. Empty disables the
+	// listener.
+	ListenAddress string
+	// Tenant is the tenant (X-Scope-OrgID) of the data points received.
+	Tenant string
+	// BatchSize is the number of series written per remote write request.
+	BatchSize int
+	// BatchTimeout is the longest time series wait before being written.
+	BatchTimeout time.Duration
+	// ReadTimeout closes connections on which nothing was received for that
+	// long. 0 means no timeout.
+	ReadTimeout time.Duration
+	// MaxLineLength drops longer lines. 0 means no limit.
+	MaxLineLength int
+	// DrainTimeout is how long open connections are given to finish when the
+	// listener stops, before they are closed.
+	DrainTimeout time.Duration
+}
+
+func (c *OpenTSDBConfig) RegisterFlags(flags *flag.FlagSet) {
+	flags.StringVar(&c.ListenAddress, "opentsdb.listen-address", "", "host:port of the OpenTSDB telnet listener; empty to disable it")
+	flags.StringVar(&c.Tenant, "opentsdb.tenant", "fake", "tenant of the data points received by the OpenTSDB telnet listener")
+	flags.IntVar(&c.BatchSize, "opentsdb.batch-size", 5000, "number of series written per request by the OpenTSDB telnet listener")
+	flags.DurationVar(&c.BatchTimeout, "opentsdb.batch-timeout", time.Second, "how long the OpenTSDB telnet listener waits before writing an incomplete batch")
+	flags.DurationVar(&c.ReadTimeout, "opentsdb.read-timeout", 5*time.Minute, "close OpenTSDB telnet connections idle for that long; 0 for no timeout")
+	flags.IntVar(&c.MaxLineLength, "opentsdb.max-line-length", 64<<10, "drop longer lines received by the OpenTSDB telnet listener; 0 for no limit")
+	flags.DurationVar(&c.DrainTimeout, "opentsdb.drain-timeout", 5*time.Second, "how long open OpenTSDB telnet connections are given to finish on shutdown")
+}
+
+// Validate checks the configuration is usable.
+func (c OpenTSDBConfig) Validate() error {
+	if c.ListenAddress == "" {
+		return nil
+	}
+	if c.Tenant == "" {
+		return errors.New("tenant is required")
+	}
+	if c.BatchSize <= 0 || c.BatchTimeout <= 0 {
+		return errors.New("batch size and timeout must be positive")
+	}
+	return nil
+}
+
+// streamConfig returns the configuration of the stream listener reading the
+// telnet connections.
+func (c OpenTSDBConfig) streamConfig() StreamConfig {
+	return StreamConfig{
+		Tenant:        c.Tenant,
+		BatchSize:     c.BatchSize,
+		BatchTimeout:  c.BatchTimeout,
+		ReadTimeout:   c.ReadTimeout,
+		MaxLineLength: c.MaxLineLength,
+		DrainTimeout:  c.DrainTimeout,
+	}
+}
+
+// openTSDBTelnetParser parses "put <metric> <timestamp> <value> <tagk=tagv>..."
+// commands, measuring the data points received.
+func openTSDBTelnetParser(recorder Recorder) lineParser {
+	return func(conv *converter, limits *requestLimits, line []byte) ([]mimirpb.TimeSeries, error) {
+		ts, err := limits.add(1, func() ([]mimirpb.TimeSeries, error) {
+			ts, err := conv.parseOpenTSDBPut(string(line))
+			if err != nil {
+				return nil, err
+			}
+			return []mimirpb.TimeSeries{ts}, nil
+		})
+		if err != nil {
+			recorder.measureOpenTSDBPoints(openTSDBTransportTelnet, "invalid", 1)
+			return nil, err
+		}
+		recorder.measureOpenTSDBPoints(openTSDBTransportTelnet, "accepted", len(ts))
+		return ts, nil
+	}
+}
+
+func (c *converter) parseOpenTSDBPut(line string) (mimirpb.TimeSeries, error) {
+	fields := strings.Fields(line)
+	if len(fields) == 0 {
+		return mimirpb.TimeSeries{}, errors.New("empty command")
+	}
+	if fields[0] != "put" {
+		return mimirpb.TimeSeries{}, fmt.Errorf("unknown command %q", fields[0])
+	}
+	if len(fields) < 4 {
+		return mimirpb.TimeSeries{}, errors.New("not enough arguments (need at least 3)")
+	}
+
+	timestamp, err := strconv.ParseInt(fields[2], 10, 64)
+	if err != nil {
+		return mimirpb.TimeSeries{}, fmt.Errorf("invalid timestamp %q", fields[2])
+	}
+	value, err := strconv.ParseFloat(fields[3], 64)
+	if err != nil {
+		return mimirpb.TimeSeries{}, fmt.Errorf("invalid value %q", fields[3])
+	}
+	tags := make(map[string]string, len(fields)-4)
+	for _, tag := range fields[4:] {
+		k, v, ok := strings.Cut(tag, "=")
+		if !ok {
+			return mimirpb.TimeSeries{}, fmt.Errorf("invalid tag %q", tag)
+		}
+		tags[k] = v
+	}
+	return c.openTSDBPointToTimeseries(fields[1], timestamp, value, tags)
+}
+
+// openTSDBDataPoint is a data point of the OpenTSDB /api/put endpoint.
+type openTSDBDataPoint struct {
+	Metric    string            `json:"metric"`
+	Timestamp json.Number       `json:"timestamp"`
+	Value     json.RawMessage   `json:"value"`
+	Tags      map[string]string `json:"tags"`
+}
+
+func (p openTSDBDataPoint) toTimeseries(conv *converter) (mimirpb.TimeSeries, error) {
+	timestamp, err := p.Timestamp.Int64()
+	if err != nil {
+		return mimirpb.TimeSeries{}, fmt.Errorf("invalid timestamp %q", p.Timestamp)
+	}
+
+	// Values can be numbers or strings holding numbers.
+	raw := string(p.Value)
+	if s, err := strconv.Unquote(raw); err == nil {
+		raw = s
+	}
+	value, err := strconv.ParseFloat(raw, 64)
+	if err != nil {
+		return mimirpb.TimeSeries{}, fmt.Errorf("invalid value %q", raw)
+	}
+	return conv.openTSDBPointToTimeseries(p.Metric, timestamp, value, p.Tags)
+}
+
+// openTSDBPointError is the OpenTSDB description of a data point that could
+// not be written.
+type openTSDBPointError struct {
+	Datapoint openTSDBDataPoint `json:"datapoint"`
+	Error     string            `json:"error"`
+}
+
+// handleOpenTSDBPut is a http.Handler for the OpenTSDB /api/put endpoint. Like
+// OpenTSDB, it writes the valid data points of a request even if others are
+// invalid. The summary and details query parameters add a summary of the
+// write to the response, details including the invalid data points.
+func (a *API) handleOpenTSDBPut(w http.ResponseWriter, r *http.Request) {
+	span, ctx := opentracing.StartSpanFromContext(r.Context(), "handleOpenTSDBPut")
+	defer span.Finish()
+
+	logger := withRequestInfo(a.logger, r)
+	beforeConversion := time.Now()
+
+	reader, err := batchReadCloser(r, a.bodyLimits())
+	if err != nil {
+		a.handleOpenTSDBError(w, r, err, logger)
+		return
+	}
+	points, err := decodeOpenTSDBDataPoints(reader)
+	if err != nil {
+		ext.LogError(span, err)
+		a.handleOpenTSDBError(w, r, errorx.BadRequest{Msg: "unable to parse the given JSON", Err: err}, logger)
+		return
+	}
+	if err := reader.Close(); err != nil {
+		a.handleOpenTSDBError(w, r, errorx.BadRequest{Msg: "problem reading body", Err: err}, logger)
+		return
+	}
+
+	tenant, _ := user.ExtractOrgID(ctx)
+	conv := a.converters.get(openTSDBListenerName, tenant)
+	limits := conv.newRequestLimits(tenant)
+	ts := make([]mimirpb.TimeSeries, 0, len(points))
+	var failed []openTSDBPointError
+	for _, p := range points {
+		series, err := limits.add(1, func() ([]mimirpb.TimeSeries, error) {
+			series, err := p.toTimeseries(conv)
+			if err != nil {
+				return nil, err
+			}
+			return []mimirpb.TimeSeries{series}, nil
+		})
+		if errors.As(err, &limitError{}) && !errors.As(err, &invalidPointError{}) {
+			ext.LogError(span, err)
+			a.handleOpenTSDBError(w, r, err, logger)
+			return
+		}
+		if err != nil {
+			failed = append(failed, openTSDBPointError{Datapoint: p, Error: err.Error()})
+			continue
+		}
+		ts = append(ts, series...)
+	}
+	logger = log.With(logger, "nosMetrics", len(ts), "failed", len(failed))
+	span.LogKV("nosMetrics", len(ts), "failed", len(failed))
+	a.recorder.measureMetricsParsed(len(ts))
+	a.recorder.measureConversionDuration(time.Since(beforeConversion))
+	a.recorder.measureOpenTSDBPoints(openTSDBTransportHTTP, "accepted", len(ts))
+	a.recorder.measureOpenTSDBPoints(openTSDBTransportHTTP, "invalid", len(failed))
+
+	if len(ts) > 0 {
+		if err := a.write(ctx, ts, conv); err != nil {
+			ext.LogError(span, err)
+			a.handleOpenTSDBError(w, r, err, logger)
+			return
+		}
+		span.LogKV("nosMetricsWritten", len(ts))
+	}
+
+	qp := r.URL.Query()
+	details, summary := qp.Has("details"), qp.Has("summary")
+	statusCode := http.StatusNoContent
+	switch {
+	case details || summary:
+		statusCode = http.StatusOK
+		if len(failed) > 0 {
+			statusCode = http.StatusBadRequest
+		}
+		body := struct {
+			Errors  []openTSDBPointError `json:"errors,omitempty"`
+			Failed  int                  `json:"failed"`
+			Success int                  `json:"success"`
+		}{
+			Failed:  len(failed),
+			Success: len(ts),
+		}
+		if details {
+			body.Errors = failed
+			if body.Errors == nil {
+				body.Errors = []openTSDBPointError{}
+			}
+		}
+		_ = level.Info(logger).Log("response_code", statusCode)
+		a.writeJSON(w, r, statusCode, body)
+	case len(failed) > 0:
+		err := errorx.BadRequest{Msg: "One or more data points had errors", Err: errors.New(failed[0].Error)}
+		a.handleOpenTSDBError(w, r, err, logger)
+	default:
+		_ = level.Info(logger).Log("response_code", statusCode)
+		w.WriteHeader(statusCode)
+	}
+}
+
+// decodeOpenTSDBDataPoints decodes a single data point or an array of them.
+func decodeOpenTSDBDataPoints(r io.Reader) ([]openTSDBDataPoint, error) {
+	var raw json.RawMessage
+	dec := json.NewDecoder(r)
+	dec.UseNumber()
+	if err := dec.Decode(&raw); err != nil {
+		return nil, err
+	}
+
+	var points []openTSDBDataPoint
+	if bytes.HasPrefix(bytes.TrimSpace(raw), []byte("[")) {
+		if err := json.Unmarshal(raw, &points); err != nil {
+			return nil, err
+		}
+		return points, nil
+	}
+	var point openTSDBDataPoint
+	if err := json.Unmarshal(raw, &point); err != nil {
+		return nil, err
+	}
+	return append(points, point), nil
+}
+
+// handleOpenTSDBError is handleError for the OpenTSDB endpoint, which has its
+// own error body shape.
+func (a *API) handleOpenTSDBError(w http.ResponseWriter, r *http.Request, err error, logger log.Logger) {
+	statusCode, _, httpErrString := a.classifyError(r, err, logger)
+	type openTSDBError struct {
+		Code    int    `json:"code"`
+		Message string `json:"message"`
+	}
+	a.writeErrorResponse(w, statusCode, struct {
+		Error openTSDBError `json:"error"`
+	}{
+		Error: openTSDBError{Code: statusCode, Message: httpErrString},
+	}, logger)
+}
+
+// openTSDBPointToTimeseries converts a data point to a series. Timestamps are
+// in seconds, or in milliseconds when they don't fit in 32 bits, like OpenTSDB
+// does. Tags are added in the order of their keys, so that the collisions of
+// their sanitized names are resolved the same way every time, and the external
+// labels and the limits on labels apply like for Influx points.
+func (c *converter) openTSDBPointToTimeseries(metric string, timestamp int64, value float64, tags map[string]string) (mimirpb.TimeSeries, error) {
+	if metric == "" {
+		return mimirpb.TimeSeries{}, errors.New("metric name is required")
+	}
+	if timestamp <= 0 {
+		return mimirpb.TimeSeries{}, fmt.Errorf("invalid timestamp %d", timestamp)
+	}
+	if timestamp&^0xFFFFFFFF == 0 {
+		timestamp *= 1000
+	}
+
+	keys := make([]string, 0, len(tags))
+	for key, value := range tags {
+		if key == "" || value == "" {
+			return mimirpb.TimeSeries{}, fmt.Errorf("invalid tag %q=%q", key, value)
+		}
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	tagLabels := make([]mimirpb.LabelAdapter, 0, len(tags))
+	tagKeys := make([]string, 0, len(tags))
+	for _, key := range keys {
+		if c.isReservedLabel(key) {
+			continue
+		}
+		name := key
+		replaceInvalidChars(&name)
+		var err error
+		if tagLabels, tagKeys, err = c.addLabel(tagLabels, tagKeys, "tag", key, name, tags[key]); err != nil {
+			return mimirpb.TimeSeries{}, err
+		}
+	}
+
+	name := metric
+	replaceInvalidChars(&name)
+	lbls := make([]mimirpb.LabelAdapter, 0, len(tagLabels)+len(c.externalLabels)+2) // Additional ones for __name__ and the source label
+	lbls = append(lbls, mimirpb.LabelAdapter{
+		Name:  labels.MetricName,
+		Value: name,
+	})
+	if source := c.protocolSourceLabel(openTSDBSource); source.Name != "" {
+		lbls = append(lbls, source) // A label for tracking active series
+	}
+	lbls = append(lbls, tagLabels...)
+	lbls = c.withExternalLabels(lbls)
+	sort.Slice(lbls, func(i, j int) bool {
+		return lbls[i].Name < lbls[j].Name
+	})
+	lbls, err := c.limitLabels(lbls)
+	if err != nil {
+		return mimirpb.TimeSeries{}, err
+	}
+
+	return mimirpb.TimeSeries{
+		Labels: lbls,
+		Samples: []mimirpb.Sample{{
+			TimestampMs: timestamp,
+			Value:       value,
+		}},
+	}, nil
+}
@@ -0,0 +1,353 @@
+package influx
+
+import (
+	"bytes"
+	"context"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/go-kit/log"
+	"github.com/grafana/dskit/services"
+	"github.com/grafana/dskit/user"
+	"github.com/grafana/mimir-graphite/v2/pkg/remotewrite/remotewritemock"
+	"github.com/grafana/mimir/pkg/mimirpb"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/mock"
+	"github.com/stretchr/testify/require"
+)
+
+func TestParseOpenTSDBPut(t *testing.T) {
+	tests := []struct {
+		name        string
+		line        string
+		collisions  string
+		expected    mimirpb.TimeSeries
+		expectedErr string
+	}{
+		{
+			name: "seconds",
+			line: "put sys.cpu.user 1465839830 42.5 host=web01 cpu.id=0",
+			expected: mimirpb.TimeSeries{
+				Labels: []mimirpb.LabelAdapter{
+					{Name: "__name__", Value: "sys_cpu_user"},
+					{Name: "__proxy_source__", Value: "opentsdb"},
+					{Name: "cpu_id", Value: "0"},
+					{Name: "host", Value: "web01"},
+				},
+				Samples: []mimirpb.Sample{{Value: 42.5, TimestampMs: 1465839830000}},
+			},
+		},
+		{
+			name: "milliseconds",
+			line: "put  sys.cpu.user  1465839830123  1",
+			expected: mimirpb.TimeSeries{
+				Labels: []mimirpb.LabelAdapter{
+					{Name: "__name__", Value: "sys_cpu_user"},
+					{Name: "__proxy_source__", Value: "opentsdb"},
+				},
+				Samples: []mimirpb.Sample{{Value: 1, TimestampMs: 1465839830123}},
+			},
+		},
+		{
+			name: "reserved tags",
+			line: "put m 1465839830 1 __name__=x __proxy_source__=y",
+			expected: mimirpb.TimeSeries{
+				Labels: []mimirpb.LabelAdapter{
+					{Name: "__name__", Value: "m"},
+					{Name: "__proxy_source__", Value: "opentsdb"},
+				},
+				Samples: []mimirpb.Sample{{Value: 1, TimestampMs: 1465839830000}},
+			},
+		},
+		{
+			name: "colliding tags first wins",
+			line: "put m 1465839830 1 a.b=1 a-b=2",
+			expected: mimirpb.TimeSeries{
+				Labels: []mimirpb.LabelAdapter{
+					{Name: "__name__", Value: "m"},
+					{Name: "__proxy_source__", Value: "opentsdb"},
+					{Name: "a_b", Value: "2"},
+				},
+				Samples: []mimirpb.Sample{{Value: 1, TimestampMs: 1465839830000}},
+			},
+		},
+		{
+			name:       "colliding tags suffixed",
+			line:       "put m 1465839830 1 a.b=1 a-b=2",
+			collisions: LabelCollisionsSuffix,
+			expected: mimirpb.TimeSeries{
+				Labels: []mimirpb.LabelAdapter{
+					{Name: "__name__", Value: "m"},
+					{Name: "__proxy_source__", Value: "opentsdb"},
+					{Name: "a_b", Value: "2"},
+					{Name: "a_b_1", Value: "1"},
+				},
+				Samples: []mimirpb.Sample{{Value: 1, TimestampMs: 1465839830000}},
+			},
+		},
+		{
+			name:        "colliding tags rejected",
+			line:        "put m 1465839830 1 a.b=1 a-b=2",
+			collisions:  LabelCollisionsReject,
+			expectedErr: `tag "a.b": label name collision with tag "a-b" as "a_b"`,
+		},
+		{
+			name:        "unknown command",
+			line:        "version",
+			expectedErr: `unknown command "version"`,
+		},
+		{
+			name:        "not enough arguments",
+			line:        "put m 1465839830",
+			expectedErr: "not enough arguments (need at least 3)",
+		},
+		{
+			name:        "invalid timestamp",
+			line:        "put m now 1",
+			expectedErr: `invalid timestamp "now"`,
+		},
+		{
+			name:        "invalid value",
+			line:        "put m 1465839830 one",
+			expectedErr: `invalid value "one"`,
+		},
+		{
+			name:        "invalid tag",
+			line:        "put m 1465839830 1 host",
+			expectedErr: `invalid tag "host"`,
+		},
+		{
+			name:        "empty tag value",
+			line:        "put m 1465839830 1 host=",
+			expectedErr: `invalid tag "host"=""`,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			recorderMock := &MockRecorder{}
+			recorderMock.On("measureLabelCollisions", mock.Anything).Return(nil)
+			conv := newConverter(ConversionConfig{LabelCollisions: tt.collisions}, log.NewNopLogger(), recorderMock)
+			ts, err := conv.parseOpenTSDBPut(tt.line)
+			if tt.expectedErr != "" {
+				require.EqualError(t, err, tt.expectedErr)
+				return
+			}
+			require.NoError(t, err)
+			assert.Equal(t, tt.expected, ts)
+		})
+	}
+}
+
+func TestHandleOpenTSDBPut(t *testing.T) {
+	series := func(host string, value float64) mimirpb.PreallocTimeseries {
+		return mimirpb.PreallocTimeseries{TimeSeries: &mimirpb.TimeSeries{
+			Labels: []mimirpb.LabelAdapter{
+				{Name: "__name__", Value: "sys_cpu_nice"},
+				{Name: "__proxy_source__", Value: "opentsdb"},
+				{Name: "host", Value: host},
+			},
+			Samples: []mimirpb.Sample{{Value: value, TimestampMs: 1465839830000}},
+		}}
+	}
+
+	tests := []struct {
+		name           string
+		url            string
+		data           string
+		expectedCode   int
+		expectJsonBody string
+		expectedWrite  *mimirpb.WriteRequest
+	}{
+		{
+			name:          "single data point",
+			url:           "/api/put",
+			data:          `{"metric": "sys.cpu.nice", "timestamp": 1465839830, "value": 18, "tags": {"host": "web01"}}`,
+			expectedCode:  http.StatusNoContent,
+			expectedWrite: &mimirpb.WriteRequest{Timeseries: []mimirpb.PreallocTimeseries{series("web01", 18)}},
+		},
+		{
+			name: "multiple data points",
+			url:  "/api/put",
+			data: `[
+				{"metric": "sys.cpu.nice", "timestamp": 1465839830, "value": 18, "tags": {"host": "web01"}},
+				{"metric": "sys.cpu.nice", "timestamp": 1465839830000, "value": "9.5", "tags": {"host": "web02"}}
+			]`,
+			expectedCode:  http.StatusNoContent,
+			expectedWrite: &mimirpb.WriteRequest{Timeseries: []mimirpb.PreallocTimeseries{series("web01", 18), series("web02", 9.5)}},
+		},
+		{
+			name: "invalid data point",
+			url:  "/api/put",
+			data: `[
+				{"metric": "sys.cpu.nice", "timestamp": 1465839830, "value": 18, "tags": {"host": "web01"}},
+				{"metric": "", "timestamp": 1465839830, "value": 1}
+			]`,
+			expectedCode:   http.StatusBadRequest,
+			expectedWrite:  &mimirpb.WriteRequest{Timeseries: []mimirpb.PreallocTimeseries{series("web01", 18)}},
+			expectJsonBody: `{"error": {"code": 400, "message": "One or more data points had errors"}}`,
+		},
+		{
+			name: "details",
+			url:  "/api/put?details",
+			data: `[
+				{"metric": "sys.cpu.nice", "timestamp": 1465839830, "value": 18, "tags": {"host": "web01"}},
+				{"metric": "sys.cpu.nice", "timestamp": 1465839830, "value": "NaN!", "tags": {"host": "web02"}}
+			]`,
+			expectedCode:  http.StatusBadRequest,
+			expectedWrite: &mimirpb.WriteRequest{Timeseries: []mimirpb.PreallocTimeseries{series("web01", 18)}},
+			expectJsonBody: `{
+				"errors": [{
+					"datapoint": {"metric": "sys.cpu.nice", "timestamp": 1465839830, "value": "NaN!", "tags": {"host": "web02"}},
+					"error": "invalid value \"NaN!\""
+				}],
+				"failed": 1,
+				"success": 1
+			}`,
+		},
+		{
+			name:           "summary",
+			url:            "/api/put?summary",
+			data:           `{"metric": "sys.cpu.nice", "timestamp": 1465839830, "value": 18, "tags": {"host": "web01"}}`,
+			expectedCode:   http.StatusOK,
+			expectedWrite:  &mimirpb.WriteRequest{Timeseries: []mimirpb.PreallocTimeseries{series("web01", 18)}},
+			expectJsonBody: `{"failed": 0, "success": 1}`,
+		},
+		{
+			name:           "invalid JSON",
+			url:            "/api/put",
+			data:           `{"metric": `,
+			expectedCode:   http.StatusBadRequest,
+			expectJsonBody: `{"error": {"code": 400, "message": "unable to parse the given JSON"}}`,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			req := httptest.NewRequest("POST", tt.url, bytes.NewReader([]byte(tt.data)))
+			req = req.WithContext(user.InjectOrgID(req.Context(), "fake"))
+			rec := httptest.NewRecorder()
+
+			remoteWriteMock := &remotewritemock.Client{}
+			if tt.expectedWrite != nil {
+				remoteWriteMock.On("Write", mock.Anything, mock.MatchedBy(func(req *mimirpb.WriteRequest) bool {
+					return assert.ElementsMatch(t, tt.expectedWrite.Timeseries, req.Timeseries)
+				})).Return(nil)
+			}
+			recorderMock := &MockRecorder{}
+			recorderMock.On("measureMetricsParsed", mock.Anything).Return(nil)
+			recorderMock.On("measureMetricsWritten", mock.Anything).Return(nil)
+			recorderMock.On("measureProxyErrors", "errorx.BadRequest").Return(nil)
+			recorderMock.On("measureConversionDuration", mock.Anything).Return(nil)
+			recorderMock.On("measureOpenTSDBPoints", "http", mock.Anything, mock.Anything).Return(nil)
+
+			conf := ProxyConfig{
+				Logger:              log.NewNopLogger(),
+				MaxRequestSizeBytes: DefaultMaxRequestSizeBytes,
+			}
+			api, err := NewAPI(conf, remoteWriteMock, recorderMock)
+			require.NoError(t, err)
+
+			api.handleOpenTSDBPut(rec, req)
+			assert.Equal(t, tt.expectedCode, rec.Code)
+			if tt.expectJsonBody != "" {
+				assert.JSONEq(t, tt.expectJsonBody, rec.Body.String())
+			} else {
+				assert.Empty(t, rec.Body.String())
+			}
+			remoteWriteMock.AssertExpectations(t)
+		})
+	}
+}
+
+func TestHandleOpenTSDBPutRelabel(t *testing.T) {
+	path := writeOverrides(t, `
+endpoints:
+  opentsdb:
+    relabel_configs:
+      - source_labels: [__name__]
+        regex: sys_cpu_idle
+        action: drop
+`)
+	remoteWriteMock := &remotewritemock.Client{}
+	remoteWriteMock.On("Write", mock.Anything, &mimirpb.WriteRequest{
+		Timeseries: []mimirpb.PreallocTimeseries{{TimeSeries: &mimirpb.TimeSeries{
+			Labels: []mimirpb.LabelAdapter{
+				{Name: "__name__", Value: "sys_cpu_nice"},
+				{Name: "__proxy_source__", Value: "opentsdb"},
+				{Name: "host", Value: "web01"},
+			},
+			Samples: []mimirpb.Sample{{Value: 18, TimestampMs: 1465839830000}},
+		}}},
+	}).Return(nil)
+	recorderMock := &MockRecorder{}
+	recorderMock.On("measureMetricsParsed", 2).Return(nil)
+	recorderMock.On("measureMetricsWritten", 1).Return(nil)
+	recorderMock.On("measureConversionDuration", mock.Anything).Return(nil)
+	recorderMock.On("measureOpenTSDBPoints", "http", mock.Anything, mock.Anything).Return(nil)
+	recorderMock.On("measureRelabelDropped", "fake", "1", "drop").Return(nil).Once()
+
+	api, err := NewAPI(ProxyConfig{Logger: log.NewNopLogger(), OverridesFile: path}, remoteWriteMock, recorderMock)
+	require.NoError(t, err)
+
+	req := httptest.NewRequest("POST", "/api/put", bytes.NewReader([]byte(`[
+		{"metric": "sys.cpu.nice", "timestamp": 1465839830, "value": 18, "tags": {"host": "web01"}},
+		{"metric": "sys.cpu.idle", "timestamp": 1465839830, "value": 70, "tags": {"host": "web01"}}
+	]`)))
+	req = req.WithContext(user.InjectOrgID(req.Context(), "fake"))
+	rec := httptest.NewRecorder()
+	api.handleOpenTSDBPut(rec, req)
+
+	assert.Equal(t, http.StatusNoContent, rec.Code)
+	remoteWriteMock.AssertExpectations(t)
+	recorderMock.AssertExpectations(t)
+}
+
+func TestOpenTSDBTelnetListener(t *testing.T) {
+	remoteWriteMock := &remotewritemock.Client{}
+	written := make(chan []mimirpb.PreallocTimeseries, 1)
+	remoteWriteMock.On("Write", mock.Anything, mock.Anything).Return(nil).Run(func(args mock.Arguments) {
+		orgID, err := user.ExtractOrgID(args.Get(0).(context.Context))
+		require.NoError(t, err)
+		require.Equal(t, "opentsdb-tenant", orgID)
+		written <- args.Get(1).(*mimirpb.WriteRequest).Timeseries
+	})
+	recorderMock := &MockRecorder{}
+	recorderMock.On("measureMessagesReceived", "opentsdb").Return(nil)
+	recorderMock.On("measureMessagesDropped", "opentsdb", "parse_error").Return(nil).Once()
+	recorderMock.On("measureOpenTSDBPoints", "telnet", "accepted", 1).Return(nil).Twice()
+	recorderMock.On("measureOpenTSDBPoints", "telnet", "invalid", 1).Return(nil).Once()
+	recorderMock.On("measureMetricsParsed", 1).Return(nil)
+	recorderMock.On("measureMetricsWritten", 2).Return(nil)
+	recorderMock.On("measureConversionDuration", mock.Anything).Return(nil)
+
+	cfg := OpenTSDBConfig{
+		ListenAddress: "127.0.0.1:0",
+		Tenant:        "opentsdb-tenant",
+		BatchSize:     2,
+		BatchTimeout:  time.Hour,
+		DrainTimeout:  5 * time.Second,
+	}
+	require.NoError(t, cfg.Validate())
+	l := newStreamListener(openTSDBListenerName, "tcp", cfg.ListenAddress, nil, cfg.streamConfig(), openTSDBTelnetParser(recorderMock), newConverter(ConversionConfig{}, log.NewNopLogger(), recorderMock), log.NewNopLogger(), remoteWriteMock, recorderMock)
+	require.NoError(t, services.StartAndAwaitRunning(context.Background(), l))
+
+	conn, err := net.Dial("tcp", l.Addr().String())
+	require.NoError(t, err)
+	_, err = conn.Write([]byte("put sys.cpu.user 1465839830 1 host=a\nput sys.cpu.user 1465839830 x host=b\nput sys.cpu.user 1465839830 3 host=c\n"))
+	require.NoError(t, err)
+	require.NoError(t, conn.Close())
+
+	select {
+	case ts := <-written:
+		require.Len(t, ts, 2)
+		assert.Equal(t, "c", ts[1].Labels[2].Value)
+	case <-time.After(5 * time.Second):
+		t.Fatal("batch not written")
+	}
+	require.NoError(t, services.StopAndAwaitTerminated(context.Background(), l))
+	recorderMock.AssertExpectations(t)
+}
@@ -0,0 +1,149 @@
+package influx
+
+import (
+	"bytes"
+	"fmt"
+	"os"
+	"sort"
+
+	"github.com/go-kit/log"
+	"gopkg.in/yaml.v3"
+)
+
+// Names of the endpoints and listeners whose conversion can be overridden.
+// The listeners are named after their listenerName.
+const (
+	// endpointV1 is the InfluxDB 1.x /write endpoint.
+	endpointV1 = "v1"
+	// endpointV2 is the InfluxDB 2.x /api/v2/write endpoint.
+	endpointV2 = "v2"
+	// endpointV3 is the InfluxDB 3 /api/v3/write_lp endpoint.
+	endpointV3 = "v3"
+	// endpointPush is the /api/v1/push/influx/write endpoint.
+	endpointPush = "push"
+)
+
+// endpoints are all the endpoints and listeners writing series. The OpenTSDB
+// /api/put endpoint shares the overrides of the OpenTSDB telnet listener.
+var endpoints = []string{endpointV1, endpointV2, endpointV3, endpointPush, udpListenerName, tcpListenerName, unixListenerName, openTSDBListenerName, collectdListenerName}
+
+// Overrides are the conversion settings that differ from the ones set by
+// flags, for all the points or those of some endpoints and tenants. Each one
+// is a ConversionConfig in which the settings left out keep their value from
+// the flags. Defaults are applied first, such as the settings that have no
+// flag, then the endpoint overrides and finally the tenant ones.
+type Overrides struct {
+	Defaults  yaml.Node            `yaml:"defaults"`
+	Endpoints map[string]yaml.Node `yaml:"endpoints"`
+	Tenants   map[string]yaml.Node `yaml:"tenants"`
+}
+
+// LoadOverrides reads the overrides in the YAML file at path.
+func LoadOverrides(path string) (Overrides, error) {
+	var o Overrides
+	b, err := os.ReadFile(path)
+	if err != nil {
+		return o, err
+	}
+	dec := yaml.NewDecoder(bytes.NewReader(b))
+	dec.KnownFields(true)
+	if err := dec.Decode(&o); err != nil {
+		return o, fmt.Errorf("invalid overrides file %s: %w", path, err)
+	}
+	return o, nil
+}
+
+// decodeOverride applies the settings of an override to cfg, rejecting
+// unknown settings.
+func decodeOverride(node yaml.Node, cfg *ConversionConfig) error {
+	if err := decodeNode(node, cfg); err != nil {
+		return err
+	}
+	return cfg.Validate()
+}
+
+// decodeNode decodes node into out, rejecting unknown fields, which
+// yaml.Node.Decode doesn't.
+func decodeNode(node yaml.Node, out interface{}) error {
+	b, err := yaml.Marshal(&node)
+	if err != nil {
+		return err
+	}
+	dec := yaml.NewDecoder(bytes.NewReader(b))
+	dec.KnownFields(true)
+	return dec.Decode(out)
+}
+
+// converters are the converters of each endpoint and tenant. They are all
+// created upfront, so that invalid overrides are reported on startup.
+type converters struct {
+	defaults  *converter
+	endpoints map[string]*converter
+	// tenants are the converters of the tenants with overrides, by endpoint.
+	tenants map[string]map[string]*converter
+}
+
+func newConverters(cfg ConversionConfig, overrides Overrides, logger log.Logger, recorder Recorder) (*converters, error) {
+	if !overrides.Defaults.IsZero() {
+		cfg = cfg.clone()
+		if err := decodeOverride(overrides.Defaults, &cfg); err != nil {
+			return nil, fmt.Errorf("invalid default overrides: %w", err)
+		}
+	}
+
+	c := &converters{
+		defaults:  newConverter(cfg, logger, recorder),
+		endpoints: map[string]*converter{},
+		tenants:   map[string]map[string]*converter{},
+	}
+
+	endpointConfigs := make(map[string]ConversionConfig, len(endpoints))
+	for _, endpoint := range endpoints {
+		endpointConfigs[endpoint] = cfg
+	}
+	for _, endpoint := range sortedKeys(overrides.Endpoints) {
+		if _, ok := endpointConfigs[endpoint]; !ok {
+			return nil, fmt.Errorf("unknown endpoint %q in overrides", endpoint)
+		}
+		endpointCfg := cfg.clone()
+		if err := decodeOverride(overrides.Endpoints[endpoint], &endpointCfg); err != nil {
+			return nil, fmt.Errorf("invalid overrides of endpoint %q: %w", endpoint, err)
+		}
+		endpointConfigs[endpoint] = endpointCfg
+		c.endpoints[endpoint] = newConverter(endpointCfg, logger, recorder)
+	}
+
+	for _, tenant := range sortedKeys(overrides.Tenants) {
+		c.tenants[tenant] = make(map[string]*converter, len(endpoints))
+		for _, endpoint := range endpoints {
+			tenantCfg := endpointConfigs[endpoint].clone()
+			if err := decodeOverride(overrides.Tenants[tenant], &tenantCfg); err != nil {
+				return nil, fmt.Errorf("invalid overrides of tenant %q: %w", tenant, err)
+			}
+			c.tenants[tenant][endpoint] = newConverter(tenantCfg, logger, recorder)
+		}
+	}
+	return c, nil
+}
+
+// get returns the converter of the points received by endpoint for tenant.
+func (c *converters) get(endpoint, tenant string) *converter {
+	if byEndpoint, ok := c.tenants[tenant]; ok {
+		if conv, ok := byEndpoint[endpoint]; ok {
+			return conv
+		}
+	}
+	if conv, ok := c.endpoints[endpoint]; ok {
+		return conv
+	}
+	return c.defaults
+}
+
+func sortedKeys(m map[string]yaml.Node) []string {
+	keys := make([]string, 0, len(m))
+	for k := range m {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	return keys
+}
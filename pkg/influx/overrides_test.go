@@ -0,0 +1,110 @@
+package influx
+
+import (
+	"bytes"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/go-kit/log"
+	"github.com/grafana/dskit/user"
+	"github.com/grafana/mimir-graphite/v2/pkg/remotewrite/remotewritemock"
+	"github.com/grafana/mimir/pkg/mimirpb"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/mock"
+	"github.com/stretchr/testify/require"
+)
+
+func writeOverrides(t *testing.T, data string) string {
+	path := filepath.Join(t.TempDir(), "overrides.yaml")
+	require.NoError(t, os.WriteFile(path, []byte(data), 0o600))
+	return path
+}
+
+func TestConverters(t *testing.T) {
+	overrides, err := LoadOverrides(writeOverrides(t, `
+endpoints:
+  v2:
+    naming:
+      scheme: telegraf-v2
+tenants:
+  team-a:
+    naming:
+      prefix: a_
+  team-b:
+    naming:
+      scheme: field-label
+    large_integers: split
+    string_fields:
+      info: true
+      labels: status,version
+      enums:
+        state: {up: 1, down: 0}
+`))
+	require.NoError(t, err)
+
+	cfg := ConversionConfig{Naming: NamingConfig{Prefix: "influx_"}}
+	convs, err := newConverters(cfg, overrides, log.NewNopLogger(), &MockRecorder{})
+	require.NoError(t, err)
+
+	assert.Same(t, convs.defaults, convs.get(endpointV1, "other"))
+	assert.Equal(t, templateNamer{separator: "_", prefix: "influx_"}, convs.get(endpointV1, "other").namer)
+	assert.Equal(t, telegrafNamer{version: 2, prefix: "influx_"}, convs.get(endpointV2, "other").namer)
+	assert.Equal(t, templateNamer{separator: "_", prefix: "a_"}, convs.get(endpointV1, "team-a").namer)
+	assert.Equal(t, telegrafNamer{version: 2, prefix: "a_"}, convs.get(endpointV2, "team-a").namer)
+	assert.Equal(t, templateNamer{prefix: "influx_", fieldLabel: "field"}, convs.get(udpListenerName, "team-b").namer)
+	assert.Equal(t, LargeIntegersSplit, convs.get(endpointV3, "team-b").largeIntegers)
+	assert.Equal(t, "", convs.get(endpointV3, "team-a").largeIntegers)
+	assert.Equal(t, StringFieldsConfig{
+		Info:   true,
+		Labels: []string{"status", "version"},
+		Enums:  EnumMappings{"state": {"up": 1, "down": 0}},
+	}, convs.get(endpointV3, "team-b").stringFields.cfg)
+}
+
+func TestConvertersErrors(t *testing.T) {
+	tests := map[string]string{
+		"unknown endpoint": "endpoints:\n  v4:\n    naming:\n      scheme: default\n",
+		"unknown setting":  "tenants:\n  a:\n    nameing:\n      scheme: default\n",
+		"invalid setting":  "tenants:\n  a:\n    naming:\n      scheme: camel\n",
+	}
+	for name, data := range tests {
+		t.Run(name, func(t *testing.T) {
+			overrides, err := LoadOverrides(writeOverrides(t, data))
+			require.NoError(t, err)
+			_, err = newConverters(ConversionConfig{}, overrides, log.NewNopLogger(), &MockRecorder{})
+			assert.Error(t, err)
+		})
+	}
+
+	_, err := LoadOverrides(writeOverrides(t, "tenant:\n  a: {}\n"))
+	assert.Error(t, err)
+}
+
+func TestHandleSeriesPushTenantOverrides(t *testing.T) {
+	req := httptest.NewRequest("POST", "/api/v1/push/influx/write", bytes.NewReader([]byte("cpu usage_idle=1 1465839830100400200")))
+	req = req.WithContext(user.InjectOrgID(req.Context(), "team-a"))
+	rec := httptest.NewRecorder()
+
+	remoteWriteMock := &remotewritemock.Client{}
+	remoteWriteMock.On("Write", mock.Anything, mock.MatchedBy(func(req *mimirpb.WriteRequest) bool {
+		return len(req.Timeseries) == 1 && req.Timeseries[0].Labels[0].Value == "team_a_cpu_usage_idle"
+	})).Return(nil)
+	recorderMock := &MockRecorder{}
+	recorderMock.On("measureMetricsParsed", 1).Return(nil)
+	recorderMock.On("measureMetricsWritten", 1).Return(nil)
+	recorderMock.On("measureConversionDuration", mock.Anything).Return(nil)
+
+	conf := ProxyConfig{
+		Logger:        log.NewNopLogger(),
+		OverridesFile: writeOverrides(t, "tenants:\n  team-a:\n    naming:\n      prefix: team_a_\n"),
+	}
+	api, err := NewAPI(conf, remoteWriteMock, recorderMock)
+	require.NoError(t, err)
+
+	api.handleSeriesPush(rec, req)
+	assert.Equal(t, http.StatusNoContent, rec.Code)
+	remoteWriteMock.AssertNumberOfCalls(t, "Write", 1)
+}
@@ -1,145 +1,224 @@
 package influx
 
 import (
-	"compress/gzip"
 	"context"
+	"errors"
 	"fmt"
 	"io"
 	"net/http"
-	"sort"
 	"time"
 
+	"github.com/grafana/dskit/user"
 	"github.com/grafana/mimir-graphite/v2/pkg/errorx"
 	"github.com/grafana/mimir/pkg/mimirpb"
-	"github.com/grafana/mimir/pkg/util"
-	io2 "github.com/influxdata/influxdb/v2/kit/io"
 	"github.com/influxdata/influxdb/v2/models"
-	"github.com/prometheus/prometheus/model/labels"
 )
 
 const internalLabel = "__proxy_source__"
 
-// parseInfluxLineReader parses a Influx Line Protocol request from an io.Reader.
-func parseInfluxLineReader(ctx context.Context, r *http.Request, maxSize int) ([]mimirpb.TimeSeries, int, error) {
-	qp := r.URL.Query()
-	precision := qp.Get("precision")
-	if precision == "" {
-		precision = "ns"
-	}
+// writeParams are the options of a single write request, derived from the
+// query parameters of the endpoint that received it.
+type writeParams struct {
+	// endpoint is the name of the endpoint that received the request.
+	endpoint string
+	// precision of the timestamps in the request. Empty means nanoseconds.
+	precision string
+	// labels are added to every series of the request, taking precedence over
+	// tags with the same name.
+	labels []mimirpb.LabelAdapter
+	// converter converts the points of the request to series.
+	converter *converter
+	// allOrNothing makes the request fail at its first invalid line, rather
+	// than skip it, and its series only be written once its whole body was
+	// parsed, so that nothing is written when a line is invalid.
+	allOrNothing bool
+}
 
-	if !models.ValidPrecision(precision) {
-		return nil, 0, errorx.BadRequest{Msg: fmt.Sprintf("precision supplied is not valid: %s", precision)}
+// parseInfluxLineReader parses the Influx Line Protocol body of r with
+// parseInfluxLines, within the size limits of the body and the per-request
+// limits of the tenant of ctx. The auto precision is only accepted from the
+// InfluxDB 3 endpoint.
+func parseInfluxLineReader(ctx context.Context, r *http.Request, params writeParams, limits bodyLimits, maxLineLength, batchSize int, flush func([]mimirpb.TimeSeries) error) (int, lineErrors, error) {
+	if params.precision == "" {
+		params.precision = "ns"
 	}
-
-	encoding := r.Header.Get("Content-Encoding")
-	reader, err := batchReadCloser(r.Body, encoding, int64(maxSize))
-	if err != nil {
-		return nil, 0, errorx.BadRequest{Msg: "gzip compression error", Err: err}
+	if !validPrecision(params.precision) && (params.precision != "auto" || params.endpoint != endpointV3) {
+		return 0, lineErrors{}, errorx.BadRequest{Msg: fmt.Sprintf("precision supplied is not valid: %s", params.precision)}
 	}
-	data, err := io.ReadAll(reader)
-	dataLen := len(data) // In case it something is read despite an error
+
+	reader, err := batchReadCloser(r, limits)
 	if err != nil {
-		return nil, dataLen, errorx.BadRequest{Msg: "can't read body", Err: err}
+		return 0, lineErrors{}, err
 	}
+	tenant, _ := user.ExtractOrgID(ctx)
+	return parseInfluxLines(reader, params, params.converter.newRequestLimits(tenant), maxLineLength, batchSize, flush)
+}
 
-	err = reader.Close()
-	if err != nil {
-		return nil, dataLen, errorx.BadRequest{Msg: "problem reading body", Err: err}
+// parseInfluxLines parses the line protocol read from reader line by line,
+// converting it within limits, and passes the series to flush in batches of
+// about batchSize series, so that the memory used doesn't depend on the size of
+// the input. A batchSize less than or equal to 0 flushes all the series at the
+// end. Invalid lines are skipped and returned as line errors, unless
+// params.allOrNothing is set: the series are then kept until the end of the
+// input, which is bounded by the request size limits, and the parsing stops at
+// the first invalid line without flushing any. It stops at the first failed
+// flush or exceeded limit, closes reader and returns the number of bytes read.
+func parseInfluxLines(reader io.ReadCloser, params writeParams, limits *requestLimits, maxLineLength, batchSize int, flush func([]mimirpb.TimeSeries) error) (int, lineErrors, error) {
+	defer reader.Close()
+
+	now := time.Now().UTC()
+	lr := newLineReader(reader, maxLineLength)
+	var batch []mimirpb.TimeSeries
+	var lineErrs lineErrors
+	for {
+		line, err := lr.next()
+		if errors.Is(err, io.EOF) {
+			break
+		}
+		if errors.Is(err, errLineTooLong) {
+			lineErrs.add(lineError{lineNum: lr.lineNum, err: err})
+			if params.allOrNothing {
+				return lr.bytesRead, lineErrs, nil
+			}
+			continue
+		}
+		if err != nil {
+			return lr.bytesRead, lineErrs, errorx.BadRequest{Msg: "can't read body", Err: err}
+		}
+		if isBlankOrComment(line) {
+			continue
+		}
+
+		points, err := parsePointsWithPrecision(line, now, params.precision)
+		if err != nil {
+			lineErrs.add(lineError{lineNum: lr.lineNum, line: string(line), err: err})
+			if params.allOrNothing {
+				return lr.bytesRead, lineErrs, nil
+			}
+			continue
+		}
+		ts, err := limits.convert(points, params.labels)
+		if errors.As(err, &invalidPointError{}) {
+			lineErrs.add(lineError{lineNum: lr.lineNum, line: string(line), err: err})
+			if params.allOrNothing {
+				return lr.bytesRead, lineErrs, nil
+			}
+			continue
+		}
+		if err != nil {
+			return lr.bytesRead, lineErrs, withLineNum(err, lr.lineNum)
+		}
+		batch = append(batch, ts...)
+		for !params.allOrNothing && batchSize > 0 && len(batch) >= batchSize {
+			// The parts of the histograms at the end of the batch are kept
+			// for the next one, in case the next lines complete them.
+			n := params.converter.histogramCut(batch, batchSize)
+			if n == 0 {
+				break
+			}
+			if err := flush(batch[:n]); err != nil {
+				return lr.bytesRead, lineErrs, err
+			}
+			// Series may still be referenced by the flushed request.
+			batch = append([]mimirpb.TimeSeries(nil), batch[n:]...)
+		}
 	}
 
-	points, err := models.ParsePointsWithPrecision(data, time.Now().UTC(), precision)
-	if err != nil {
-		return nil, dataLen, errorx.BadRequest{Msg: "error parsing points", Err: err}
+	if err := reader.Close(); err != nil {
+		return lr.bytesRead, lineErrs, errorx.BadRequest{Msg: "problem reading body", Err: err}
 	}
-	a, b := writeRequestFromInfluxPoints(points)
-	return a, dataLen, b
+	for len(batch) > 0 {
+		n := len(batch)
+		if batchSize > 0 && n > batchSize {
+			if n = params.converter.histogramCut(batch, batchSize); n == 0 {
+				n = len(batch)
+			}
+		}
+		if err := flush(batch[:n]); err != nil {
+			return lr.bytesRead, lineErrs, err
+		}
+		batch = batch[n:]
+	}
+	return lr.bytesRead, lineErrs, nil
 }
 
-func writeRequestFromInfluxPoints(points []models.Point) ([]mimirpb.TimeSeries, error) {
-	// Technically the same series should not be repeated. We should put all the samples for
-	// a series in single client.Timeseries. Having said that doing it is not very optimal and the
-	// occurrence of multiple timestamps for the same series is rare. Only reason I see it happening is
-	// for backfilling and this is not the API for that. Keeping that in mind, we are going to create a new
-	// client.Timeseries for each sample.
+// validPrecision is models.ValidPrecision extended with the minute and hour
+// precisions of InfluxDB 1.x.
+func validPrecision(precision string) bool {
+	return models.ValidPrecision(precision) || precision == "m" || precision == "h"
+}
+
+// parsePointsWithPrecision is models.ParsePointsWithPrecision with support for
+// the minute and hour precisions of InfluxDB 1.x, which the 2.x models package
+// dropped, and for the "auto" precision of InfluxDB 3.
+func parsePointsWithPrecision(buf []byte, defaultTime time.Time, precision string) ([]models.Point, error) {
+	var scale func(ts int64) (int64, error)
+	switch precision {
+	case "m":
+		scale = scaleTimestamp(time.Minute)
+		defaultTime = defaultTime.Truncate(time.Minute)
+	case "h":
+		scale = scaleTimestamp(time.Hour)
+		defaultTime = defaultTime.Truncate(time.Hour)
+	case "auto":
+		scale = guessTimestampPrecision
+	default:
+		return models.ParsePointsWithPrecision(buf, defaultTime, precision)
+	}
 
-	returnTs := []mimirpb.TimeSeries{}
+	// Parse the raw timestamps as nanoseconds and scale them afterwards. A zero
+	// default time tells us which points came without a timestamp, as no
+	// timestamp in the valid range maps to it.
+	points, err := models.ParsePointsWithPrecision(buf, time.Time{}, "ns")
 	for _, pt := range points {
-		ts, err := influxPointToTimeseries(pt)
+		if pt.Time().IsZero() {
+			pt.SetTime(defaultTime)
+			continue
+		}
+		ts, err := scale(pt.UnixNano())
 		if err != nil {
 			return nil, err
 		}
-		returnTs = append(returnTs, ts...)
+		pt.SetTime(time.Unix(0, ts).UTC())
 	}
-
-	return returnTs, nil
+	return points, err
 }
 
-// Points to Prometheus is heavily inspired from https://github.com/prometheus/influxdb_exporter/blob/a1dc16ad596a990d8854545ea39a57a99a3c7c43/main.go#L148-L211
-func influxPointToTimeseries(pt models.Point) ([]mimirpb.TimeSeries, error) {
-	returnTs := []mimirpb.TimeSeries{}
+func scaleTimestamp(unit time.Duration) func(ts int64) (int64, error) {
+	return func(ts int64) (int64, error) {
+		if ts > models.MaxNanoTime/int64(unit) || ts < models.MinNanoTime/int64(unit) {
+			return 0, models.ErrTimeOutOfRange
+		}
+		return ts * int64(unit), nil
+	}
+}
 
-	fields, err := pt.Fields()
-	if err != nil {
-		return nil, errorx.Internal{Msg: "error getting fields from point", Err: err}
+// guessTimestampPrecision converts a timestamp of unknown precision to
+// nanoseconds, guessing the precision from its magnitude the way InfluxDB 3
+// does: timestamps are assumed to be recent.
+func guessTimestampPrecision(ts int64) (int64, error) {
+	val := ts / int64(time.Second)
+	if val < 0 {
+		val = -val
 	}
-	for field, v := range fields {
-		var value float64
-		switch v := v.(type) {
-		case float64:
-			value = v
-		case int64:
-			value = float64(v)
-		case bool:
-			if v {
-				value = 1
-			} else {
-				value = 0
-			}
-		default:
-			continue
-		}
+	switch {
+	case val < 5:
+		return ts * int64(time.Second), nil
+	case val < 5_000:
+		return ts * int64(time.Millisecond), nil
+	case val < 5_000_000:
+		return ts * int64(time.Microsecond), nil
+	}
+	return ts, nil
+}
 
-		name := string(pt.Name()) + "_" + field
-		if field == "value" {
-			name = string(pt.Name())
+func hasLabel(lbls []mimirpb.LabelAdapter, name string) bool {
+	for _, l := range lbls {
+		if l.Name == name {
+			return true
 		}
-		replaceInvalidChars(&name)
-
-		tags := pt.Tags()
-		lbls := make([]mimirpb.LabelAdapter, 0, len(tags)+2) // An additional one for __name__, and one for internal label
-		lbls = append(lbls, mimirpb.LabelAdapter{
-			Name:  labels.MetricName,
-			Value: name,
-		})
-		lbls = append(lbls, mimirpb.LabelAdapter{
-			Name:  internalLabel, // An internal label for tracking active series
-			Value: "influx",
-		})
-		for _, tag := range tags {
-			key := string(tag.Key)
-			if key == "__name__" || key == internalLabel {
-				continue
-			}
-			replaceInvalidChars(&key)
-			lbls = append(lbls, mimirpb.LabelAdapter{
-				Name:  key,
-				Value: string(tag.Value),
-			})
-		}
-		sort.Slice(lbls, func(i, j int) bool {
-			return lbls[i].Name < lbls[j].Name
-		})
-
-		returnTs = append(returnTs, mimirpb.TimeSeries{
-			Labels: lbls,
-			Samples: []mimirpb.Sample{{
-				TimestampMs: util.TimeToMillis(pt.Time()),
-				Value:       value,
-			}},
-		})
 	}
-
-	return returnTs, nil
+	return false
 }
 
 // analog of invalidChars = regexp.MustCompile("[^a-zA-Z0-9_]")
@@ -160,20 +239,3 @@ func replaceInvalidChars(in *string) {
 		*in = "_" + *in
 	}
 }
-
-// batchReadCloser (potentially) wraps an io.ReadCloser in Gzip
-// decompression and limits the reading to a specific number of bytes.
-func batchReadCloser(rc io.ReadCloser, encoding string, maxBatchSizeBytes int64) (io.ReadCloser, error) {
-	switch encoding {
-	case "gzip", "x-gzip":
-		var err error
-		rc, err = gzip.NewReader(rc)
-		if err != nil {
-			return nil, err
-		}
-	}
-	if maxBatchSizeBytes > 0 {
-		rc = io2.NewLimitedReadCloser(rc, maxBatchSizeBytes)
-	}
-	return rc, nil
-}
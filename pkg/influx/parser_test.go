@@ -3,13 +3,17 @@ package influx
 import (
 	"bytes"
 	"context"
+	"io"
 	"net/http/httptest"
 	"sort"
+	"strings"
 	"testing"
 
+	"github.com/go-kit/log"
 	"github.com/grafana/mimir-graphite/v2/pkg/errorx"
 	"github.com/grafana/mimir/pkg/mimirpb"
 	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/mock"
 	"github.com/stretchr/testify/require"
 )
 
@@ -198,8 +202,13 @@ func TestParseInfluxLineReader(t *testing.T) {
 		t.Run(tt.name, func(t *testing.T) {
 			req := httptest.NewRequest("POST", tt.url, bytes.NewReader([]byte(tt.data)))
 
-			timeSeries, _, err := parseInfluxLineReader(context.Background(), req, maxSize)
+			var timeSeries []mimirpb.TimeSeries
+			_, lineErrs, err := parseInfluxLineReader(context.Background(), req, writeParams{precision: req.URL.Query().Get("precision"), converter: testConverter()}, bodyLimits{maxDecompressedBytes: maxSize}, 0, DefaultWriteBatchSize, func(ts []mimirpb.TimeSeries) error {
+				timeSeries = append(timeSeries, ts...)
+				return nil
+			})
 			require.NoError(t, err)
+			require.Zero(t, lineErrs.count())
 
 			if len(timeSeries) > 1 {
 				// sort the returned timeSeries results in guarantee expected order for comparison
@@ -233,28 +242,24 @@ func TestInvalidInput(t *testing.T) {
 			errorType: &errorx.BadRequest{},
 		},
 		{
-			name:      "parse invalid field input",
-			url:       "/write",
-			data:      "measurement,t1=v1 f1= 1465839830100400200", // field value is missing
-			errorType: &errorx.BadRequest{},
+			name: "parse invalid field input",
+			url:  "/write",
+			data: "measurement,t1=v1 f1= 1465839830100400200", // field value is missing
 		},
 		{
-			name:      "parse invalid tags",
-			url:       "/write",
-			data:      "measurement,t1=v1,t2 f1=2 1465839830100400200", // field value is missing
-			errorType: &errorx.BadRequest{},
+			name: "parse invalid tags",
+			url:  "/write",
+			data: "measurement,t1=v1,t2 f1=2 1465839830100400200", // field value is missing
 		},
 		{
-			name:      "parse field value invalid quotes",
-			url:       "/write",
-			data:      "measurement,t1=v1 f1=v1 1465839830100400200", // string type field values require double quotes
-			errorType: &errorx.BadRequest{},
+			name: "parse field value invalid quotes",
+			url:  "/write",
+			data: "measurement,t1=v1 f1=v1 1465839830100400200", // string type field values require double quotes
 		},
 		{
-			name:      "parse missing field",
-			url:       "/write",
-			data:      "measurement,t1=v1 1465839830100400200", // missing field
-			errorType: &errorx.BadRequest{},
+			name: "parse missing field",
+			url:  "/write",
+			data: "measurement,t1=v1 1465839830100400200", // missing field
 		},
 	}
 
@@ -262,17 +267,65 @@ func TestInvalidInput(t *testing.T) {
 		t.Run(tt.name, func(t *testing.T) {
 			req := httptest.NewRequest("POST", tt.url, bytes.NewReader([]byte(tt.data)))
 
-			_, _, err := parseInfluxLineReader(context.Background(), req, maxSize)
-			require.Error(t, err)
-			assert.ErrorAs(t, err, tt.errorType)
+			var flushed int
+			_, lineErrs, err := parseInfluxLineReader(context.Background(), req, writeParams{precision: req.URL.Query().Get("precision"), converter: testConverter()}, bodyLimits{maxDecompressedBytes: maxSize}, 0, DefaultWriteBatchSize, func(ts []mimirpb.TimeSeries) error {
+				flushed += len(ts)
+				return nil
+			})
+			if tt.errorType != nil {
+				require.Error(t, err)
+				assert.ErrorAs(t, err, tt.errorType)
+				return
+			}
+			// Invalid lines are reported without failing the request.
+			require.NoError(t, err)
+			require.Len(t, lineErrs.errs, 1)
+			assert.Equal(t, 1, lineErrs.errs[0].lineNum)
+			assert.Equal(t, tt.data, lineErrs.errs[0].line)
+			assert.Zero(t, flushed)
 		})
 	}
 }
 
-func TestBatchReadCloser(t *testing.T) {
-	req := httptest.NewRequest("POST", "/write", bytes.NewReader([]byte("m,t1=v1 f1=2 1465839830100400200")))
-	req.Header.Add("Content-Encoding", "gzip")
+func TestParseInfluxLineReaderSkipsInvalidLines(t *testing.T) {
+	data := "m f=1 1465839830100400200\nm f= 1465839830100400200\n" + strings.Repeat("x", 100) + "\nm f=2 1465839830100400200\n"
+	req := httptest.NewRequest("POST", "/write", bytes.NewReader([]byte(data)))
+
+	var timeSeries []mimirpb.TimeSeries
+	_, lineErrs, err := parseInfluxLineReader(context.Background(), req, writeParams{converter: testConverter()}, bodyLimits{}, 50, DefaultWriteBatchSize, func(ts []mimirpb.TimeSeries) error {
+		timeSeries = append(timeSeries, ts...)
+		return nil
+	})
+	require.NoError(t, err)
+	require.Len(t, timeSeries, 2)
+	require.Len(t, lineErrs.errs, 2)
+	assert.Equal(t, 2, lineErrs.errs[0].lineNum)
+	assert.Equal(t, 3, lineErrs.errs[1].lineNum)
+	assert.ErrorIs(t, lineErrs.errs[1].err, errLineTooLong)
+}
+
+func TestParseInfluxLines(t *testing.T) {
+	data := "# comment\nm f=1 1\n\nm f= 2\nm f=3 3\n"
+	conv := testConverter()
+	var timeSeries []mimirpb.TimeSeries
+	bytesRead, lineErrs, err := parseInfluxLines(io.NopCloser(strings.NewReader(data)), writeParams{precision: "s", converter: conv}, conv.newRequestLimits("fake"), 0, 0, func(ts []mimirpb.TimeSeries) error {
+		timeSeries = append(timeSeries, ts...)
+		return nil
+	})
+	require.NoError(t, err)
+	assert.Equal(t, len(data), bytesRead)
+	require.Len(t, timeSeries, 2)
+	assert.Equal(t, int64(1000), timeSeries[0].Samples[0].TimestampMs)
+	assert.Equal(t, int64(3000), timeSeries[1].Samples[0].TimestampMs)
+	require.Len(t, lineErrs.errs, 1)
+	assert.Equal(t, 4, lineErrs.errs[0].lineNum)
+	assert.Equal(t, "m f= 2", lineErrs.errs[0].line)
+}
 
-	_, err := batchReadCloser(req.Body, "gzip", int64(maxSize))
-	require.Error(t, err)
+// testConverter returns a converter with the default options, which drop
+// string fields.
+func testConverter() *converter {
+	recorderMock := &MockRecorder{}
+	recorderMock.On("measureStringFieldsDropped", mock.Anything).Return(nil)
+	return newConverter(ConversionConfig{}, log.NewNopLogger(), recorderMock)
 }
@@ -5,9 +5,12 @@ import (
 	"flag"
 	"fmt"
 	"net"
+	"net/http"
 	"os"
+	"strings"
 
 	"github.com/go-kit/log"
+	"github.com/go-kit/log/level"
 	"github.com/gorilla/mux"
 	"github.com/grafana/dskit/services"
 	"github.com/grafana/mimir-graphite/v2/pkg/appcommon"
@@ -20,7 +23,10 @@ import (
 const (
 	// Upped to 10MB based on empirical evidence of proxy receiving batches from telegraf agent
 	DefaultMaxRequestSizeBytes = 10 << 20 // 10 MB
-	serviceName                = "influx-write-proxy"
+	DefaultMaxLineLengthBytes  = 1 << 20  // 1 MB
+	// DefaultWriteBatchSize bounds the memory used by a request, whatever its size.
+	DefaultWriteBatchSize = 5000
+	serviceName           = "influx-write-proxy"
 )
 
 // ProxyConfig holds objects needed to start running an influx2cortex proxy
@@ -41,16 +47,69 @@ type ProxyConfig struct {
 	// Registerer registers metrics Collectors. If left nil, will use
 	// prometheus.DefaultRegisterer.
 	Registerer prometheus.Registerer
-	// MaxRequestSizeBytes limits the size of an incoming request. Any value less than or equal to 0 means no limit.
+	// MaxRequestSizeBytes limits the size of an incoming request once
+	// decompressed. Any value less than or equal to 0 means no limit.
 	MaxRequestSizeBytes int
+	// MaxWireRequestSizeBytes limits the size of an incoming request as
+	// received, before decompression. Any value less than or equal to 0 means no
+	// limit.
+	MaxWireRequestSizeBytes int
+	// MaxCompressionRatio limits the ratio between the decompressed and the
+	// received size of an incoming request. Any value less than or equal to 0
+	// means no limit.
+	MaxCompressionRatio float64
+	// MaxLineLengthBytes limits the length of a line of an incoming request.
+	// Any value less than or equal to 0 means no limit.
+	MaxLineLengthBytes int
+	// WriteBatchSize is the number of series of an incoming request written per
+	// remote write request. Any value less than or equal to 0 means
+	// DefaultWriteBatchSize.
+	WriteBatchSize int
+	// V1Config configures the InfluxDB 1.x compatible /write endpoint.
+	V1Config V1Config
+	// V2Config configures the InfluxDB 2.x compatible /api/v2/write endpoint.
+	V2Config V2Config
+	// V3Config configures the InfluxDB 3 compatible /api/v3/write_lp endpoint.
+	V3Config V3Config
+	// InfluxVersion is the InfluxDB version reported to clients by the
+	// handshake endpoints and the response headers.
+	InfluxVersion string
+	// UDPConfig configures the optional line protocol UDP listener.
+	UDPConfig UDPConfig
+	// StreamConfig configures the optional line protocol TCP and Unix socket
+	// listeners.
+	StreamConfig StreamConfig
+	// OpenTSDBConfig configures the optional OpenTSDB telnet listener.
+	OpenTSDBConfig OpenTSDBConfig
+	// CollectdConfig configures the optional collectd listener.
+	CollectdConfig CollectdConfig
+	// ConversionConfig configures the conversion of Influx points to series.
+	ConversionConfig ConversionConfig
+	// OverridesFile is the path of a YAML file with the Overrides of the
+	// ConversionConfig for some endpoints and tenants. Empty means none.
+	OverridesFile string
 }
 
 func (c *ProxyConfig) RegisterFlags(flags *flag.FlagSet) {
 	c.HTTPConfig.RegisterFlags(flags)
 	c.RemoteWriteConfig.RegisterFlags(flags)
+	c.V1Config.RegisterFlags(flags)
+	c.V2Config.RegisterFlags(flags)
+	c.V3Config.RegisterFlags(flags)
+	c.UDPConfig.RegisterFlags(flags)
+	c.StreamConfig.RegisterFlags(flags)
+	c.OpenTSDBConfig.RegisterFlags(flags)
+	c.CollectdConfig.RegisterFlags(flags)
+	c.ConversionConfig.RegisterFlags(flags)
 
 	flags.BoolVar(&c.EnableAuth, "auth.enable", true, "require X-Scope-OrgId header")
-	flags.IntVar(&c.MaxRequestSizeBytes, "max.request.size.bytes", DefaultMaxRequestSizeBytes, "limit the size of incoming batches; 0 for no limit")
+	flags.IntVar(&c.MaxRequestSizeBytes, "max.request.size.bytes", DefaultMaxRequestSizeBytes, "limit the decompressed size of incoming batches; 0 for no limit")
+	flags.IntVar(&c.MaxWireRequestSizeBytes, "max.wire.request.size.bytes", DefaultMaxRequestSizeBytes, "limit the size of incoming batches as received, before decompression; 0 for no limit")
+	flags.Float64Var(&c.MaxCompressionRatio, "max.compression.ratio", 0, "limit the ratio between the decompressed and received size of incoming batches; 0 for no limit")
+	flags.IntVar(&c.MaxLineLengthBytes, "max.line.length.bytes", DefaultMaxLineLengthBytes, "limit the length of a line of incoming batches; 0 for no limit")
+	flags.IntVar(&c.WriteBatchSize, "write.batch.size", DefaultWriteBatchSize, "number of series of incoming batches written per remote write request")
+	flags.StringVar(&c.InfluxVersion, "influx.version", DefaultInfluxVersion, "InfluxDB version reported to clients")
+	flags.StringVar(&c.OverridesFile, "overrides.file", "", "YAML file overriding the conversion flags for some endpoints and tenants")
 }
 
 // ProxyService is the actual Influx Proxy dskit service.
@@ -63,6 +122,11 @@ type ProxyService struct {
 	server  *server.Server
 	errChan chan error
 
+	// listeners runs the optional non-HTTP listeners alongside the server. It
+	// is nil when none is enabled.
+	listeners        *services.Manager
+	listenersWatcher *services.FailureWatcher
+
 	tracerCloser func() error
 }
 
@@ -92,7 +156,7 @@ func newProxyWithClient(conf ProxyConfig, client remotewrite.Client) (*ProxyServ
 
 	var authMiddleware middleware.Interface
 	if conf.EnableAuth {
-		authMiddleware = middleware.NewHTTPAuth(conf.Logger)
+		authMiddleware = withoutProbeAuth(middleware.NewHTTPAuth(conf.Logger), conf.HTTPConfig.PathPrefix)
 	} else {
 		authMiddleware = middleware.HTTPFakeAuth{}
 	}
@@ -125,6 +189,41 @@ func newProxyWithClient(conf ProxyConfig, client remotewrite.Client) (*ProxyServ
 		return nil, fmt.Errorf("could not register version build timestamp: %w", err)
 	}
 
+	var listeners []services.Service
+	if conf.UDPConfig.ListenAddress != "" {
+		if err := conf.UDPConfig.Validate(); err != nil {
+			return nil, fmt.Errorf("invalid UDP config: %w", err)
+		}
+		conv := api.converters.get(udpListenerName, conf.UDPConfig.Tenant)
+		listeners = append(listeners, newUDPListener(udpListenerName, conf.UDPConfig, influxPacketParser(conf.UDPConfig.Precision), conv, conf.Logger, client, recorder))
+	}
+	if err := conf.StreamConfig.Validate(); err != nil {
+		return nil, fmt.Errorf("invalid stream config: %w", err)
+	}
+	streamListeners, err := newStreamListeners(conf.StreamConfig, api.converters, conf.Logger, client, recorder)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create stream listeners: %w", err)
+	}
+	listeners = append(listeners, streamListeners...)
+	if conf.CollectdConfig.ListenAddress != "" {
+		if err := conf.CollectdConfig.Validate(); err != nil {
+			return nil, fmt.Errorf("invalid collectd config: %w", err)
+		}
+		opts, err := conf.CollectdConfig.parseOpts()
+		if err != nil {
+			return nil, fmt.Errorf("invalid collectd config: %w", err)
+		}
+		conv := api.converters.get(collectdListenerName, conf.CollectdConfig.Tenant)
+		listeners = append(listeners, newUDPListener(collectdListenerName, conf.CollectdConfig.udpConfig(), collectdPacketParser(opts), conv, conf.Logger, client, recorder))
+	}
+	if conf.OpenTSDBConfig.ListenAddress != "" {
+		if err := conf.OpenTSDBConfig.Validate(); err != nil {
+			return nil, fmt.Errorf("invalid OpenTSDB config: %w", err)
+		}
+		conv := api.converters.get(openTSDBListenerName, conf.OpenTSDBConfig.Tenant)
+		listeners = append(listeners, newStreamListener(openTSDBListenerName, "tcp", conf.OpenTSDBConfig.ListenAddress, nil, conf.OpenTSDBConfig.streamConfig(), openTSDBTelnetParser(recorder), conv, conf.Logger, client, recorder))
+	}
+
 	p := &ProxyService{
 		logger:       conf.Logger,
 		config:       conf,
@@ -132,17 +231,46 @@ func newProxyWithClient(conf ProxyConfig, client remotewrite.Client) (*ProxyServ
 		errChan:      make(chan error, 1),
 		tracerCloser: tracerCloser.Close,
 	}
+	if len(listeners) > 0 {
+		p.listeners, err = services.NewManager(listeners...)
+		if err != nil {
+			return nil, fmt.Errorf("failed to create listeners manager: %w", err)
+		}
+		p.listenersWatcher = services.NewFailureWatcher()
+		p.listenersWatcher.WatchManager(p.listeners)
+	}
 	p.Service = services.NewBasicService(p.start, p.run, p.stop).WithName(serviceName)
 	return p, nil
 }
 
+// withoutProbeAuth wraps handlers with auth, except for the requests to the
+// probePaths under prefix, so that clients can check the proxy before writing.
+func withoutProbeAuth(auth middleware.Interface, prefix string) middleware.Interface {
+	return middleware.Func(func(next http.Handler) http.Handler {
+		authenticated := auth.Wrap(next)
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			if path, ok := strings.CutPrefix(r.URL.Path, prefix); ok && probePaths[path] {
+				next.ServeHTTP(w, r)
+				return
+			}
+			authenticated.ServeHTTP(w, r)
+		})
+	})
+}
+
 // Addr returns the net.Addr for the configured server. This is useful in case
 // it was started with port auto-selection so the port number can be retrieved.
 func (p *ProxyService) Addr() net.Addr {
 	return p.server.Addr()
 }
 
-func (p *ProxyService) start(_ context.Context) error {
+func (p *ProxyService) start(ctx context.Context) error {
+	if p.listeners != nil {
+		if err := services.StartManagerAndAwaitHealthy(ctx, p.listeners); err != nil {
+			return fmt.Errorf("failed to start listeners: %w", err)
+		}
+	}
+
 	// the server does not listen for context canceling, so we have to start it
 	// in a goroutine so we can listen for both.
 	go func() {
@@ -160,11 +288,18 @@ func (p *ProxyService) run(servCtx context.Context) error {
 			return servCtx.Err()
 		case err := <-p.errChan:
 			return err
+		case err := <-p.listenersWatcher.Chan():
+			return fmt.Errorf("listener failed: %w", err)
 		}
 	}
 }
 
 func (p *ProxyService) stop(_ error) error {
 	p.server.Shutdown(nil)
+	if p.listeners != nil {
+		if err := services.StopManagerAndAwaitStopped(context.Background(), p.listeners); err != nil {
+			_ = level.Warn(p.logger).Log("msg", "failed to stop listeners", "err", err)
+		}
+	}
 	return p.tracerCloser()
 }
@@ -24,6 +24,19 @@ type Recorder interface {
 	measureMetricsWritten(count int)
 	measureProxyErrors(reason string)
 	measureConversionDuration(duration time.Duration)
+	measureMessagesReceived(listener string)
+	measureMessagesDropped(listener, reason string)
+	measureReadErrors(listener string)
+	measureOpenTSDBPoints(transport, result string, count int)
+	measureRequestTooLarge(reason string)
+	measureFailedLines(reason string, count int)
+	measureStringFieldsDropped(reason string)
+	measureLargeIntegers(policy string)
+	measureHistograms(format string)
+	measureRelabelDropped(tenant, rule, action string)
+	measureLabelCollisions(policy string)
+	measureSampleValidation(tenant, reason, action string)
+	measureLimitsExceeded(limit, action string)
 	RegisterVersionBuildTimestamp() error
 }
 
@@ -50,6 +63,71 @@ func NewRecorder(reg prometheus.Registerer) Recorder {
 			Help:      "Time (in seconds) spent converting ingested InfluxDB data into Prometheus data.",
 			Buckets:   instrument.DefBuckets,
 		}, []string{}),
+		messagesReceived: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Namespace: prefix,
+			Name:      "listener_messages_received_total",
+			Help:      "The total number of messages (datagrams, or lines for stream listeners) received by the non-HTTP listeners.",
+		}, []string{"listener"}),
+		messagesDropped: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Namespace: prefix,
+			Name:      "listener_messages_dropped_total",
+			Help:      "The total number of messages (datagrams, or lines for stream listeners) fully or partially dropped by the non-HTTP listeners, sliced by reason.",
+		}, []string{"listener", "reason"}),
+		readErrors: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Namespace: prefix,
+			Name:      "listener_read_errors_total",
+			Help:      "The total number of failed reads of datagrams and accepts of connections by the non-HTTP listeners.",
+		}, []string{"listener"}),
+		openTSDBPoints: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Namespace: prefix,
+			Name:      "opentsdb_points_total",
+			Help:      "The total number of OpenTSDB data points received, sliced by transport and result.",
+		}, []string{"transport", "result"}),
+		requestsTooLarge: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Namespace: prefix,
+			Name:      "requests_too_large_total",
+			Help:      "The total number of requests rejected for exceeding a size limit, sliced by the limit exceeded.",
+		}, []string{"reason"}),
+		failedLines: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Namespace: prefix,
+			Name:      "failed_lines_total",
+			Help:      "The total number of line protocol lines of HTTP requests that could not be parsed or were rejected by a policy, sliced by reason.",
+		}, []string{"reason"}),
+		stringFieldsDropped: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Namespace: prefix,
+			Name:      "string_fields_dropped_total",
+			Help:      "The total number of string field values dropped, sliced by reason.",
+		}, []string{"reason"}),
+		largeIntegers: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Namespace: prefix,
+			Name:      "large_integers_total",
+			Help:      "The total number of integer field values larger than 2^53, sliced by the policy applied.",
+		}, []string{"policy"}),
+		histograms: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Namespace: prefix,
+			Name:      "histograms_total",
+			Help:      "The total number of histograms and summaries assembled from Telegraf series, sliced by the format written.",
+		}, []string{"format"}),
+		relabelDropped: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Namespace: prefix,
+			Name:      "relabel_dropped_series_total",
+			Help:      "The total number of series dropped by relabeling, sliced by tenant and by the 1-based index and action of the rule dropping them.",
+		}, []string{"tenant", "rule", "action"}),
+		labelCollisions: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Namespace: prefix,
+			Name:      "label_collisions_total",
+			Help:      "The total number of tags and string fields whose label names collided once sanitized, sliced by the policy applied.",
+		}, []string{"policy"}),
+		sampleValidation: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Namespace: prefix,
+			Name:      "sample_validation_total",
+			Help:      "The total number of samples with a timestamp outside the accepted window, a NaN or infinite value, or an ignored timestamp, sliced by tenant, reason and the action taken.",
+		}, []string{"tenant", "reason", "action"}),
+		limitsExceeded: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Namespace: prefix,
+			Name:      "limits_exceeded_total",
+			Help:      "The total number of lines exceeding a limit on the size of requests or series, sliced by limit and the action taken.",
+		}, []string{"limit", "action"}),
 		buildDateGauge: prometheus.NewGauge(prometheus.GaugeOpts{
 			Namespace:   prefix,
 			Name:        "build_unix_timestamp",
@@ -58,7 +136,7 @@ func NewRecorder(reg prometheus.Registerer) Recorder {
 		}),
 	}
 
-	reg.MustRegister(r.proxyMetricsParsed, r.proxyMetricsWritten, r.proxyErrors, r.conversionDuration, r.buildDateGauge)
+	reg.MustRegister(r.proxyMetricsParsed, r.proxyMetricsWritten, r.proxyErrors, r.conversionDuration, r.messagesReceived, r.messagesDropped, r.readErrors, r.openTSDBPoints, r.requestsTooLarge, r.failedLines, r.stringFieldsDropped, r.largeIntegers, r.histograms, r.relabelDropped, r.labelCollisions, r.sampleValidation, r.limitsExceeded, r.buildDateGauge)
 
 	return r
 }
@@ -70,6 +148,19 @@ type prometheusRecorder struct {
 	proxyMetricsWritten *prometheus.CounterVec
 	proxyErrors         *prometheus.CounterVec
 	conversionDuration  *prometheus.HistogramVec
+	messagesReceived    *prometheus.CounterVec
+	messagesDropped     *prometheus.CounterVec
+	readErrors          *prometheus.CounterVec
+	openTSDBPoints      *prometheus.CounterVec
+	requestsTooLarge    *prometheus.CounterVec
+	failedLines         *prometheus.CounterVec
+	stringFieldsDropped *prometheus.CounterVec
+	largeIntegers       *prometheus.CounterVec
+	histograms          *prometheus.CounterVec
+	relabelDropped      *prometheus.CounterVec
+	labelCollisions     *prometheus.CounterVec
+	sampleValidation    *prometheus.CounterVec
+	limitsExceeded      *prometheus.CounterVec
 	buildDateGauge      prometheus.Gauge
 }
 
@@ -93,6 +184,75 @@ func (r prometheusRecorder) measureConversionDuration(duration time.Duration) {
 	r.conversionDuration.WithLabelValues().Observe(duration.Seconds())
 }
 
+// measureMessagesReceived measures the total amount of datagrams, or lines for
+// stream listeners, received by a listener.
+func (r prometheusRecorder) measureMessagesReceived(listener string) {
+	r.messagesReceived.WithLabelValues(listener).Inc()
+}
+
+// measureMessagesDropped measures the total amount of datagrams, or lines for
+// stream listeners, dropped by a listener.
+func (r prometheusRecorder) measureMessagesDropped(listener, reason string) {
+	r.messagesDropped.WithLabelValues(listener, reason).Inc()
+}
+
+// measureReadErrors measures the total amount of failed reads or accepts of a
+// listener.
+func (r prometheusRecorder) measureReadErrors(listener string) {
+	r.readErrors.WithLabelValues(listener).Inc()
+}
+
+// measureOpenTSDBPoints measures the total amount of OpenTSDB data points received.
+func (r prometheusRecorder) measureOpenTSDBPoints(transport, result string, count int) {
+	r.openTSDBPoints.WithLabelValues(transport, result).Add(float64(count))
+}
+
+// measureRequestTooLarge measures the total amount of requests rejected for exceeding a size limit.
+func (r prometheusRecorder) measureRequestTooLarge(reason string) {
+	r.requestsTooLarge.WithLabelValues(reason).Inc()
+}
+
+// measureFailedLines measures the total amount of lines that could not be parsed.
+func (r prometheusRecorder) measureFailedLines(reason string, count int) {
+	r.failedLines.WithLabelValues(reason).Add(float64(count))
+}
+
+// measureStringFieldsDropped measures the total amount of string field values dropped.
+func (r prometheusRecorder) measureStringFieldsDropped(reason string) {
+	r.stringFieldsDropped.WithLabelValues(reason).Inc()
+}
+
+// measureLargeIntegers measures the total amount of integer field values larger than 2^53.
+func (r prometheusRecorder) measureLargeIntegers(policy string) {
+	r.largeIntegers.WithLabelValues(policy).Inc()
+}
+
+// measureHistograms measures the total amount of histograms and summaries assembled.
+func (r prometheusRecorder) measureHistograms(format string) {
+	r.histograms.WithLabelValues(format).Inc()
+}
+
+// measureRelabelDropped measures the total amount of series dropped by relabeling.
+func (r prometheusRecorder) measureRelabelDropped(tenant, rule, action string) {
+	r.relabelDropped.WithLabelValues(tenant, rule, action).Inc()
+}
+
+// measureLabelCollisions measures the total amount of label name collisions.
+func (r prometheusRecorder) measureLabelCollisions(policy string) {
+	r.labelCollisions.WithLabelValues(policy).Inc()
+}
+
+// measureSampleValidation measures the total amount of samples handled by the
+// timestamp and value policies.
+func (r prometheusRecorder) measureSampleValidation(tenant, reason, action string) {
+	r.sampleValidation.WithLabelValues(tenant, reason, action).Inc()
+}
+
+// measureLimitsExceeded measures the total amount of lines exceeding a limit.
+func (r prometheusRecorder) measureLimitsExceeded(limit, action string) {
+	r.limitsExceeded.WithLabelValues(limit, action).Inc()
+}
+
 func (r prometheusRecorder) RegisterVersionBuildTimestamp() error {
 	parsedCommitTimestamp, err := strconv.ParseFloat(CommitUnixTimestamp, 64)
 	if err != nil {
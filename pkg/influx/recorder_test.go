@@ -88,6 +88,164 @@ influxdb_proxy_ingester_data_conversion_seconds_bucket{le="100"} 1
 influxdb_proxy_ingester_data_conversion_seconds_bucket{le="+Inf"} 1
 influxdb_proxy_ingester_data_conversion_seconds_sum 15
 influxdb_proxy_ingester_data_conversion_seconds_count 1
+`,
+		},
+		"Measure listener messages": {
+			measure: func(r Recorder) {
+				r.measureMessagesReceived("udp")
+				r.measureMessagesReceived("udp")
+				r.measureMessagesDropped("udp", "queue_full")
+				r.measureReadErrors("tcp")
+			},
+			expMetricNames: []string{
+				"influxdb_proxy_ingester_listener_messages_received_total",
+				"influxdb_proxy_ingester_listener_messages_dropped_total",
+				"influxdb_proxy_ingester_listener_read_errors_total",
+			},
+			expMetrics: `
+# HELP influxdb_proxy_ingester_listener_messages_received_total The total number of messages (datagrams, or lines for stream listeners) received by the non-HTTP listeners.
+# TYPE influxdb_proxy_ingester_listener_messages_received_total counter
+influxdb_proxy_ingester_listener_messages_received_total{listener="udp"} 2
+# HELP influxdb_proxy_ingester_listener_messages_dropped_total The total number of messages (datagrams, or lines for stream listeners) fully or partially dropped by the non-HTTP listeners, sliced by reason.
+# TYPE influxdb_proxy_ingester_listener_messages_dropped_total counter
+influxdb_proxy_ingester_listener_messages_dropped_total{listener="udp",reason="queue_full"} 1
+# HELP influxdb_proxy_ingester_listener_read_errors_total The total number of failed reads of datagrams and accepts of connections by the non-HTTP listeners.
+# TYPE influxdb_proxy_ingester_listener_read_errors_total counter
+influxdb_proxy_ingester_listener_read_errors_total{listener="tcp"} 1
+`,
+		},
+		"Measure OpenTSDB points": {
+			measure: func(r Recorder) {
+				r.measureOpenTSDBPoints("http", "accepted", 3)
+				r.measureOpenTSDBPoints("telnet", "invalid", 1)
+			},
+			expMetricNames: []string{
+				"influxdb_proxy_ingester_opentsdb_points_total",
+			},
+			expMetrics: `
+# HELP influxdb_proxy_ingester_opentsdb_points_total The total number of OpenTSDB data points received, sliced by transport and result.
+# TYPE influxdb_proxy_ingester_opentsdb_points_total counter
+influxdb_proxy_ingester_opentsdb_points_total{result="accepted",transport="http"} 3
+influxdb_proxy_ingester_opentsdb_points_total{result="invalid",transport="telnet"} 1
+`,
+		},
+		"Measure requests too large": {
+			measure: func(r Recorder) {
+				r.measureRequestTooLarge("decompressed")
+			},
+			expMetricNames: []string{
+				"influxdb_proxy_ingester_requests_too_large_total",
+			},
+			expMetrics: `
+# HELP influxdb_proxy_ingester_requests_too_large_total The total number of requests rejected for exceeding a size limit, sliced by the limit exceeded.
+# TYPE influxdb_proxy_ingester_requests_too_large_total counter
+influxdb_proxy_ingester_requests_too_large_total{reason="decompressed"} 1
+`,
+		},
+		"Measure failed lines": {
+			measure: func(r Recorder) {
+				r.measureFailedLines("parse_error", 2)
+				r.measureFailedLines("line_too_long", 1)
+			},
+			expMetricNames: []string{
+				"influxdb_proxy_ingester_failed_lines_total",
+			},
+			expMetrics: `
+# HELP influxdb_proxy_ingester_failed_lines_total The total number of line protocol lines of HTTP requests that could not be parsed or were rejected by a policy, sliced by reason.
+# TYPE influxdb_proxy_ingester_failed_lines_total counter
+influxdb_proxy_ingester_failed_lines_total{reason="line_too_long"} 1
+influxdb_proxy_ingester_failed_lines_total{reason="parse_error"} 2
+`,
+		},
+		"Measure string fields dropped": {
+			measure: func(r Recorder) {
+				r.measureStringFieldsDropped("cardinality")
+			},
+			expMetricNames: []string{
+				"influxdb_proxy_ingester_string_fields_dropped_total",
+			},
+			expMetrics: `
+# HELP influxdb_proxy_ingester_string_fields_dropped_total The total number of string field values dropped, sliced by reason.
+# TYPE influxdb_proxy_ingester_string_fields_dropped_total counter
+influxdb_proxy_ingester_string_fields_dropped_total{reason="cardinality"} 1
+`,
+		},
+		"Measure large integers": {
+			measure: func(r Recorder) {
+				r.measureLargeIntegers("split")
+			},
+			expMetricNames: []string{
+				"influxdb_proxy_ingester_large_integers_total",
+			},
+			expMetrics: `
+# HELP influxdb_proxy_ingester_large_integers_total The total number of integer field values larger than 2^53, sliced by the policy applied.
+# TYPE influxdb_proxy_ingester_large_integers_total counter
+influxdb_proxy_ingester_large_integers_total{policy="split"} 1
+`,
+		},
+		"Measure histograms": {
+			measure: func(r Recorder) {
+				r.measureHistograms("native")
+			},
+			expMetricNames: []string{
+				"influxdb_proxy_ingester_histograms_total",
+			},
+			expMetrics: `
+# HELP influxdb_proxy_ingester_histograms_total The total number of histograms and summaries assembled from Telegraf series, sliced by the format written.
+# TYPE influxdb_proxy_ingester_histograms_total counter
+influxdb_proxy_ingester_histograms_total{format="native"} 1
+`,
+		},
+		"Measure relabel dropped": {
+			measure: func(r Recorder) {
+				r.measureRelabelDropped("a", "2", "drop")
+			},
+			expMetricNames: []string{
+				"influxdb_proxy_ingester_relabel_dropped_series_total",
+			},
+			expMetrics: `
+# HELP influxdb_proxy_ingester_relabel_dropped_series_total The total number of series dropped by relabeling, sliced by tenant and by the 1-based index and action of the rule dropping them.
+# TYPE influxdb_proxy_ingester_relabel_dropped_series_total counter
+influxdb_proxy_ingester_relabel_dropped_series_total{action="drop",rule="2",tenant="a"} 1
+`,
+		},
+		"Measure label collisions": {
+			measure: func(r Recorder) {
+				r.measureLabelCollisions("suffix")
+			},
+			expMetricNames: []string{
+				"influxdb_proxy_ingester_label_collisions_total",
+			},
+			expMetrics: `
+# HELP influxdb_proxy_ingester_label_collisions_total The total number of tags and string fields whose label names collided once sanitized, sliced by the policy applied.
+# TYPE influxdb_proxy_ingester_label_collisions_total counter
+influxdb_proxy_ingester_label_collisions_total{policy="suffix"} 1
+`,
+		},
+		"Measure sample validation": {
+			measure: func(r Recorder) {
+				r.measureSampleValidation("a", "too_old", "clamp")
+			},
+			expMetricNames: []string{
+				"influxdb_proxy_ingester_sample_validation_total",
+			},
+			expMetrics: `
+# HELP influxdb_proxy_ingester_sample_validation_total The total number of samples with a timestamp outside the accepted window, a NaN or infinite value, or an ignored timestamp, sliced by tenant, reason and the action taken.
+# TYPE influxdb_proxy_ingester_sample_validation_total counter
+influxdb_proxy_ingester_sample_validation_total{action="clamp",reason="too_old",tenant="a"} 1
+`,
+		},
+		"Measure limits exceeded": {
+			measure: func(r Recorder) {
+				r.measureLimitsExceeded("labels_per_series", "hash")
+			},
+			expMetricNames: []string{
+				"influxdb_proxy_ingester_limits_exceeded_total",
+			},
+			expMetrics: `
+# HELP influxdb_proxy_ingester_limits_exceeded_total The total number of lines exceeding a limit on the size of requests or series, sliced by limit and the action taken.
+# TYPE influxdb_proxy_ingester_limits_exceeded_total counter
+influxdb_proxy_ingester_limits_exceeded_total{action="hash",limit="labels_per_series"} 1
 `,
 		},
 		"Register version build timestamp": {
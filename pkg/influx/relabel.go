@@ -0,0 +1,128 @@
+package influx
+
+import (
+	"fmt"
+	"os"
+	"regexp"
+	"strconv"
+
+	"github.com/grafana/mimir/pkg/mimirpb"
+	"github.com/prometheus/prometheus/model/labels"
+	"github.com/prometheus/prometheus/model/relabel"
+	"gopkg.in/yaml.v3"
+)
+
+// relabelReferenceRE matches the references to the groups of the regular
+// expression of a relabeling rule in its replacement.
+var relabelReferenceRE = regexp.MustCompile(`\$(\w+|\{\w+\})`)
+
+// RelabelConfigs are Prometheus relabeling rules. Unlike relabel.Config, they
+// accept references in the replacements of labelmap rules whatever the name
+// validation scheme, which Mimir sets to the legacy one.
+type RelabelConfigs []*relabel.Config
+
+// plainRelabelConfig is a relabel.Config decoded without its validation.
+type plainRelabelConfig relabel.Config
+
+func (r *RelabelConfigs) UnmarshalYAML(value *yaml.Node) error {
+	var nodes []yaml.Node
+	if err := value.Decode(&nodes); err != nil {
+		return err
+	}
+	cfgs := make(RelabelConfigs, 0, len(nodes))
+	for i := range nodes {
+		cfg := relabel.DefaultRelabelConfig
+		if err := decodeNode(nodes[i], (*plainRelabelConfig)(&cfg)); err != nil {
+			return fmt.Errorf("invalid relabel config %d: %w", i+1, err)
+		}
+		if cfg.Regex.Regexp == nil {
+			cfg.Regex = relabel.MustNewRegexp("")
+		}
+		cfgs = append(cfgs, &cfg)
+	}
+	*r = cfgs
+	return cfgs.Validate()
+}
+
+// relabelConfigFile is a flag loading the relabeling rules in the YAML file at
+// its path.
+type relabelConfigFile struct {
+	path    string
+	configs *RelabelConfigs
+}
+
+func (f *relabelConfigFile) String() string {
+	return f.path
+}
+
+func (f *relabelConfigFile) Set(path string) error {
+	b, err := os.ReadFile(path)
+	if err != nil {
+		return err
+	}
+	var cfgs RelabelConfigs
+	if err := yaml.Unmarshal(b, &cfgs); err != nil {
+		return fmt.Errorf("invalid relabel config file %s: %w", path, err)
+	}
+	f.path = path
+	*f.configs = cfgs
+	return nil
+}
+
+// Validate checks the relabeling rules are usable.
+func (r RelabelConfigs) Validate() error {
+	for i, cfg := range r {
+		if cfg == nil {
+			return fmt.Errorf("invalid relabel config %d: empty rule", i+1)
+		}
+		validated := *cfg
+		if validated.Action == relabel.LabelMap {
+			validated.Replacement = relabelReferenceRE.ReplaceAllString(validated.Replacement, "_")
+		}
+		if err := validated.Validate(); err != nil {
+			return fmt.Errorf("invalid relabel config %d: %w", i+1, err)
+		}
+	}
+	return nil
+}
+
+// relabel applies the relabeling rules of c to the labels of the series of ts
+// written for tenant, returning the series that are kept. The series dropped
+// are counted by rule.
+func (c *converter) relabel(tenant string, ts []mimirpb.TimeSeries) []mimirpb.TimeSeries {
+	if len(c.relabelConfigs) == 0 {
+		return ts
+	}
+
+	kept := make([]mimirpb.TimeSeries, 0, len(ts))
+	lb := labels.NewBuilder(labels.EmptyLabels())
+	for _, s := range ts {
+		lb.Reset(mimirpb.FromLabelAdaptersToLabels(s.Labels))
+		if rule, keep := c.relabelSeries(lb); !keep {
+			c.recorder.measureRelabelDropped(tenant, strconv.Itoa(rule+1), string(c.relabelConfigs[rule].Action))
+			continue
+		}
+		lbls := lb.Labels()
+		if lbls.IsEmpty() {
+			// Like Prometheus, series left without labels are dropped too,
+			// and counted against the last rule.
+			last := len(c.relabelConfigs) - 1
+			c.recorder.measureRelabelDropped(tenant, strconv.Itoa(last+1), string(c.relabelConfigs[last].Action))
+			continue
+		}
+		s.Labels = mimirpb.FromLabelsToLabelAdapters(lbls)
+		kept = append(kept, s)
+	}
+	return kept
+}
+
+// relabelSeries applies the relabeling rules to the labels of a series in lb,
+// returning whether it is kept, or the index of the rule dropping it.
+func (c *converter) relabelSeries(lb *labels.Builder) (int, bool) {
+	for i, cfg := range c.relabelConfigs {
+		if !relabel.ProcessBuilder(lb, cfg) {
+			return i, false
+		}
+	}
+	return 0, true
+}
@@ -0,0 +1,141 @@
+package influx
+
+import (
+	"flag"
+	"io"
+	"testing"
+	"time"
+
+	"github.com/go-kit/log"
+	"github.com/grafana/mimir/pkg/mimirpb"
+	"github.com/prometheus/prometheus/model/relabel"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestRelabel(t *testing.T) {
+	overrides, err := LoadOverrides(writeOverrides(t, `
+defaults:
+  relabel_configs:
+    - source_labels: [__name__]
+      regex: procstat_.*
+      action: drop
+    - regex: pid|uuid
+      action: labeldrop
+    - source_labels: [host]
+      regex: (.*)\.example\.com
+      target_label: host
+      replacement: $1
+tenants:
+  team-a:
+    relabel_configs:
+      - source_labels: [__name__]
+        regex: cpu_.*
+        action: keep
+      - regex: tag_(.*)
+        action: labelmap
+`))
+	require.NoError(t, err)
+
+	recorderMock := &MockRecorder{}
+	recorderMock.On("measureRelabelDropped", "other", "1", "drop").Return(nil)
+	recorderMock.On("measureRelabelDropped", "team-a", "1", "keep").Return(nil)
+	convs, err := newConverters(ConversionConfig{}, overrides, log.NewNopLogger(), recorderMock)
+	require.NoError(t, err)
+
+	points, err := parsePointsWithPrecision([]byte(`cpu,host=a.example.com,pid=1,tag_env=prod usage_idle=1 1000000000
+procstat,host=b memory_rss=2 1000000000`), time.Now(), "ns")
+	require.NoError(t, err)
+
+	tests := []struct {
+		tenant   string
+		expected []mimirpb.LabelAdapter
+	}{
+		{
+			tenant: "other",
+			expected: []mimirpb.LabelAdapter{
+				{Name: "__name__", Value: "cpu_usage_idle"},
+				{Name: "__proxy_source__", Value: "influx"},
+				{Name: "host", Value: "a"},
+				{Name: "tag_env", Value: "prod"},
+			},
+		},
+		{
+			tenant: "team-a",
+			expected: []mimirpb.LabelAdapter{
+				{Name: "__name__", Value: "cpu_usage_idle"},
+				{Name: "__proxy_source__", Value: "influx"},
+				{Name: "env", Value: "prod"},
+				{Name: "host", Value: "a.example.com"},
+				{Name: "pid", Value: "1"},
+				{Name: "tag_env", Value: "prod"},
+			},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.tenant, func(t *testing.T) {
+			conv := convs.get(endpointPush, tt.tenant)
+			ts, err := conv.writeRequestFromInfluxPoints("fake", points, nil)
+			require.NoError(t, err)
+
+			ts = conv.relabel(tt.tenant, ts)
+			require.Len(t, ts, 1)
+			assert.Equal(t, tt.expected, ts[0].Labels)
+			assert.Equal(t, []mimirpb.Sample{{TimestampMs: 1000, Value: 1}}, ts[0].Samples)
+		})
+	}
+	recorderMock.AssertNumberOfCalls(t, "measureRelabelDropped", 2)
+}
+
+func TestRelabelEmptyLabels(t *testing.T) {
+	recorderMock := &MockRecorder{}
+	recorderMock.On("measureRelabelDropped", "a", "1", "labeldrop").Return(nil)
+	conv := newConverter(ConversionConfig{RelabelConfigs: RelabelConfigs{{
+		Regex:  relabel.MustNewRegexp(".*"),
+		Action: relabel.LabelDrop,
+	}}}, log.NewNopLogger(), recorderMock)
+
+	ts := conv.relabel("a", []mimirpb.TimeSeries{{Labels: []mimirpb.LabelAdapter{{Name: "__name__", Value: "m"}}}})
+	assert.Empty(t, ts)
+	recorderMock.AssertExpectations(t)
+}
+
+func TestValidateRelabelConfigs(t *testing.T) {
+	assert.NoError(t, ConversionConfig{RelabelConfigs: RelabelConfigs{{Regex: relabel.MustNewRegexp("a"), Action: relabel.Drop}}}.Validate())
+	assert.Error(t, ConversionConfig{RelabelConfigs: RelabelConfigs{nil}}.Validate())
+	assert.Error(t, ConversionConfig{RelabelConfigs: RelabelConfigs{{Action: relabel.HashMod, TargetLabel: "shard"}}}.Validate())
+
+	for _, data := range []string{
+		"defaults:\n  relabel_configs:\n    - action: replace\n",
+		"defaults:\n  relabel_configs:\n    - action: drop\n      regexp: a\n",
+	} {
+		_, err := newConverters(ConversionConfig{}, mustLoadOverrides(t, data), log.NewNopLogger(), &MockRecorder{})
+		assert.Error(t, err, data)
+	}
+}
+
+func TestRelabelConfigFile(t *testing.T) {
+	var cfg ConversionConfig
+	flags := flag.NewFlagSet("test", flag.ContinueOnError)
+	cfg.RegisterFlags(flags)
+	path := writeOverrides(t, `
+- source_labels: [__name__]
+  regex: procstat_.*
+  action: drop
+`)
+	require.NoError(t, flags.Parse([]string{"-relabel.config-file", path}))
+	require.Len(t, cfg.RelabelConfigs, 1)
+	assert.Equal(t, relabel.Drop, cfg.RelabelConfigs[0].Action)
+
+	flags = flag.NewFlagSet("test", flag.ContinueOnError)
+	flags.SetOutput(io.Discard)
+	cfg.RegisterFlags(flags)
+	assert.Error(t, flags.Parse([]string{"-relabel.config-file", writeOverrides(t, "- action: explode\n")}))
+	assert.Error(t, flags.Parse([]string{"-relabel.config-file", path + ".missing"}))
+}
+
+func mustLoadOverrides(t *testing.T, data string) Overrides {
+	overrides, err := LoadOverrides(writeOverrides(t, data))
+	require.NoError(t, err)
+	return overrides
+}
@@ -0,0 +1,233 @@
+package influx
+
+import (
+	"flag"
+	"fmt"
+	"math"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/grafana/mimir/pkg/mimirpb"
+)
+
+// Policies for the samples whose timestamp is outside the accepted window.
+const (
+	// TimestampsDrop drops the samples.
+	TimestampsDrop = "drop"
+	// TimestampsClamp moves their timestamp to the nearest end of the window.
+	TimestampsClamp = "clamp"
+	// TimestampsReplace replaces their timestamp with the time they are
+	// written.
+	TimestampsReplace = "replace"
+)
+
+// Policies for the samples whose value is NaN or infinite.
+const (
+	// ValuesAccept writes them as they are.
+	ValuesAccept = "accept"
+	// ValuesDrop drops the samples.
+	ValuesDrop = "drop"
+	// ValuesZero replaces their value with 0.
+	ValuesZero = "zero"
+	// ValuesClamp replaces infinite values with the largest finite float64 of
+	// the same sign. It doesn't apply to NaN.
+	ValuesClamp = "clamp"
+)
+
+// Reasons for the samples handled by the timestamp and value policies.
+const (
+	sampleTooOld           = "too_old"
+	sampleTooNew           = "too_new"
+	sampleIgnoredTimestamp = "ignored_timestamp"
+	sampleNaN              = "nan"
+	sampleInf              = "inf"
+)
+
+// SamplesConfig configures the validation of the timestamps and values of the
+// samples converted from Influx points, so that a client with a broken clock
+// doesn't fail the writes of the whole batch.
+type SamplesConfig struct {
+	// PastWindow is how far in the past timestamps are accepted. Any value
+	// less than or equal to 0 means no limit.
+	PastWindow time.Duration `yaml:"past_window"`
+	// FutureWindow is how far in the future timestamps are accepted. Any
+	// value less than or equal to 0 means no limit.
+	FutureWindow time.Duration `yaml:"future_window"`
+	// OutOfWindow is the policy for timestamps outside the window:
+	// TimestampsDrop, TimestampsClamp or TimestampsReplace. Empty means
+	// TimestampsDrop.
+	OutOfWindow string `yaml:"out_of_window"`
+	// IgnoreTimestamps replaces all the timestamps sent by clients with the
+	// time the samples are written.
+	IgnoreTimestamps bool `yaml:"ignore_timestamps"`
+	// NaN is the policy for NaN values: ValuesAccept, ValuesDrop or
+	// ValuesZero. Empty means ValuesAccept.
+	NaN string `yaml:"nan"`
+	// Inf is the policy for infinite values: ValuesAccept, ValuesDrop,
+	// ValuesZero or ValuesClamp. Empty means ValuesAccept.
+	Inf string `yaml:"inf"`
+}
+
+func (c *SamplesConfig) RegisterFlags(flags *flag.FlagSet) {
+	flags.DurationVar(&c.PastWindow, "samples.past.window", 0, "how far in the past sample timestamps are accepted (0 for no limit)")
+	flags.DurationVar(&c.FutureWindow, "samples.future.window", 0, "how far in the future sample timestamps are accepted (0 for no limit)")
+	flags.StringVar(&c.OutOfWindow, "samples.out.of.window", TimestampsDrop, fmt.Sprintf("what to do with samples whose timestamp is outside the accepted window: %s them, %s the timestamp to the window, or %s it with the time of the write", TimestampsDrop, TimestampsClamp, TimestampsReplace))
+	flags.BoolVar(&c.IgnoreTimestamps, "samples.ignore.timestamps", false, "replace the timestamps sent by clients with the time of the write")
+	flags.StringVar(&c.NaN, "samples.nan", ValuesAccept, fmt.Sprintf("what to do with NaN values: %s, %s or replace with %s", ValuesAccept, ValuesDrop, ValuesZero))
+	flags.StringVar(&c.Inf, "samples.inf", ValuesAccept, fmt.Sprintf("what to do with infinite values: %s, %s, replace with %s, or %s to the largest finite value", ValuesAccept, ValuesDrop, ValuesZero, ValuesClamp))
+}
+
+// Validate checks the configuration is usable.
+func (c SamplesConfig) Validate() error {
+	switch c.OutOfWindow {
+	case "", TimestampsDrop, TimestampsClamp, TimestampsReplace:
+	default:
+		return fmt.Errorf("invalid out of window policy %q", c.OutOfWindow)
+	}
+	switch c.NaN {
+	case "", ValuesAccept, ValuesDrop, ValuesZero:
+	default:
+		return fmt.Errorf("invalid NaN policy %q", c.NaN)
+	}
+	switch c.Inf {
+	case "", ValuesAccept, ValuesDrop, ValuesZero, ValuesClamp:
+	default:
+		return fmt.Errorf("invalid Inf policy %q", c.Inf)
+	}
+	return nil
+}
+
+// validateSamples applies the timestamp and value policies of c to the
+// samples of the series of ts written for tenant at now, returning the series
+// left with samples. Every sample handled by a policy is counted by tenant.
+func (c *converter) validateSamples(tenant string, ts []mimirpb.TimeSeries, now time.Time) []mimirpb.TimeSeries {
+	nowMs := now.UnixMilli()
+	kept := make([]mimirpb.TimeSeries, 0, len(ts))
+	// retimed reports whether a timestamp was changed, which may give
+	// samples of the same series the same timestamp.
+	retimed := false
+	for _, s := range ts {
+		samples := make([]mimirpb.Sample, 0, len(s.Samples))
+		for _, sample := range s.Samples {
+			timestampMs, ok := c.validateTimestamp(tenant, sample.TimestampMs, nowMs)
+			if !ok {
+				continue
+			}
+			retimed = retimed || timestampMs != sample.TimestampMs
+			sample.TimestampMs = timestampMs
+			if sample.Value, ok = c.validateValue(tenant, sample.Value); !ok {
+				continue
+			}
+			samples = append(samples, sample)
+		}
+		if len(samples) == 0 {
+			continue
+		}
+		s.Samples = samples
+		kept = append(kept, s)
+	}
+	if retimed {
+		kept = lastSamples(kept)
+	}
+	return kept
+}
+
+// validateTimestamp returns the timestamp to write a sample with instead of
+// timestampMs at nowMs, or false if the sample is dropped.
+func (c *converter) validateTimestamp(tenant string, timestampMs, nowMs int64) (int64, bool) {
+	if c.samples.IgnoreTimestamps {
+		c.recorder.measureSampleValidation(tenant, sampleIgnoredTimestamp, TimestampsReplace)
+		return nowMs, true
+	}
+
+	var reason string
+	var bound int64
+	switch {
+	case c.samples.PastWindow > 0 && timestampMs < nowMs-c.samples.PastWindow.Milliseconds():
+		reason, bound = sampleTooOld, nowMs-c.samples.PastWindow.Milliseconds()
+	case c.samples.FutureWindow > 0 && timestampMs > nowMs+c.samples.FutureWindow.Milliseconds():
+		reason, bound = sampleTooNew, nowMs+c.samples.FutureWindow.Milliseconds()
+	default:
+		return timestampMs, true
+	}
+
+	policy := c.samples.OutOfWindow
+	if policy == "" {
+		policy = TimestampsDrop
+	}
+	c.recorder.measureSampleValidation(tenant, reason, policy)
+	switch policy {
+	case TimestampsClamp:
+		return bound, true
+	case TimestampsReplace:
+		return nowMs, true
+	}
+	return 0, false
+}
+
+// validateValue returns the value to write a sample with instead of value, or
+// false if the sample is dropped.
+func (c *converter) validateValue(tenant string, value float64) (float64, bool) {
+	var reason, policy string
+	switch {
+	case math.IsNaN(value):
+		reason, policy = sampleNaN, c.samples.NaN
+	case math.IsInf(value, 0):
+		reason, policy = sampleInf, c.samples.Inf
+	default:
+		return value, true
+	}
+
+	if policy == "" {
+		policy = ValuesAccept
+	}
+	c.recorder.measureSampleValidation(tenant, reason, policy)
+	switch policy {
+	case ValuesDrop:
+		return 0, false
+	case ValuesZero:
+		return 0, true
+	case ValuesClamp:
+		return math.Copysign(math.MaxFloat64, value), true
+	}
+	return value, true
+}
+
+// lastSamples keeps the last of the samples of the same series with the same
+// timestamp, as InfluxDB overwrites the points of a series with the same
+// timestamp, rather than have Mimir reject them as duplicates. The samples of
+// ts are filtered in place.
+func lastSamples(ts []mimirpb.TimeSeries) []mimirpb.TimeSeries {
+	type location struct {
+		series, sample int
+	}
+	seen := map[string]location{}
+	var sb strings.Builder
+	kept := ts[:0]
+	for _, s := range ts {
+		sb.Reset()
+		writeSeriesKey(&sb, s.Labels)
+		prefix := sb.String()
+		samples := s.Samples[:0]
+		for _, sample := range s.Samples {
+			key := prefix + strconv.FormatInt(sample.TimestampMs, 10)
+			if loc, ok := seen[key]; ok {
+				if loc.series == len(kept) {
+					samples[loc.sample].Value = sample.Value
+				} else {
+					kept[loc.series].Samples[loc.sample].Value = sample.Value
+				}
+				continue
+			}
+			seen[key] = location{series: len(kept), sample: len(samples)}
+			samples = append(samples, sample)
+		}
+		if len(samples) == 0 {
+			continue
+		}
+		s.Samples = samples
+		kept = append(kept, s)
+	}
+	return kept
+}
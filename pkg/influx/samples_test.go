@@ -0,0 +1,189 @@
+package influx
+
+import (
+	"fmt"
+	"math"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"collectd.org/api"
+	"github.com/go-kit/log"
+	"github.com/grafana/dskit/user"
+	"github.com/grafana/mimir-graphite/v2/pkg/remotewrite/remotewritemock"
+	"github.com/grafana/mimir/pkg/mimirpb"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/mock"
+	"github.com/stretchr/testify/require"
+)
+
+func TestValidateSamples(t *testing.T) {
+	now := time.UnixMilli(1_000_000_000)
+	nowMs := now.UnixMilli()
+	series := func(name string, samples ...mimirpb.Sample) mimirpb.TimeSeries {
+		return mimirpb.TimeSeries{Labels: []mimirpb.LabelAdapter{{Name: "__name__", Value: name}}, Samples: samples}
+	}
+	ts := func() []mimirpb.TimeSeries {
+		return []mimirpb.TimeSeries{
+			series("old", mimirpb.Sample{TimestampMs: nowMs - 2*time.Hour.Milliseconds(), Value: 1}),
+			series("new", mimirpb.Sample{TimestampMs: nowMs + time.Hour.Milliseconds(), Value: 2}),
+			series("ok", mimirpb.Sample{TimestampMs: nowMs - 1000, Value: 3}),
+			series("nan", mimirpb.Sample{TimestampMs: nowMs, Value: math.NaN()}),
+			series("inf", mimirpb.Sample{TimestampMs: nowMs, Value: math.Inf(-1)}),
+		}
+	}
+
+	tests := []struct {
+		name     string
+		cfg      SamplesConfig
+		expected []mimirpb.TimeSeries
+		measured [][3]string
+	}{
+		{
+			name: "default",
+			expected: []mimirpb.TimeSeries{
+				series("old", mimirpb.Sample{TimestampMs: nowMs - 2*time.Hour.Milliseconds(), Value: 1}),
+				series("new", mimirpb.Sample{TimestampMs: nowMs + time.Hour.Milliseconds(), Value: 2}),
+				series("ok", mimirpb.Sample{TimestampMs: nowMs - 1000, Value: 3}),
+				series("nan", mimirpb.Sample{TimestampMs: nowMs, Value: math.NaN()}),
+				series("inf", mimirpb.Sample{TimestampMs: nowMs, Value: math.Inf(-1)}),
+			},
+			measured: [][3]string{{"a", "nan", "accept"}, {"a", "inf", "accept"}},
+		},
+		{
+			name: "drop",
+			cfg:  SamplesConfig{PastWindow: time.Hour, FutureWindow: 10 * time.Minute, NaN: ValuesDrop, Inf: ValuesDrop},
+			expected: []mimirpb.TimeSeries{
+				series("ok", mimirpb.Sample{TimestampMs: nowMs - 1000, Value: 3}),
+			},
+			measured: [][3]string{{"a", "too_old", "drop"}, {"a", "too_new", "drop"}, {"a", "nan", "drop"}, {"a", "inf", "drop"}},
+		},
+		{
+			name: "clamp",
+			cfg:  SamplesConfig{PastWindow: time.Hour, FutureWindow: 10 * time.Minute, OutOfWindow: TimestampsClamp, NaN: ValuesZero, Inf: ValuesClamp},
+			expected: []mimirpb.TimeSeries{
+				series("old", mimirpb.Sample{TimestampMs: nowMs - time.Hour.Milliseconds(), Value: 1}),
+				series("new", mimirpb.Sample{TimestampMs: nowMs + 10*time.Minute.Milliseconds(), Value: 2}),
+				series("ok", mimirpb.Sample{TimestampMs: nowMs - 1000, Value: 3}),
+				series("nan", mimirpb.Sample{TimestampMs: nowMs, Value: 0}),
+				series("inf", mimirpb.Sample{TimestampMs: nowMs, Value: -math.MaxFloat64}),
+			},
+			measured: [][3]string{{"a", "too_old", "clamp"}, {"a", "too_new", "clamp"}, {"a", "nan", "zero"}, {"a", "inf", "clamp"}},
+		},
+		{
+			name: "replace",
+			cfg:  SamplesConfig{FutureWindow: 10 * time.Minute, OutOfWindow: TimestampsReplace, NaN: ValuesDrop, Inf: ValuesZero},
+			expected: []mimirpb.TimeSeries{
+				series("old", mimirpb.Sample{TimestampMs: nowMs - 2*time.Hour.Milliseconds(), Value: 1}),
+				series("new", mimirpb.Sample{TimestampMs: nowMs, Value: 2}),
+				series("ok", mimirpb.Sample{TimestampMs: nowMs - 1000, Value: 3}),
+				series("inf", mimirpb.Sample{TimestampMs: nowMs, Value: 0}),
+			},
+			measured: [][3]string{{"a", "too_new", "replace"}, {"a", "nan", "drop"}, {"a", "inf", "zero"}},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			recorderMock := &MockRecorder{}
+			for _, m := range tt.measured {
+				recorderMock.On("measureSampleValidation", m[0], m[1], m[2]).Return(nil).Once()
+			}
+			conv := newConverter(ConversionConfig{Samples: tt.cfg}, log.NewNopLogger(), recorderMock)
+
+			// Compared as strings, as NaN isn't equal to itself.
+			assert.Equal(t, fmt.Sprint(tt.expected), fmt.Sprint(conv.validateSamples("a", ts(), now)))
+			recorderMock.AssertExpectations(t)
+		})
+	}
+}
+
+func TestValidateSamplesIgnoreTimestamps(t *testing.T) {
+	now := time.UnixMilli(1_000_000_000)
+	recorderMock := &MockRecorder{}
+	recorderMock.On("measureSampleValidation", "a", "ignored_timestamp", "replace").Return(nil)
+	conv := newConverter(ConversionConfig{Samples: SamplesConfig{IgnoreTimestamps: true}}, log.NewNopLogger(), recorderMock)
+
+	a := []mimirpb.LabelAdapter{{Name: "__name__", Value: "a"}}
+	b := []mimirpb.LabelAdapter{{Name: "__name__", Value: "b"}}
+	ts := conv.validateSamples("a", []mimirpb.TimeSeries{
+		{Labels: a, Samples: []mimirpb.Sample{{TimestampMs: 1, Value: 1}}},
+		{Labels: b, Samples: []mimirpb.Sample{{TimestampMs: 1, Value: 2}}},
+		{Labels: a, Samples: []mimirpb.Sample{{TimestampMs: 2, Value: 3}, {TimestampMs: 3, Value: 4}}},
+	}, now)
+
+	// The samples of a now have the same timestamp, and the last one wins.
+	assert.Equal(t, []mimirpb.TimeSeries{
+		{Labels: a, Samples: []mimirpb.Sample{{TimestampMs: now.UnixMilli(), Value: 4}}},
+		{Labels: b, Samples: []mimirpb.Sample{{TimestampMs: now.UnixMilli(), Value: 2}}},
+	}, ts)
+	recorderMock.AssertNumberOfCalls(t, "measureSampleValidation", 4)
+}
+
+func TestValidateSamplesOtherProtocols(t *testing.T) {
+	now := time.Now().Truncate(time.Second)
+	cfg := ConversionConfig{Samples: SamplesConfig{FutureWindow: 10 * time.Minute, NaN: ValuesDrop, Inf: ValuesZero}}
+
+	t.Run("opentsdb", func(t *testing.T) {
+		remoteWriteMock := &remotewritemock.Client{}
+		remoteWriteMock.On("Write", mock.Anything, mock.MatchedBy(func(req *mimirpb.WriteRequest) bool {
+			return len(req.Timeseries) == 1 && req.Timeseries[0].Samples[0].Value == 1
+		})).Return(nil)
+		recorderMock := &MockRecorder{}
+		recorderMock.On("measureMetricsParsed", 3).Return(nil)
+		recorderMock.On("measureMetricsWritten", 1).Return(nil)
+		recorderMock.On("measureConversionDuration", mock.Anything).Return(nil)
+		recorderMock.On("measureOpenTSDBPoints", "http", mock.Anything, mock.Anything).Return(nil)
+		recorderMock.On("measureSampleValidation", "fake", "too_new", "drop").Return(nil).Once()
+		recorderMock.On("measureSampleValidation", "fake", "nan", "drop").Return(nil).Once()
+
+		api, err := NewAPI(ProxyConfig{Logger: log.NewNopLogger(), ConversionConfig: cfg}, remoteWriteMock, recorderMock)
+		require.NoError(t, err)
+
+		req := httptest.NewRequest("POST", "/api/put", strings.NewReader(fmt.Sprintf(`[
+			{"metric": "m", "timestamp": %[1]d, "value": 1, "tags": {"host": "a"}},
+			{"metric": "m", "timestamp": %[2]d, "value": 2, "tags": {"host": "b"}},
+			{"metric": "m", "timestamp": %[1]d, "value": "NaN", "tags": {"host": "c"}}
+		]`, now.Unix(), now.Add(time.Hour).Unix())))
+		req = req.WithContext(user.InjectOrgID(req.Context(), "fake"))
+		rec := httptest.NewRecorder()
+		api.handleOpenTSDBPut(rec, req)
+
+		assert.Equal(t, http.StatusNoContent, rec.Code)
+		remoteWriteMock.AssertExpectations(t)
+		recorderMock.AssertExpectations(t)
+	})
+
+	t.Run("collectd", func(t *testing.T) {
+		remoteWriteMock := &remotewritemock.Client{}
+		remoteWriteMock.On("Write", mock.Anything, mock.MatchedBy(func(req *mimirpb.WriteRequest) bool {
+			return len(req.Timeseries) == 1 && req.Timeseries[0].Samples[0].Value == 0
+		})).Return(nil)
+		recorderMock := &MockRecorder{}
+		recorderMock.On("measureMetricsWritten", 1).Return(nil)
+		recorderMock.On("measureSampleValidation", "collectd-tenant", "inf", "zero").Return(nil).Once()
+		conv := newConverter(cfg, log.NewNopLogger(), recorderMock)
+
+		b := newSeriesBatcher("collectd-tenant", 0, conv, remoteWriteMock, recorderMock, log.NewNopLogger())
+		ts, err := conv.collectdValueListToTimeseries(&api.ValueList{
+			Identifier: api.Identifier{Host: "web01", Plugin: "memory", Type: "memory"},
+			Time:       now,
+			Values:     []api.Value{api.Gauge(math.Inf(1))},
+		}, now)
+		require.NoError(t, err)
+		b.add(ts)
+		b.flush()
+
+		remoteWriteMock.AssertExpectations(t)
+		recorderMock.AssertExpectations(t)
+	})
+}
+
+func TestValidateSamplesConfig(t *testing.T) {
+	assert.NoError(t, ConversionConfig{Samples: SamplesConfig{OutOfWindow: TimestampsClamp, NaN: ValuesZero, Inf: ValuesClamp}}.Validate())
+	assert.Error(t, ConversionConfig{Samples: SamplesConfig{OutOfWindow: "keep"}}.Validate())
+	assert.Error(t, ConversionConfig{Samples: SamplesConfig{NaN: ValuesClamp}}.Validate())
+	assert.Error(t, ConversionConfig{Samples: SamplesConfig{Inf: "nan"}}.Validate())
+}
@@ -0,0 +1,380 @@
+package influx
+
+import (
+	"context"
+	"crypto/tls"
+	"crypto/x509"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"net"
+	"os"
+	"sync"
+	"time"
+
+	"github.com/go-kit/log"
+	"github.com/go-kit/log/level"
+	"github.com/grafana/dskit/backoff"
+	"github.com/grafana/dskit/services"
+	"github.com/grafana/mimir-graphite/v2/pkg/remotewrite"
+	"github.com/grafana/mimir/pkg/mimirpb"
+)
+
+const (
+	tcpListenerName  = "tcp"
+	unixListenerName = "unix"
+)
+
+// StreamConfig configures the optional listeners reading newline-delimited
+// line protocol from long-lived TCP or Unix domain socket connections, such as
+// the ones of the Telegraf socket_writer output.
+type StreamConfig struct {
+	// TCPListenAddress is the host:port the TCP listener binds to. Empty
+	// disables the listener.
+	TCPListenAddress string
+	// UnixSocketPath is the path of the socket the Unix listener binds to.
+	// Empty disables the listener.
+	UnixSocketPath string
+	// Tenant is the tenant (X-Scope-OrgID) of the points received.
+	Tenant string
+	// Precision of the timestamps in the streams.
+	Precision string
+	// BatchSize is the number of series written per remote write request.
+	BatchSize int
+	// BatchTimeout is the longest time series wait before being written.
+	BatchTimeout time.Duration
+	// ReadTimeout closes connections on which nothing was received for that
+	// long. 0 means no timeout.
+	ReadTimeout time.Duration
+	// MaxLineLength drops longer lines. 0 means no limit.
+	MaxLineLength int
+	// DrainTimeout is how long open connections are given to finish when the
+	// listeners stop, before they are closed.
+	DrainTimeout time.Duration
+	// TLSCertFile and TLSKeyFile enable TLS on the TCP listener.
+	TLSCertFile string
+	TLSKeyFile  string
+	// TLSClientCAFile makes the TCP listener require client certificates
+	// signed by one of the CAs in the file.
+	TLSClientCAFile string
+}
+
+func (c *StreamConfig) RegisterFlags(flags *flag.FlagSet) {
+	flags.StringVar(&c.TCPListenAddress, "stream.tcp-listen-address", "", "host:port of the TCP line protocol listener; empty to disable it")
+	flags.StringVar(&c.UnixSocketPath, "stream.unix-socket-path", "", "path of the Unix socket line protocol listener; empty to disable it")
+	flags.StringVar(&c.Tenant, "stream.tenant", "fake", "tenant of the points received by the TCP and Unix socket listeners")
+	flags.StringVar(&c.Precision, "stream.precision", "ns", "precision of the timestamps received by the TCP and Unix socket listeners")
+	flags.IntVar(&c.BatchSize, "stream.batch-size", 5000, "number of series written per request by the TCP and Unix socket listeners")
+	flags.DurationVar(&c.BatchTimeout, "stream.batch-timeout", time.Second, "how long the TCP and Unix socket listeners wait before writing an incomplete batch")
+	flags.DurationVar(&c.ReadTimeout, "stream.read-timeout", 5*time.Minute, "close TCP and Unix socket connections idle for that long; 0 for no timeout")
+	flags.IntVar(&c.MaxLineLength, "stream.max-line-length", 64<<10, "drop longer lines received by the TCP and Unix socket listeners; 0 for no limit")
+	flags.DurationVar(&c.DrainTimeout, "stream.drain-timeout", 5*time.Second, "how long open TCP and Unix socket connections are given to finish on shutdown")
+	flags.StringVar(&c.TLSCertFile, "stream.tls-cert-file", "", "certificate file enabling TLS on the TCP listener")
+	flags.StringVar(&c.TLSKeyFile, "stream.tls-key-file", "", "key file of the TCP listener certificate")
+	flags.StringVar(&c.TLSClientCAFile, "stream.tls-client-ca-file", "", "CA file used to verify the client certificates required by the TCP listener")
+}
+
+// Validate checks the configuration is usable.
+func (c StreamConfig) Validate() error {
+	if c.TCPListenAddress == "" && c.UnixSocketPath == "" {
+		return nil
+	}
+	if c.Tenant == "" {
+		return errors.New("tenant is required")
+	}
+	if !validPrecision(c.Precision) {
+		return fmt.Errorf("invalid precision %q", c.Precision)
+	}
+	if c.BatchSize <= 0 || c.BatchTimeout <= 0 {
+		return errors.New("batch size and timeout must be positive")
+	}
+	if (c.TLSCertFile == "") != (c.TLSKeyFile == "") {
+		return errors.New("TLS certificate and key files must be set together")
+	}
+	if c.TLSClientCAFile != "" && c.TLSCertFile == "" {
+		return errors.New("TLS client CA file requires a TLS certificate")
+	}
+	return nil
+}
+
+// tlsConfig builds the TLS configuration of the TCP listener, or nil if TLS
+// is not enabled.
+func (c StreamConfig) tlsConfig() (*tls.Config, error) {
+	if c.TLSCertFile == "" {
+		return nil, nil
+	}
+	cert, err := tls.LoadX509KeyPair(c.TLSCertFile, c.TLSKeyFile)
+	if err != nil {
+		return nil, fmt.Errorf("failed to load TLS certificate: %w", err)
+	}
+	conf := &tls.Config{
+		Certificates: []tls.Certificate{cert},
+		MinVersion:   tls.VersionTLS12,
+	}
+	if c.TLSClientCAFile != "" {
+		pem, err := os.ReadFile(c.TLSClientCAFile)
+		if err != nil {
+			return nil, fmt.Errorf("failed to read TLS client CA file: %w", err)
+		}
+		pool := x509.NewCertPool()
+		if !pool.AppendCertsFromPEM(pem) {
+			return nil, errors.New("no certificate found in TLS client CA file")
+		}
+		conf.ClientCAs = pool
+		conf.ClientAuth = tls.RequireAndVerifyClientCert
+	}
+	return conf, nil
+}
+
+// newStreamListeners creates the enabled stream listeners.
+func newStreamListeners(cfg StreamConfig, convs *converters, logger log.Logger, client remotewrite.Client, recorder Recorder) ([]services.Service, error) {
+	var listeners []services.Service
+	if cfg.TCPListenAddress != "" {
+		tlsConfig, err := cfg.tlsConfig()
+		if err != nil {
+			return nil, err
+		}
+		conv := convs.get(tcpListenerName, cfg.Tenant)
+		listeners = append(listeners, newStreamListener(tcpListenerName, "tcp", cfg.TCPListenAddress, tlsConfig, cfg, influxLineParser(cfg.Precision), conv, logger, client, recorder))
+	}
+	if cfg.UnixSocketPath != "" {
+		conv := convs.get(unixListenerName, cfg.Tenant)
+		listeners = append(listeners, newStreamListener(unixListenerName, "unix", cfg.UnixSocketPath, nil, cfg, influxLineParser(cfg.Precision), conv, logger, client, recorder))
+	}
+	return listeners, nil
+}
+
+// lineParser converts a line received by a stream listener into series with the
+// converter of the listener, checking it against the per-request limits of the
+// lines of its connection. Lines beyond the limits are dropped.
+type lineParser func(conv *converter, limits *requestLimits, line []byte) ([]mimirpb.TimeSeries, error)
+
+// influxLineParser parses line protocol with timestamps of the given precision.
+func influxLineParser(precision string) lineParser {
+	return func(_ *converter, limits *requestLimits, line []byte) ([]mimirpb.TimeSeries, error) {
+		points, err := parsePointsWithPrecision(line, time.Now().UTC(), precision)
+		if err != nil {
+			return nil, err
+		}
+		return limits.convert(points, nil)
+	}
+}
+
+// streamListener is a dskit service reading newline-delimited data from stream
+// connections. All the connections of a listener share its batches.
+type streamListener struct {
+	services.Service
+
+	name      string
+	network   string
+	address   string
+	tlsConfig *tls.Config
+	cfg       StreamConfig
+	parse     lineParser
+	converter *converter
+	logger    log.Logger
+	recorder  Recorder
+	batcher   *seriesBatcher
+
+	listener net.Listener
+	wg       sync.WaitGroup
+	mtx      sync.Mutex
+	conns    map[net.Conn]struct{}
+}
+
+// newStreamListener creates a listener parsing the lines it receives with
+// parse, converting them with conv.
+func newStreamListener(name, network, address string, tlsConfig *tls.Config, cfg StreamConfig, parse lineParser, conv *converter, logger log.Logger, client remotewrite.Client, recorder Recorder) *streamListener {
+	logger = log.With(logger, "listener", name)
+	l := &streamListener{
+		name:      name,
+		network:   network,
+		address:   address,
+		tlsConfig: tlsConfig,
+		cfg:       cfg,
+		parse:     parse,
+		converter: conv,
+		logger:    logger,
+		recorder:  recorder,
+		batcher:   newSeriesBatcher(cfg.Tenant, cfg.BatchSize, conv, client, recorder, logger),
+		conns:     map[net.Conn]struct{}{},
+	}
+	l.Service = services.NewBasicService(l.start, l.run, l.stop).WithName(name + "-listener")
+	return l
+}
+
+// Addr returns the address the listener is bound to.
+func (l *streamListener) Addr() net.Addr {
+	return l.listener.Addr()
+}
+
+func (l *streamListener) start(_ context.Context) error {
+	if l.network == "unix" {
+		// Remove the socket left behind by a previous run, but nothing else.
+		if fi, err := os.Stat(l.address); err == nil && fi.Mode()&os.ModeSocket != 0 {
+			if err := os.Remove(l.address); err != nil {
+				return fmt.Errorf("failed to remove stale socket: %w", err)
+			}
+		}
+	}
+
+	var err error
+	l.listener, err = net.Listen(l.network, l.address)
+	if err != nil {
+		return fmt.Errorf("failed to listen on %s: %w", l.network, err)
+	}
+	if l.tlsConfig != nil {
+		l.listener = tls.NewListener(l.listener, l.tlsConfig)
+	}
+	_ = level.Info(l.logger).Log("msg", "Starting stream listener", "addr", l.listener.Addr(), "tls", l.tlsConfig != nil)
+
+	// The accept loop is tracked so that connections are never added to the
+	// wait group while stop waits on it.
+	l.wg.Add(1)
+	go l.accept()
+	return nil
+}
+
+func (l *streamListener) accept() {
+	defer l.wg.Done()
+	retries := backoff.New(context.Background(), readBackoff)
+	for {
+		conn, err := l.listener.Accept()
+		if err != nil {
+			if errors.Is(err, net.ErrClosed) {
+				return
+			}
+			// Only the first error of a series of failed accepts is logged.
+			l.recorder.measureReadErrors(l.name)
+			if retries.NumRetries() == 0 {
+				_ = level.Warn(l.logger).Log("msg", "failed to accept connection", "err", err)
+			}
+			retries.Wait()
+			continue
+		}
+		retries.Reset()
+
+		l.mtx.Lock()
+		l.conns[conn] = struct{}{}
+		l.wg.Add(1)
+		l.mtx.Unlock()
+
+		go l.handle(conn)
+	}
+}
+
+func (l *streamListener) run(ctx context.Context) error {
+	ticker := time.NewTicker(l.cfg.BatchTimeout)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return nil
+		case <-ticker.C:
+			l.batcher.flush()
+		}
+	}
+}
+
+// handle reads lines from conn until the client closes it, it becomes idle
+// for longer than the read timeout, or the listener stops. The per-request
+// limits apply to the lines of conn received within each batch timeout, as a
+// connection has no requests.
+func (l *streamListener) handle(conn net.Conn) {
+	defer l.wg.Done()
+	defer func() {
+		l.mtx.Lock()
+		delete(l.conns, conn)
+		l.mtx.Unlock()
+		_ = conn.Close()
+	}()
+	logger := log.With(l.logger, "remote", conn.RemoteAddr())
+
+	lr := newLineReader(deadlineReader{conn: conn, timeout: l.cfg.ReadTimeout}, l.cfg.MaxLineLength)
+	limits, limitsStart := l.converter.newRequestLimits(l.cfg.Tenant), time.Now()
+	for {
+		line, err := lr.next()
+		switch {
+		case errors.Is(err, io.EOF):
+			return
+		case errors.Is(err, errLineTooLong):
+			l.recorder.measureMessagesReceived(l.name)
+			_ = level.Debug(logger).Log("msg", "dropped line", "line", lr.lineNum, "err", err)
+			l.recorder.measureMessagesDropped(l.name, "line_too_long")
+			continue
+		case isNetworkTimeout(err):
+			_ = level.Debug(logger).Log("msg", "closing idle connection")
+			return
+		case err != nil:
+			if !errors.Is(err, net.ErrClosed) {
+				_ = level.Warn(logger).Log("msg", "failed to read from connection", "err", err)
+			}
+			return
+		}
+		l.recorder.measureMessagesReceived(l.name)
+		if isBlankOrComment(line) {
+			continue
+		}
+
+		beforeConversion := time.Now()
+		if beforeConversion.Sub(limitsStart) >= l.cfg.BatchTimeout {
+			limits, limitsStart = l.converter.newRequestLimits(l.cfg.Tenant), beforeConversion
+		}
+		ts, err := l.parse(l.converter, limits, line)
+		if err != nil {
+			_ = level.Debug(logger).Log("msg", "dropped line", "line", lr.lineNum, "err", err)
+			l.recorder.measureMessagesDropped(l.name, lineErrorReason(err))
+			continue
+		}
+		l.recorder.measureMetricsParsed(len(ts))
+		l.recorder.measureConversionDuration(time.Since(beforeConversion))
+		l.batcher.add(ts)
+	}
+}
+
+// stop stops accepting connections and gives the open ones the drain timeout
+// to finish before closing them. What was read is written out in any case.
+func (l *streamListener) stop(_ error) error {
+	err := l.listener.Close()
+
+	drained := make(chan struct{})
+	go func() {
+		l.wg.Wait()
+		close(drained)
+	}()
+	select {
+	case <-drained:
+	case <-time.After(l.cfg.DrainTimeout):
+		l.mtx.Lock()
+		_ = level.Info(l.logger).Log("msg", "closing connections still open after drain timeout", "connections", len(l.conns))
+		for conn := range l.conns {
+			_ = conn.Close()
+		}
+		l.mtx.Unlock()
+		<-drained
+	}
+
+	l.batcher.flush()
+	if errors.Is(err, net.ErrClosed) {
+		return nil
+	}
+	return err
+}
+
+// deadlineReader extends the read deadline of a connection before every read,
+// so that only idle connections time out.
+type deadlineReader struct {
+	conn    net.Conn
+	timeout time.Duration
+}
+
+func (r deadlineReader) Read(p []byte) (int, error) {
+	if r.timeout > 0 {
+		if err := r.conn.SetReadDeadline(time.Now().Add(r.timeout)); err != nil {
+			return 0, err
+		}
+	}
+	return r.conn.Read(p)
+}
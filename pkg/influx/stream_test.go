@@ -0,0 +1,305 @@
+package influx
+
+import (
+	"context"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"errors"
+	"math/big"
+	"net"
+	"os"
+	"path/filepath"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/go-kit/log"
+	"github.com/grafana/dskit/services"
+	"github.com/grafana/dskit/user"
+	"github.com/grafana/mimir-graphite/v2/pkg/remotewrite/remotewritemock"
+	"github.com/grafana/mimir/pkg/mimirpb"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/mock"
+	"github.com/stretchr/testify/require"
+)
+
+func TestStreamListener(t *testing.T) {
+	tests := []struct {
+		name    string
+		network string
+		address func(t *testing.T) string
+	}{
+		{
+			name:    "tcp",
+			network: "tcp",
+			address: func(*testing.T) string { return "127.0.0.1:0" },
+		},
+		{
+			name:    "unix",
+			network: "unix",
+			address: func(t *testing.T) string { return filepath.Join(t.TempDir(), "influx.sock") },
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			remoteWriteMock, written := newStreamWriteMock(t)
+			recorderMock := newStreamRecorderMock(tt.name)
+			recorderMock.On("measureMessagesDropped", tt.name, "parse_error").Return(nil).Once()
+			recorderMock.On("measureMessagesDropped", tt.name, "line_too_long").Return(nil).Once()
+
+			cfg := testStreamConfig()
+			l := newStreamListener(tt.name, tt.network, tt.address(t), nil, cfg, influxLineParser(cfg.Precision), newConverter(ConversionConfig{}, log.NewNopLogger(), recorderMock), log.NewNopLogger(), remoteWriteMock, recorderMock)
+			require.NoError(t, services.StartAndAwaitRunning(context.Background(), l))
+
+			conn, err := net.Dial(tt.network, l.Addr().String())
+			require.NoError(t, err)
+			// The first two series fill a batch, the third one is written on
+			// stop. The invalid and too long lines are dropped.
+			_, err = conn.Write([]byte("m,t=a f=1 1465839830\nm,t=b f=2 1465839830\n# comment\nm,t=c f= 1465839830\n"))
+			require.NoError(t, err)
+			_, err = conn.Write([]byte("m,t=c f=\"a very long string\" 1465839830\nm,t=c f=3 1465839830\n"))
+			require.NoError(t, err)
+			require.NoError(t, conn.Close())
+
+			require.Eventually(t, func() bool { return len(written()) == 2 }, 5*time.Second, 10*time.Millisecond)
+			require.NoError(t, services.StopAndAwaitTerminated(context.Background(), l))
+
+			ts := written()
+			require.Len(t, ts, 3)
+			assert.Equal(t, []mimirpb.LabelAdapter{
+				{Name: "__name__", Value: "m_f"},
+				{Name: "__proxy_source__", Value: "influx"},
+				{Name: "t", Value: "c"},
+			}, ts[2].Labels)
+			assert.Equal(t, []mimirpb.Sample{{Value: 3, TimestampMs: 1465839830000}}, ts[2].Samples)
+			recorderMock.AssertExpectations(t)
+		})
+	}
+}
+
+func TestStreamListenerDrain(t *testing.T) {
+	remoteWriteMock, written := newStreamWriteMock(t)
+	recorderMock := &MockRecorder{}
+	recorderMock.On("measureMessagesReceived", "tcp").Return(nil)
+	parsed := make(chan struct{})
+	recorderMock.On("measureMetricsParsed", 1).Return(nil).Once().Run(func(mock.Arguments) {
+		close(parsed)
+	})
+	recorderMock.On("measureMetricsWritten", 1).Return(nil)
+	recorderMock.On("measureConversionDuration", mock.Anything).Return(nil)
+
+	cfg := testStreamConfig()
+	cfg.DrainTimeout = 50 * time.Millisecond
+	l := newStreamListener("tcp", "tcp", "127.0.0.1:0", nil, cfg, influxLineParser(cfg.Precision), newConverter(ConversionConfig{}, log.NewNopLogger(), recorderMock), log.NewNopLogger(), remoteWriteMock, recorderMock)
+	require.NoError(t, services.StartAndAwaitRunning(context.Background(), l))
+
+	// The connection stays open, so it is closed after the drain timeout and
+	// the line received is written.
+	conn, err := net.Dial("tcp", l.Addr().String())
+	require.NoError(t, err)
+	defer conn.Close()
+	_, err = conn.Write([]byte("m,t=a f=1 1465839830\n"))
+	require.NoError(t, err)
+	select {
+	case <-parsed:
+	case <-time.After(5 * time.Second):
+		t.Fatal("line not parsed")
+	}
+
+	require.NoError(t, services.StopAndAwaitTerminated(context.Background(), l))
+	require.Len(t, written(), 1)
+
+	_, err = conn.Read(make([]byte, 1))
+	assert.Error(t, err)
+}
+
+func TestStreamListenerReadTimeout(t *testing.T) {
+	remoteWriteMock, _ := newStreamWriteMock(t)
+	recorderMock := newStreamRecorderMock("tcp")
+
+	cfg := testStreamConfig()
+	cfg.ReadTimeout = 50 * time.Millisecond
+	l := newStreamListener("tcp", "tcp", "127.0.0.1:0", nil, cfg, influxLineParser(cfg.Precision), newConverter(ConversionConfig{}, log.NewNopLogger(), recorderMock), log.NewNopLogger(), remoteWriteMock, recorderMock)
+	require.NoError(t, services.StartAndAwaitRunning(context.Background(), l))
+	defer func() {
+		require.NoError(t, services.StopAndAwaitTerminated(context.Background(), l))
+	}()
+
+	conn, err := net.Dial("tcp", l.Addr().String())
+	require.NoError(t, err)
+	defer conn.Close()
+
+	// The idle connection is closed by the listener.
+	require.NoError(t, conn.SetReadDeadline(time.Now().Add(5*time.Second)))
+	_, err = conn.Read(make([]byte, 1))
+	require.Error(t, err)
+	var netErr net.Error
+	assert.False(t, errors.As(err, &netErr) && netErr.Timeout(), "connection should have been closed: %v", err)
+}
+
+func TestStreamListenerTLS(t *testing.T) {
+	dir := t.TempDir()
+	ca, caKey := newTestCertificate(t, nil, nil, "ca")
+	server, serverKey := newTestCertificate(t, ca, caKey, "server")
+	client, clientKey := newTestCertificate(t, ca, caKey, "client")
+
+	cfg := testStreamConfig()
+	cfg.TCPListenAddress = "127.0.0.1:0"
+	cfg.TLSCertFile = writePEM(t, dir, "server.crt", "CERTIFICATE", server.Raw)
+	cfg.TLSKeyFile = writePEM(t, dir, "server.key", "PRIVATE KEY", marshalKey(t, serverKey))
+	cfg.TLSClientCAFile = writePEM(t, dir, "ca.crt", "CERTIFICATE", ca.Raw)
+	require.NoError(t, cfg.Validate())
+
+	remoteWriteMock, written := newStreamWriteMock(t)
+	recorderMock := newStreamRecorderMock("tcp")
+	convs, err := newConverters(ConversionConfig{}, Overrides{}, log.NewNopLogger(), recorderMock)
+	require.NoError(t, err)
+	listeners, err := newStreamListeners(cfg, convs, log.NewNopLogger(), remoteWriteMock, recorderMock)
+	require.NoError(t, err)
+	require.Len(t, listeners, 1)
+	l := listeners[0].(*streamListener)
+	require.NoError(t, services.StartAndAwaitRunning(context.Background(), l))
+
+	roots := x509.NewCertPool()
+	roots.AddCert(ca)
+
+	// Without a client certificate, the handshake fails.
+	conn, err := tls.Dial("tcp", l.Addr().String(), &tls.Config{RootCAs: roots, ServerName: "server"})
+	if err == nil {
+		_, err = conn.Write([]byte("m,t=z f=1 1465839830\n"))
+		if err == nil {
+			_, err = conn.Read(make([]byte, 1))
+		}
+		_ = conn.Close()
+	}
+	require.Error(t, err)
+
+	conn, err = tls.Dial("tcp", l.Addr().String(), &tls.Config{
+		RootCAs:    roots,
+		ServerName: "server",
+		Certificates: []tls.Certificate{{
+			Certificate: [][]byte{client.Raw},
+			PrivateKey:  clientKey,
+		}},
+	})
+	require.NoError(t, err)
+	_, err = conn.Write([]byte("m,t=a f=1 1465839830\n"))
+	require.NoError(t, err)
+	require.NoError(t, conn.Close())
+
+	require.NoError(t, services.StopAndAwaitTerminated(context.Background(), l))
+	ts := written()
+	require.Len(t, ts, 1)
+	assert.Equal(t, "a", ts[0].Labels[2].Value)
+}
+
+func TestStreamConfigValidate(t *testing.T) {
+	valid := testStreamConfig()
+	valid.TCPListenAddress = ":8094"
+	assert.NoError(t, valid.Validate())
+	assert.NoError(t, StreamConfig{}.Validate())
+
+	cfg := valid
+	cfg.Tenant = ""
+	assert.Error(t, cfg.Validate())
+
+	cfg = valid
+	cfg.Precision = "ss"
+	assert.Error(t, cfg.Validate())
+
+	cfg = valid
+	cfg.BatchSize = 0
+	assert.Error(t, cfg.Validate())
+
+	cfg = valid
+	cfg.TLSCertFile = "server.crt"
+	assert.Error(t, cfg.Validate())
+
+	cfg = valid
+	cfg.TLSClientCAFile = "ca.crt"
+	assert.Error(t, cfg.Validate())
+}
+
+func testStreamConfig() StreamConfig {
+	return StreamConfig{
+		Tenant:        "stream-tenant",
+		Precision:     "s",
+		BatchSize:     2,
+		BatchTimeout:  time.Hour,
+		MaxLineLength: 32,
+		DrainTimeout:  5 * time.Second,
+	}
+}
+
+func newStreamWriteMock(t *testing.T) (*remotewritemock.Client, func() []mimirpb.PreallocTimeseries) {
+	var mtx sync.Mutex
+	var written []mimirpb.PreallocTimeseries
+
+	remoteWriteMock := &remotewritemock.Client{}
+	remoteWriteMock.On("Write", mock.Anything, mock.Anything).Return(nil).Run(func(args mock.Arguments) {
+		orgID, err := user.ExtractOrgID(args.Get(0).(context.Context))
+		require.NoError(t, err)
+		require.Equal(t, "stream-tenant", orgID)
+
+		mtx.Lock()
+		defer mtx.Unlock()
+		written = append(written, args.Get(1).(*mimirpb.WriteRequest).Timeseries...)
+	})
+	return remoteWriteMock, func() []mimirpb.PreallocTimeseries {
+		mtx.Lock()
+		defer mtx.Unlock()
+		return append([]mimirpb.PreallocTimeseries(nil), written...)
+	}
+}
+
+func newStreamRecorderMock(listener string) *MockRecorder {
+	recorderMock := &MockRecorder{}
+	recorderMock.On("measureMessagesReceived", listener).Return(nil)
+	recorderMock.On("measureMetricsParsed", mock.Anything).Return(nil)
+	recorderMock.On("measureMetricsWritten", mock.Anything).Return(nil)
+	recorderMock.On("measureConversionDuration", mock.Anything).Return(nil)
+	return recorderMock
+}
+
+func newTestCertificate(t *testing.T, parent *x509.Certificate, parentKey *ecdsa.PrivateKey, name string) (*x509.Certificate, *ecdsa.PrivateKey) {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	require.NoError(t, err)
+
+	template := &x509.Certificate{
+		SerialNumber: big.NewInt(time.Now().UnixNano()),
+		Subject:      pkix.Name{CommonName: name},
+		DNSNames:     []string{name},
+		NotBefore:    time.Now().Add(-time.Hour),
+		NotAfter:     time.Now().Add(time.Hour),
+		KeyUsage:     x509.KeyUsageDigitalSignature | x509.KeyUsageCertSign,
+		ExtKeyUsage:  []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth, x509.ExtKeyUsageClientAuth},
+	}
+	if parent == nil {
+		template.IsCA = true
+		template.BasicConstraintsValid = true
+		parent, parentKey = template, key
+	}
+	der, err := x509.CreateCertificate(rand.Reader, template, parent, &key.PublicKey, parentKey)
+	require.NoError(t, err)
+	cert, err := x509.ParseCertificate(der)
+	require.NoError(t, err)
+	return cert, key
+}
+
+func marshalKey(t *testing.T, key *ecdsa.PrivateKey) []byte {
+	der, err := x509.MarshalPKCS8PrivateKey(key)
+	require.NoError(t, err)
+	return der
+}
+
+func writePEM(t *testing.T, dir, name, blockType string, der []byte) string {
+	path := filepath.Join(dir, name)
+	require.NoError(t, os.WriteFile(path, pem.EncodeToMemory(&pem.Block{Type: blockType, Bytes: der}), 0o600))
+	return path
+}
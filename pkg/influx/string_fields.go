@@ -0,0 +1,301 @@
+package influx
+
+import (
+	"container/list"
+	"errors"
+	"flag"
+	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/grafana/dskit/flagext"
+	"github.com/grafana/mimir/pkg/mimirpb"
+)
+
+const (
+	// maxTrackedStringFields bounds the memory used to guard the cardinality of
+	// string fields for each tenant: values of the fields of series beyond it
+	// are dropped.
+	maxTrackedStringFields = 100000
+
+	// Reasons for dropping a string field.
+	stringDroppedUnhandled    = "unhandled"
+	stringDroppedTooLong      = "too_long"
+	stringDroppedCardinality  = "cardinality"
+	stringDroppedUnknownValue = "unknown_value"
+)
+
+// StringFieldsConfig configures what is done with string fields, which have no
+// Prometheus equivalent. By default they are dropped. For each string field, an
+// enum mapping takes precedence over turning the field into a label, which
+// takes precedence over an info series.
+type StringFieldsConfig struct {
+	// Info converts string fields to a series named after the field with an
+	// _info suffix, a value of 1 and the string as a label named after the
+	// field.
+	Info bool `yaml:"info"`
+	// Labels are the string fields added as labels to the numeric series of
+	// the same point.
+	Labels flagext.StringSliceCSV `yaml:"labels"`
+	// Enums map the values of string fields to numbers, written as a series
+	// named after the field like numeric fields are.
+	Enums EnumMappings `yaml:"enums"`
+	// MaxValueLength drops the strings longer than it from info series and
+	// labels. Any value less than or equal to 0 means no limit.
+	MaxValueLength int `yaml:"max_value_length"`
+	// MaxValuesPerField drops the values of a field of a series once it had
+	// that many distinct values in info series or labels, for each tenant. Any
+	// value less than or equal to 0 means no limit.
+	MaxValuesPerField int `yaml:"max_values_per_field"`
+	// ValuesTTL is how long the values of a field are remembered for
+	// MaxValuesPerField since they were last seen. Any value less than or
+	// equal to 0 means forever.
+	ValuesTTL time.Duration `yaml:"values_ttl"`
+}
+
+func (c *StringFieldsConfig) RegisterFlags(flags *flag.FlagSet) {
+	flags.BoolVar(&c.Info, "string.fields.info", false, "convert string fields to _info series with the string as a label")
+	flags.Var(&c.Labels, "string.fields.labels", "comma-separated string fields added as labels to the numeric series of the same point")
+	flags.Var(&c.Enums, "string.fields.enum", "map the values of a string field to numbers, as field:value=number,value=number; can be repeated")
+	flags.IntVar(&c.MaxValueLength, "string.fields.max.value.length", 256, "drop string field values longer than this from info series and labels; 0 for no limit")
+	flags.IntVar(&c.MaxValuesPerField, "string.fields.max.values", 100, "drop string field values once a field of a series had this many distinct values in info series and labels; 0 for no limit")
+	flags.DurationVar(&c.ValuesTTL, "string.fields.values.ttl", time.Hour, "how long string field values count towards -string.fields.max.values since they were last seen; 0 to never forget them")
+}
+
+// Validate checks the configuration is usable.
+func (c StringFieldsConfig) Validate() error {
+	for _, field := range c.Labels {
+		if field == "" {
+			return errors.New("empty string field label")
+		}
+	}
+	return nil
+}
+
+// EnumMappings maps string field names to the numbers of their values. It is
+// a flag.Value set by repeated field:value=number,value=number flags.
+type EnumMappings map[string]map[string]float64
+
+func (e EnumMappings) String() string {
+	fields := make([]string, 0, len(e))
+	for field := range e {
+		fields = append(fields, field)
+	}
+	sort.Strings(fields)
+
+	var sb strings.Builder
+	for i, field := range fields {
+		if i > 0 {
+			sb.WriteString(" ")
+		}
+		values := make([]string, 0, len(e[field]))
+		for v, n := range e[field] {
+			values = append(values, v+"="+strconv.FormatFloat(n, 'g', -1, 64))
+		}
+		sort.Strings(values)
+		sb.WriteString(field + ":" + strings.Join(values, ","))
+	}
+	return sb.String()
+}
+
+func (e *EnumMappings) Set(s string) error {
+	field, values, ok := strings.Cut(s, ":")
+	if !ok || field == "" || values == "" {
+		return fmt.Errorf("invalid enum mapping %q (use field:value=number,value=number)", s)
+	}
+	mapping := map[string]float64{}
+	for _, v := range strings.Split(values, ",") {
+		value, number, ok := strings.Cut(v, "=")
+		if !ok {
+			return fmt.Errorf("invalid enum value %q (use value=number)", v)
+		}
+		n, err := strconv.ParseFloat(number, 64)
+		if err != nil {
+			return fmt.Errorf("invalid enum number %q: %w", number, err)
+		}
+		mapping[value] = n
+	}
+	if *e == nil {
+		*e = EnumMappings{}
+	}
+	(*e)[field] = mapping
+	return nil
+}
+
+// stringFieldsConverter applies a StringFieldsConfig. It is safe for
+// concurrent use.
+type stringFieldsConverter struct {
+	cfg      StringFieldsConfig
+	labels   map[string]bool
+	recorder Recorder
+	// maxTracked is the number of fields of series tracked for each tenant.
+	maxTracked int
+
+	// tenants are the values of the string fields of each tenant, to guard
+	// their cardinality.
+	mu      sync.Mutex
+	tenants map[string]*stringFieldValues
+}
+
+// stringFieldValues tracks the values of the string fields of the series of a
+// tenant.
+type stringFieldValues struct {
+	mu     sync.Mutex
+	fields map[string]*list.Element
+	// order holds the *trackedStringField of fields from the most to the least
+	// recently seen, so that the expired ones are found at its back.
+	order *list.List
+}
+
+// trackedStringField is a field of a series and the times its distinct values
+// were last seen.
+type trackedStringField struct {
+	key      string
+	lastSeen time.Time
+	values   map[string]time.Time
+}
+
+func newStringFieldsConverter(cfg StringFieldsConfig, recorder Recorder) *stringFieldsConverter {
+	c := &stringFieldsConverter{
+		cfg:        cfg,
+		labels:     make(map[string]bool, len(cfg.Labels)),
+		recorder:   recorder,
+		maxTracked: maxTrackedStringFields,
+		tenants:    map[string]*stringFieldValues{},
+	}
+	for _, field := range cfg.Labels {
+		c.labels[field] = true
+	}
+	return c
+}
+
+// enum returns the number of the value of an enum field. isEnum is false if
+// field isn't an enum, in which case the value must be handled otherwise, and
+// dropped is true if the value isn't one of the enum.
+func (c *stringFieldsConverter) enum(field, value string) (n float64, isEnum, dropped bool) {
+	mapping, isEnum := c.cfg.Enums[field]
+	if !isEnum {
+		return 0, false, false
+	}
+	n, known := mapping[value]
+	if !known {
+		c.drop(stringDroppedUnknownValue)
+		return 0, true, true
+	}
+	return n, true, false
+}
+
+// isLabel reports whether field is added as a label to its sibling series.
+func (c *stringFieldsConverter) isLabel(field string) bool {
+	return c.labels[field]
+}
+
+// allow applies the cardinality guards to the value of the field of the series
+// of the named metric with the labels of tags, written for tenant at now,
+// counting the values it drops. The converter of an endpoint may be shared by
+// tenants, which don't share their guards.
+func (c *stringFieldsConverter) allow(tenant, metric string, tags []mimirpb.LabelAdapter, field, value string, now time.Time) bool {
+	if c.cfg.MaxValueLength > 0 && len(value) > c.cfg.MaxValueLength {
+		c.drop(stringDroppedTooLong)
+		return false
+	}
+	if c.cfg.MaxValuesPerField <= 0 {
+		return true
+	}
+
+	var sb strings.Builder
+	sb.WriteString(metric + "\xff")
+	writeSeriesKey(&sb, tags)
+	sb.WriteString(field)
+	key := sb.String()
+
+	t := c.tenantValues(tenant)
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	elem, ok := t.fields[key]
+	if !ok {
+		c.expire(t, now)
+		if len(t.fields) >= c.maxTracked {
+			c.drop(stringDroppedCardinality)
+			return false
+		}
+		elem = t.order.PushFront(&trackedStringField{key: key, values: map[string]time.Time{}})
+		t.fields[key] = elem
+	}
+	tracked := elem.Value.(*trackedStringField)
+	if _, ok := tracked.values[value]; !ok && len(tracked.values) >= c.cfg.MaxValuesPerField {
+		c.expireValues(tracked.values, now)
+		if len(tracked.values) >= c.cfg.MaxValuesPerField {
+			c.drop(stringDroppedCardinality)
+			return false
+		}
+	}
+	tracked.values[value] = now
+	tracked.lastSeen = now
+	t.order.MoveToFront(elem)
+	return true
+}
+
+// tenantValues returns the values tracked for tenant.
+func (c *stringFieldsConverter) tenantValues(tenant string) *stringFieldValues {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	t, ok := c.tenants[tenant]
+	if !ok {
+		t = &stringFieldValues{fields: map[string]*list.Element{}, order: list.New()}
+		c.tenants[tenant] = t
+	}
+	return t
+}
+
+// expire forgets the fields of t whose values were all last seen more than the
+// values TTL ago, starting from the least recently seen, so that it only
+// visits the fields it forgets.
+func (c *stringFieldsConverter) expire(t *stringFieldValues, now time.Time) {
+	if c.cfg.ValuesTTL <= 0 {
+		return
+	}
+	for elem := t.order.Back(); elem != nil; elem = t.order.Back() {
+		tracked := elem.Value.(*trackedStringField)
+		if now.Sub(tracked.lastSeen) < c.cfg.ValuesTTL {
+			return
+		}
+		t.order.Remove(elem)
+		delete(t.fields, tracked.key)
+	}
+}
+
+// expireValues forgets the values of a field of a series not seen for the
+// values TTL.
+func (c *stringFieldsConverter) expireValues(values map[string]time.Time, now time.Time) {
+	if c.cfg.ValuesTTL <= 0 {
+		return
+	}
+	for value, last := range values {
+		if now.Sub(last) >= c.cfg.ValuesTTL {
+			delete(values, value)
+		}
+	}
+}
+
+// infoName returns the name of the _info series of a string field of a point
+// with the labels of tags, given the name its series would have if it were
+// numeric, or false if the value is dropped.
+func (c *stringFieldsConverter) infoName(tenant, name string, tags []mimirpb.LabelAdapter, field, value string, now time.Time) (string, bool) {
+	if !c.cfg.Info {
+		c.drop(stringDroppedUnhandled)
+		return "", false
+	}
+	name += "_info"
+	if !c.allow(tenant, name, tags, field, value, now) {
+		return "", false
+	}
+	return name, true
+}
+
+func (c *stringFieldsConverter) drop(reason string) {
+	c.recorder.measureStringFieldsDropped(reason)
+}
@@ -0,0 +1,250 @@
+package influx
+
+import (
+	"io"
+	"sort"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/go-kit/log"
+	"github.com/grafana/mimir/pkg/mimirpb"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/mock"
+	"github.com/stretchr/testify/require"
+)
+
+func TestStringFields(t *testing.T) {
+	tests := []struct {
+		name            string
+		cfg             StringFieldsConfig
+		data            string
+		expected        []mimirpb.TimeSeries
+		expectedDropped map[string]int
+	}{
+		{
+			name: "dropped by default",
+			data: `app,host=a version="1.2.3",uptime=5 1465839830100400200`,
+			expected: []mimirpb.TimeSeries{{
+				Labels: []mimirpb.LabelAdapter{
+					{Name: "__name__", Value: "app_uptime"},
+					{Name: "__proxy_source__", Value: "influx"},
+					{Name: "host", Value: "a"},
+				},
+				Samples: []mimirpb.Sample{{Value: 5, TimestampMs: 1465839830100}},
+			}},
+			expectedDropped: map[string]int{stringDroppedUnhandled: 1},
+		},
+		{
+			name: "info series",
+			cfg:  StringFieldsConfig{Info: true},
+			data: `app,host=a version="1.2.3",value="x" 1465839830100400200`,
+			expected: []mimirpb.TimeSeries{
+				{
+					Labels: []mimirpb.LabelAdapter{
+						{Name: "__name__", Value: "app_info"},
+						{Name: "__proxy_source__", Value: "influx"},
+						{Name: "host", Value: "a"},
+						{Name: "value", Value: "x"},
+					},
+					Samples: []mimirpb.Sample{{Value: 1, TimestampMs: 1465839830100}},
+				},
+				{
+					Labels: []mimirpb.LabelAdapter{
+						{Name: "__name__", Value: "app_version_info"},
+						{Name: "__proxy_source__", Value: "influx"},
+						{Name: "host", Value: "a"},
+						{Name: "version", Value: "1.2.3"},
+					},
+					Samples: []mimirpb.Sample{{Value: 1, TimestampMs: 1465839830100}},
+				},
+			},
+		},
+		{
+			name: "label on sibling series",
+			cfg:  StringFieldsConfig{Labels: []string{"status"}},
+			data: `svc,host=a status="running",restarts=2i,load=0.5 1465839830100400200`,
+			expected: []mimirpb.TimeSeries{
+				{
+					Labels: []mimirpb.LabelAdapter{
+						{Name: "__name__", Value: "svc_load"},
+						{Name: "__proxy_source__", Value: "influx"},
+						{Name: "host", Value: "a"},
+						{Name: "status", Value: "running"},
+					},
+					Samples: []mimirpb.Sample{{Value: 0.5, TimestampMs: 1465839830100}},
+				},
+				{
+					Labels: []mimirpb.LabelAdapter{
+						{Name: "__name__", Value: "svc_restarts"},
+						{Name: "__proxy_source__", Value: "influx"},
+						{Name: "host", Value: "a"},
+						{Name: "status", Value: "running"},
+					},
+					Samples: []mimirpb.Sample{{Value: 2, TimestampMs: 1465839830100}},
+				},
+			},
+		},
+		{
+			name: "label without sibling series falls back to info",
+			cfg:  StringFieldsConfig{Info: true, Labels: []string{"status"}},
+			data: `svc status="running" 1465839830100400200`,
+			expected: []mimirpb.TimeSeries{{
+				Labels: []mimirpb.LabelAdapter{
+					{Name: "__name__", Value: "svc_status_info"},
+					{Name: "__proxy_source__", Value: "influx"},
+					{Name: "status", Value: "running"},
+				},
+				Samples: []mimirpb.Sample{{Value: 1, TimestampMs: 1465839830100}},
+			}},
+		},
+		{
+			name: "enum",
+			cfg:  StringFieldsConfig{Enums: EnumMappings{"state": {"up": 1, "down": 0}}, Labels: []string{"state"}},
+			data: "svc state=\"down\" 1465839830100400200\nsvc state=\"unknown\" 1465839830100400200",
+			expected: []mimirpb.TimeSeries{{
+				Labels: []mimirpb.LabelAdapter{
+					{Name: "__name__", Value: "svc_state"},
+					{Name: "__proxy_source__", Value: "influx"},
+				},
+				Samples: []mimirpb.Sample{{Value: 0, TimestampMs: 1465839830100}},
+			}},
+			expectedDropped: map[string]int{stringDroppedUnknownValue: 1},
+		},
+		{
+			name: "value too long",
+			cfg:  StringFieldsConfig{Info: true, MaxValueLength: 3},
+			data: `app version="1.2.3" 1465839830100400200`,
+			expectedDropped: map[string]int{
+				stringDroppedTooLong: 1,
+			},
+		},
+		{
+			name: "too many values",
+			cfg:  StringFieldsConfig{Labels: []string{"version"}, MaxValuesPerField: 2},
+			data: "app version=\"1\",up=1 1465839830100400200\napp version=\"2\",up=1 1465839830100400200\napp version=\"1\",up=1 1465839830100400200\napp version=\"3\",up=1 1465839830100400200",
+			expected: []mimirpb.TimeSeries{
+				{
+					Labels: []mimirpb.LabelAdapter{
+						{Name: "__name__", Value: "app_up"},
+						{Name: "__proxy_source__", Value: "influx"},
+						{Name: "version", Value: "1"},
+					},
+					Samples: []mimirpb.Sample{{Value: 1, TimestampMs: 1465839830100}},
+				},
+				{
+					Labels: []mimirpb.LabelAdapter{
+						{Name: "__name__", Value: "app_up"},
+						{Name: "__proxy_source__", Value: "influx"},
+						{Name: "version", Value: "1"},
+					},
+					Samples: []mimirpb.Sample{{Value: 1, TimestampMs: 1465839830100}},
+				},
+				{
+					Labels: []mimirpb.LabelAdapter{
+						{Name: "__name__", Value: "app_up"},
+						{Name: "__proxy_source__", Value: "influx"},
+						{Name: "version", Value: "2"},
+					},
+					Samples: []mimirpb.Sample{{Value: 1, TimestampMs: 1465839830100}},
+				},
+				{
+					Labels: []mimirpb.LabelAdapter{
+						{Name: "__name__", Value: "app_up"},
+						{Name: "__proxy_source__", Value: "influx"},
+					},
+					Samples: []mimirpb.Sample{{Value: 1, TimestampMs: 1465839830100}},
+				},
+			},
+			expectedDropped: map[string]int{stringDroppedCardinality: 1},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			dropped := map[string]int{}
+			recorderMock := &MockRecorder{}
+			recorderMock.On("measureStringFieldsDropped", mock.Anything).Return(nil).Run(func(args mock.Arguments) {
+				dropped[args.String(0)]++
+			})
+			conv := newConverter(ConversionConfig{StringFields: tt.cfg}, log.NewNopLogger(), recorderMock)
+
+			var ts []mimirpb.TimeSeries
+			_, lineErrs, err := parseInfluxLines(io.NopCloser(strings.NewReader(tt.data)), writeParams{precision: "ns", converter: conv}, conv.newRequestLimits("fake"), 0, 0, func(batch []mimirpb.TimeSeries) error {
+				ts = append(ts, batch...)
+				return nil
+			})
+			require.NoError(t, err)
+			require.Zero(t, lineErrs.count())
+
+			sort.Slice(ts, func(i, j int) bool {
+				return ts[i].String() < ts[j].String()
+			})
+			if len(tt.expected) == 0 {
+				assert.Empty(t, ts)
+			} else {
+				assert.Equal(t, tt.expected, ts)
+			}
+			if tt.expectedDropped == nil {
+				tt.expectedDropped = map[string]int{}
+			}
+			assert.Equal(t, tt.expectedDropped, dropped)
+		})
+	}
+}
+
+func TestStringFieldsCardinality(t *testing.T) {
+	recorderMock := &MockRecorder{}
+	recorderMock.On("measureStringFieldsDropped", stringDroppedCardinality).Return(nil)
+	c := newStringFieldsConverter(StringFieldsConfig{MaxValuesPerField: 1, ValuesTTL: time.Minute}, recorderMock)
+	hostA := []mimirpb.LabelAdapter{{Name: "host", Value: "a"}}
+	hostB := []mimirpb.LabelAdapter{{Name: "host", Value: "b"}}
+	now := time.Now()
+
+	assert.True(t, c.allow("team-a", "app", hostA, "version", "1", now))
+	assert.True(t, c.allow("team-a", "app", hostA, "version", "1", now))
+	assert.False(t, c.allow("team-a", "app", hostA, "version", "2", now))
+	// Other series and tenants have their own values.
+	assert.True(t, c.allow("team-a", "app", hostB, "version", "2", now))
+	assert.True(t, c.allow("team-b", "app", hostA, "version", "2", now))
+	// Values not seen for the TTL are forgotten.
+	assert.False(t, c.allow("team-a", "app", hostA, "version", "2", now.Add(59*time.Second)))
+	assert.True(t, c.allow("team-a", "app", hostA, "version", "2", now.Add(time.Minute)))
+	assert.False(t, c.allow("team-a", "app", hostA, "version", "1", now.Add(time.Minute)))
+	recorderMock.AssertNumberOfCalls(t, "measureStringFieldsDropped", 3)
+}
+
+func TestStringFieldsTrackedPerTenant(t *testing.T) {
+	recorderMock := &MockRecorder{}
+	recorderMock.On("measureStringFieldsDropped", stringDroppedCardinality).Return(nil)
+	c := newStringFieldsConverter(StringFieldsConfig{MaxValuesPerField: 10, ValuesTTL: time.Minute}, recorderMock)
+	c.maxTracked = 2
+	host := func(name string) []mimirpb.LabelAdapter {
+		return []mimirpb.LabelAdapter{{Name: "host", Value: name}}
+	}
+	now := time.Now()
+
+	assert.True(t, c.allow("team-a", "app", host("a"), "version", "1", now))
+	assert.True(t, c.allow("team-a", "app", host("b"), "version", "1", now.Add(30*time.Second)))
+	assert.False(t, c.allow("team-a", "app", host("c"), "version", "1", now.Add(30*time.Second)))
+	// A tenant filling its series doesn't take the room of the others.
+	assert.True(t, c.allow("team-b", "app", host("c"), "version", "1", now))
+	// The least recently seen series is forgotten once expired, but not the
+	// series seen since.
+	assert.True(t, c.allow("team-a", "app", host("c"), "version", "1", now.Add(time.Minute)))
+	assert.False(t, c.allow("team-a", "app", host("d"), "version", "1", now.Add(time.Minute)))
+	assert.Len(t, c.tenants["team-a"].fields, 2)
+	recorderMock.AssertNumberOfCalls(t, "measureStringFieldsDropped", 2)
+}
+
+func TestEnumMappings(t *testing.T) {
+	var e EnumMappings
+	require.NoError(t, e.Set("state:up=1,down=0"))
+	require.NoError(t, e.Set("status:ok=2.5"))
+	assert.Equal(t, EnumMappings{"state": {"up": 1, "down": 0}, "status": {"ok": 2.5}}, e)
+	assert.Equal(t, "state:down=0,up=1 status:ok=2.5", e.String())
+
+	assert.Error(t, e.Set("state"))
+	assert.Error(t, e.Set("state:up"))
+	assert.Error(t, e.Set("state:up=yes"))
+}
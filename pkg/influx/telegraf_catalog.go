@@ -0,0 +1,87 @@
+package influx
+
+// telegrafCatalog are the metadata rules of the fields of the common Telegraf
+// input plugins, applied after the configured rules when the catalog is
+// enabled. Fields that aren't listed, such as the ones added by newer plugin
+// versions, get no metadata.
+var telegrafCatalog = []MetadataRule{
+	// inputs.cpu
+	{Measurement: "cpu", Field: "usage_.*", Type: "gauge", Unit: "percent", Help: "Percentage of CPU time spent in the state."},
+	{Measurement: "cpu", Field: "time_.*", Type: "counter", Unit: "seconds", Help: "CPU time spent in the state."},
+
+	// inputs.mem
+	{Measurement: "mem", Field: "used_percent|available_percent", Type: "gauge", Unit: "percent", Help: "Percentage of the memory used or available."},
+	{Measurement: "mem", Field: "huge_pages_free|huge_pages_total", Type: "gauge", Help: "Number of huge pages."},
+	{Measurement: "mem", Type: "gauge", Unit: "bytes", Help: "Memory in the state."},
+
+	// inputs.swap
+	{Measurement: "swap", Field: "used_percent", Type: "gauge", Unit: "percent", Help: "Percentage of the swap space used."},
+	{Measurement: "swap", Field: "in|out", Type: "counter", Unit: "bytes", Help: "Data swapped in or out."},
+	{Measurement: "swap", Field: "total|used|free", Type: "gauge", Unit: "bytes", Help: "Swap space in the state."},
+
+	// inputs.disk
+	{Measurement: "disk", Field: "used_percent", Type: "gauge", Unit: "percent", Help: "Percentage of the file system space used."},
+	{Measurement: "disk", Field: "inodes_.*", Type: "gauge", Help: "Number of inodes of the file system in the state."},
+	{Measurement: "disk", Field: "total|used|free", Type: "gauge", Unit: "bytes", Help: "File system space in the state."},
+
+	// inputs.diskio
+	{Measurement: "diskio", Field: "read_bytes|write_bytes", Type: "counter", Unit: "bytes", Help: "Data read from or written to the disk."},
+	{Measurement: "diskio", Field: "read_time|write_time|io_time|weighted_io_time", Type: "counter", Unit: "milliseconds", Help: "Time spent doing I/O."},
+	{Measurement: "diskio", Field: "reads|writes|merged_reads|merged_writes", Type: "counter", Help: "Number of I/O operations completed."},
+	{Measurement: "diskio", Field: "iops_in_progress", Type: "gauge", Help: "Number of I/O operations in progress."},
+
+	// inputs.net
+	{Measurement: "net", Field: "bytes_sent|bytes_recv", Type: "counter", Unit: "bytes", Help: "Data sent or received by the interface."},
+	{Measurement: "net", Field: "packets_sent|packets_recv|err_in|err_out|drop_in|drop_out", Type: "counter", Help: "Number of packets sent, received, in error or dropped by the interface."},
+	{Measurement: "net", Field: "speed", Type: "gauge", Help: "Speed of the interface in Mbit/s."},
+
+	// inputs.netstat
+	{Measurement: "netstat", Type: "gauge", Help: "Number of sockets in the state."},
+
+	// inputs.system
+	{Measurement: "system", Field: "load1|load5|load15", Type: "gauge", Help: "Load average."},
+	{Measurement: "system", Field: "n_users|n_unique_users|n_cpus", Type: "gauge", Help: "Number of users or CPUs."},
+	{Measurement: "system", Field: "uptime", Type: "gauge", Unit: "seconds", Help: "Time since the system booted."},
+
+	// inputs.kernel
+	{Measurement: "kernel", Field: "boot_time", Type: "gauge", Unit: "seconds", Help: "Time the system booted, in seconds since the epoch."},
+	{Measurement: "kernel", Field: "context_switches|interrupts|processes_forked|disk_pages_in|disk_pages_out", Type: "counter", Help: "Number of kernel events."},
+	{Measurement: "kernel", Field: "entropy_avail", Type: "gauge", Help: "Entropy available, in bits."},
+
+	// inputs.processes
+	{Measurement: "processes", Type: "gauge", Help: "Number of processes in the state."},
+
+	// inputs.procstat
+	{Measurement: "procstat", Field: "cpu_time_.*", Type: "counter", Unit: "seconds", Help: "CPU time spent by the process in the state."},
+	{Measurement: "procstat", Field: "cpu_usage|memory_usage", Type: "gauge", Unit: "percent", Help: "Percentage of the CPU or memory used by the process."},
+	{Measurement: "procstat", Field: "memory_.*", Type: "gauge", Unit: "bytes", Help: "Memory of the process in the state."},
+	{Measurement: "procstat", Field: "read_bytes|write_bytes", Type: "counter", Unit: "bytes", Help: "Data read or written by the process."},
+	{Measurement: "procstat", Field: "read_count|write_count|involuntary_context_switches|voluntary_context_switches", Type: "counter", Help: "Number of I/O operations or context switches of the process."},
+	{Measurement: "procstat", Field: "num_threads|num_fds", Type: "gauge", Help: "Number of threads or file descriptors of the process."},
+	{Measurement: "procstat_lookup", Field: "pid_count|running", Type: "gauge", Help: "Number of processes found."},
+
+	// inputs.nginx
+	{Measurement: "nginx", Field: "accepts|handled|requests", Type: "counter", Help: "Number of connections or requests."},
+	{Measurement: "nginx", Field: "active|reading|writing|waiting", Type: "gauge", Help: "Number of connections in the state."},
+
+	// inputs.docker
+	{Measurement: "docker_container_cpu", Field: "usage_percent", Type: "gauge", Unit: "percent", Help: "Percentage of CPU used by the container."},
+	{Measurement: "docker_container_cpu", Field: "usage_total|usage_in_kernelmode|usage_in_usermode|usage_system|throttling_throttled_time", Type: "counter", Unit: "nanoseconds", Help: "CPU time used or throttled."},
+	{Measurement: "docker_container_cpu", Field: "throttling_periods|throttling_throttled_periods", Type: "counter", Help: "Number of CPU throttling periods."},
+	{Measurement: "docker_container_mem", Field: "usage_percent", Type: "gauge", Unit: "percent", Help: "Percentage of the memory limit used by the container."},
+	{Measurement: "docker_container_mem", Field: "usage|max_usage|limit", Type: "gauge", Unit: "bytes", Help: "Memory used by the container, or its limit."},
+	{Measurement: "docker_container_net", Field: "rx_bytes|tx_bytes", Type: "counter", Unit: "bytes", Help: "Data received or sent by the container."},
+	{Measurement: "docker_container_net", Field: "rx_packets|tx_packets|rx_errors|tx_errors|rx_dropped|tx_dropped", Type: "counter", Help: "Number of packets received, sent, in error or dropped by the container."},
+
+	// inputs.redis
+	{Measurement: "redis", Field: "used_memory|used_memory_rss|used_memory_peak|maxmemory", Type: "gauge", Unit: "bytes", Help: "Memory used by Redis, or its limit."},
+	{Measurement: "redis", Field: "total_connections_received|total_commands_processed|rejected_connections|keyspace_hits|keyspace_misses|evicted_keys|expired_keys", Type: "counter", Help: "Number of Redis events."},
+	{Measurement: "redis", Field: "connected_clients|blocked_clients|connected_slaves|instantaneous_ops_per_sec", Type: "gauge", Help: "Number of clients, replicas or operations per second."},
+	{Measurement: "redis", Field: "uptime", Type: "gauge", Unit: "seconds", Help: "Time since Redis started."},
+
+	// inputs.postgresql
+	{Measurement: "postgresql", Field: "temp_bytes", Type: "counter", Unit: "bytes", Help: "Data written to temporary files."},
+	{Measurement: "postgresql", Field: "blk_read_time|blk_write_time", Type: "counter", Unit: "milliseconds", Help: "Time spent reading or writing blocks."},
+	{Measurement: "postgresql", Field: "xact_commit|xact_rollback|blks_read|blks_hit|tup_returned|tup_fetched|tup_inserted|tup_updated|tup_deleted|conflicts|temp_files|deadlocks", Type: "counter", Help: "Number of database events."},
+	{Measurement: "postgresql", Field: "numbackends", Type: "gauge", Help: "Number of connected backends."},
+}
@@ -0,0 +1,52 @@
+package influx
+
+import (
+	"testing"
+
+	"github.com/grafana/mimir/pkg/mimirpb"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestTelegrafCatalogValid(t *testing.T) {
+	require.NoError(t, MetadataConfig{Rules: telegrafCatalog}.Validate())
+}
+
+func TestTelegrafCatalog(t *testing.T) {
+	tracker := newMetadataTracker(MetadataConfig{
+		Rules: []MetadataRule{
+			{Measurement: "nginx", Field: "requests", Type: "gauge"},
+		},
+		TelegrafCatalog: true,
+		EnforceSuffixes: true,
+	})
+
+	tests := []struct {
+		measurement, field, expected string
+		metricType                   mimirpb.MetricMetadata_MetricType
+	}{
+		{measurement: "cpu", field: "usage_idle", expected: "cpu_usage_idle_percent", metricType: mimirpb.GAUGE},
+		{measurement: "cpu", field: "time_user", expected: "cpu_time_user_seconds_total", metricType: mimirpb.COUNTER},
+		{measurement: "mem", field: "used_percent", expected: "mem_used_percent", metricType: mimirpb.GAUGE},
+		{measurement: "mem", field: "available", expected: "mem_available_bytes", metricType: mimirpb.GAUGE},
+		{measurement: "net", field: "bytes_recv", expected: "net_bytes_recv_bytes_total", metricType: mimirpb.COUNTER},
+		{measurement: "diskio", field: "io_time", expected: "diskio_io_time_milliseconds_total", metricType: mimirpb.COUNTER},
+		{measurement: "procstat", field: "memory_usage", expected: "procstat_memory_usage_percent", metricType: mimirpb.GAUGE},
+		{measurement: "procstat", field: "memory_rss", expected: "procstat_memory_rss_bytes", metricType: mimirpb.GAUGE},
+		{measurement: "nginx", field: "accepts", expected: "nginx_accepts_total", metricType: mimirpb.COUNTER},
+		// Overridden by the configured rule.
+		{measurement: "nginx", field: "requests", expected: "nginx_requests", metricType: mimirpb.GAUGE},
+	}
+	for _, tt := range tests {
+		name := tracker.name(tt.measurement, tt.field, tt.measurement+"_"+tt.field)
+		assert.Equal(t, tt.expected, name, tt.field)
+		assert.Equal(t, tt.metricType, tracker.families[name].Type, tt.field)
+	}
+
+	// Unknown fields get no metadata.
+	assert.Equal(t, "nginx_foo", tracker.name("nginx", "foo", "nginx_foo"))
+	assert.NotContains(t, tracker.families, "nginx_foo")
+
+	disabled := newMetadataTracker(MetadataConfig{EnforceSuffixes: true})
+	assert.Equal(t, "cpu_usage_idle", disabled.name("cpu", "usage_idle", "cpu_usage_idle"))
+}
@@ -0,0 +1,237 @@
+package influx
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"net"
+	"time"
+
+	"github.com/go-kit/log"
+	"github.com/go-kit/log/level"
+	"github.com/grafana/dskit/backoff"
+	"github.com/grafana/dskit/services"
+	"github.com/grafana/mimir-graphite/v2/pkg/remotewrite"
+	"github.com/grafana/mimir/pkg/mimirpb"
+)
+
+const (
+	udpListenerName = "udp"
+	// maxUDPPayload is the largest payload of a UDP datagram.
+	maxUDPPayload = 64 * 1024
+)
+
+// readBackoff spaces out the retries of the listeners after failed reads or
+// accepts, so that a persistent socket error doesn't spin.
+var readBackoff = backoff.Config{MinBackoff: 10 * time.Millisecond, MaxBackoff: time.Second}
+
+// UDPConfig configures the optional line protocol UDP listener, modelled
+// after the UDP service of InfluxDB 1.x.
+type UDPConfig struct {
+	// ListenAddress is the host:port the listener binds to. Empty disables the
+	// listener.
+	ListenAddress string
+	// Tenant is the tenant (X-Scope-OrgID) of the points received.
+	Tenant string
+	// Precision of the timestamps in the datagrams.
+	Precision string
+	// BatchSize is the number of series written per remote write request.
+	BatchSize int
+	// BatchTimeout is the longest time series wait before being written.
+	BatchTimeout time.Duration
+	// BatchPending is the number of datagrams queued for parsing. Datagrams
+	// received while the queue is full are dropped.
+	BatchPending int
+	// ReadBufferBytes sets the socket receive buffer size. 0 keeps the
+	// operating system default.
+	ReadBufferBytes int
+}
+
+func (c *UDPConfig) RegisterFlags(flags *flag.FlagSet) {
+	flags.StringVar(&c.ListenAddress, "udp.listen-address", "", "host:port of the UDP line protocol listener; empty to disable it")
+	flags.StringVar(&c.Tenant, "udp.tenant", "fake", "tenant of the points received by the UDP listener")
+	flags.StringVar(&c.Precision, "udp.precision", "ns", "precision of the timestamps received by the UDP listener")
+	flags.IntVar(&c.BatchSize, "udp.batch-size", 5000, "number of series written per request by the UDP listener")
+	flags.DurationVar(&c.BatchTimeout, "udp.batch-timeout", time.Second, "how long the UDP listener waits before writing an incomplete batch")
+	flags.IntVar(&c.BatchPending, "udp.batch-pending", 10, "number of datagrams the UDP listener queues before dropping them")
+	flags.IntVar(&c.ReadBufferBytes, "udp.read-buffer-bytes", 0, "socket receive buffer size of the UDP listener; 0 for the OS default")
+}
+
+// Validate checks the configuration is usable.
+func (c UDPConfig) Validate() error {
+	if c.ListenAddress == "" {
+		return nil
+	}
+	if c.Tenant == "" {
+		return errors.New("tenant is required")
+	}
+	if !validPrecision(c.Precision) {
+		return fmt.Errorf("invalid precision %q", c.Precision)
+	}
+	if c.BatchSize <= 0 || c.BatchTimeout <= 0 || c.BatchPending <= 0 {
+		return errors.New("batch size, timeout and pending must be positive")
+	}
+	return nil
+}
+
+// packetParser converts a datagram received by a UDP listener into series with
+// the converter of the listener, checking it against the per-request limits of
+// the datagram. It returns the series it could convert even when it fails,
+// unless the datagram exceeds a limit rejecting it.
+type packetParser func(conv *converter, limits *requestLimits, packet []byte) ([]mimirpb.TimeSeries, error)
+
+// influxPacketParser parses datagrams holding one or more complete lines of
+// line protocol with timestamps of the given precision.
+func influxPacketParser(precision string) packetParser {
+	params := writeParams{endpoint: udpListenerName, precision: precision}
+	return func(conv *converter, limits *requestLimits, packet []byte) ([]mimirpb.TimeSeries, error) {
+		params := params
+		params.converter = conv
+		var ts []mimirpb.TimeSeries
+		_, lineErrs, err := parseInfluxLines(io.NopCloser(bytes.NewReader(packet)), params, limits, 0, 0, func(batch []mimirpb.TimeSeries) error {
+			ts = append(ts, batch...)
+			return nil
+		})
+		if err != nil {
+			return nil, err
+		}
+		if n := lineErrs.count(); n > 0 {
+			return ts, fmt.Errorf("%d invalid lines: %w", n, lineErrs.errs[0].err)
+		}
+		return ts, nil
+	}
+}
+
+// udpListener is a dskit service receiving datagrams over UDP.
+type udpListener struct {
+	services.Service
+
+	name      string
+	cfg       UDPConfig
+	parse     packetParser
+	converter *converter
+	logger    log.Logger
+	recorder  Recorder
+	batcher   *seriesBatcher
+
+	conn    *net.UDPConn
+	packets chan []byte
+}
+
+// newUDPListener creates a listener parsing the datagrams it receives with
+// parse, converting them with conv.
+func newUDPListener(name string, cfg UDPConfig, parse packetParser, conv *converter, logger log.Logger, client remotewrite.Client, recorder Recorder) *udpListener {
+	logger = log.With(logger, "listener", name)
+	l := &udpListener{
+		name:      name,
+		cfg:       cfg,
+		parse:     parse,
+		converter: conv,
+		logger:    logger,
+		recorder:  recorder,
+		batcher:   newSeriesBatcher(cfg.Tenant, cfg.BatchSize, conv, client, recorder, logger),
+		packets:   make(chan []byte, cfg.BatchPending),
+	}
+	l.Service = services.NewBasicService(l.start, l.run, l.stop).WithName(name + "-listener")
+	return l
+}
+
+// Addr returns the address the listener is bound to.
+func (l *udpListener) Addr() net.Addr {
+	return l.conn.LocalAddr()
+}
+
+func (l *udpListener) start(_ context.Context) error {
+	addr, err := net.ResolveUDPAddr("udp", l.cfg.ListenAddress)
+	if err != nil {
+		return fmt.Errorf("failed to resolve UDP address: %w", err)
+	}
+	l.conn, err = net.ListenUDP("udp", addr)
+	if err != nil {
+		return fmt.Errorf("failed to listen on UDP: %w", err)
+	}
+	if l.cfg.ReadBufferBytes > 0 {
+		if err := l.conn.SetReadBuffer(l.cfg.ReadBufferBytes); err != nil {
+			_ = l.conn.Close()
+			return fmt.Errorf("failed to set UDP read buffer: %w", err)
+		}
+	}
+	_ = level.Info(l.logger).Log("msg", "Starting UDP listener", "addr", l.conn.LocalAddr())
+
+	go l.read()
+	return nil
+}
+
+// read receives datagrams and queues them for parsing until the connection is
+// closed.
+func (l *udpListener) read() {
+	buf := make([]byte, maxUDPPayload)
+	retries := backoff.New(context.Background(), readBackoff)
+	for {
+		n, _, err := l.conn.ReadFromUDP(buf)
+		if err != nil {
+			if errors.Is(err, net.ErrClosed) {
+				return
+			}
+			// Only the first error of a series of failed reads is logged.
+			l.recorder.measureReadErrors(l.name)
+			if retries.NumRetries() == 0 {
+				_ = level.Warn(l.logger).Log("msg", "failed to read datagram", "err", err)
+			}
+			retries.Wait()
+			continue
+		}
+		retries.Reset()
+		l.recorder.measureMessagesReceived(l.name)
+
+		select {
+		case l.packets <- bytes.Clone(buf[:n]):
+		default:
+			l.recorder.measureMessagesDropped(l.name, "queue_full")
+		}
+	}
+}
+
+func (l *udpListener) run(ctx context.Context) error {
+	ticker := time.NewTicker(l.cfg.BatchTimeout)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			// Parse what was already received before stopping.
+			for {
+				select {
+				case packet := <-l.packets:
+					l.process(packet)
+				default:
+					l.batcher.flush()
+					return nil
+				}
+			}
+		case packet := <-l.packets:
+			l.process(packet)
+		case <-ticker.C:
+			l.batcher.flush()
+		}
+	}
+}
+
+func (l *udpListener) process(packet []byte) {
+	beforeConversion := time.Now()
+	ts, err := l.parse(l.converter, l.converter.newRequestLimits(l.cfg.Tenant), packet)
+	if err != nil {
+		_ = level.Debug(l.logger).Log("msg", "dropped invalid data", "series", len(ts), "err", err)
+		l.recorder.measureMessagesDropped(l.name, lineErrorReason(err))
+	}
+	l.recorder.measureMetricsParsed(len(ts))
+	l.recorder.measureConversionDuration(time.Since(beforeConversion))
+	l.batcher.add(ts)
+}
+
+func (l *udpListener) stop(_ error) error {
+	return l.conn.Close()
+}
@@ -0,0 +1,96 @@
+package influx
+
+import (
+	"context"
+	"net"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/go-kit/log"
+	"github.com/grafana/dskit/services"
+	"github.com/grafana/dskit/user"
+	"github.com/grafana/mimir-graphite/v2/pkg/remotewrite/remotewritemock"
+	"github.com/grafana/mimir/pkg/mimirpb"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/mock"
+	"github.com/stretchr/testify/require"
+)
+
+func TestUDPListener(t *testing.T) {
+	var mtx sync.Mutex
+	var written []mimirpb.PreallocTimeseries
+
+	remoteWriteMock := &remotewritemock.Client{}
+	remoteWriteMock.On("Write", mock.Anything, mock.Anything).Return(nil).Run(func(args mock.Arguments) {
+		orgID, err := user.ExtractOrgID(args.Get(0).(context.Context))
+		require.NoError(t, err)
+		require.Equal(t, "udp-tenant", orgID)
+
+		mtx.Lock()
+		defer mtx.Unlock()
+		written = append(written, args.Get(1).(*mimirpb.WriteRequest).Timeseries...)
+	})
+	recorderMock := &MockRecorder{}
+	recorderMock.On("measureMessagesReceived", "udp").Return(nil)
+	processed := make(chan struct{})
+	recorderMock.On("measureMessagesDropped", "udp", "parse_error").Return(nil).Once().Run(func(mock.Arguments) {
+		close(processed)
+	})
+	recorderMock.On("measureMetricsParsed", mock.Anything).Return(nil)
+	recorderMock.On("measureMetricsWritten", mock.Anything).Return(nil)
+	recorderMock.On("measureConversionDuration", mock.Anything).Return(nil)
+
+	cfg := UDPConfig{
+		ListenAddress: "127.0.0.1:0",
+		Tenant:        "udp-tenant",
+		Precision:     "s",
+		BatchSize:     2,
+		BatchTimeout:  time.Hour,
+		BatchPending:  10,
+	}
+	require.NoError(t, cfg.Validate())
+	l := newUDPListener(udpListenerName, cfg, influxPacketParser(cfg.Precision), newConverter(ConversionConfig{}, log.NewNopLogger(), recorderMock), log.NewNopLogger(), remoteWriteMock, recorderMock)
+	require.NoError(t, services.StartAndAwaitRunning(context.Background(), l))
+
+	conn, err := net.Dial("udp", l.Addr().String())
+	require.NoError(t, err)
+	defer conn.Close()
+
+	// The first two series fill a batch, the third one is written on stop.
+	_, err = conn.Write([]byte("m,t=a f=1 1465839830\nm,t=b f=2 1465839830"))
+	require.NoError(t, err)
+	_, err = conn.Write([]byte("m,t=c f=3 1465839830\nm,t=d f= 1465839830"))
+	require.NoError(t, err)
+
+	require.Eventually(t, func() bool {
+		mtx.Lock()
+		defer mtx.Unlock()
+		return len(written) == 2
+	}, 5*time.Second, 10*time.Millisecond)
+
+	select {
+	case <-processed:
+	case <-time.After(5 * time.Second):
+		t.Fatal("second datagram not processed")
+	}
+	require.NoError(t, services.StopAndAwaitTerminated(context.Background(), l))
+
+	mtx.Lock()
+	defer mtx.Unlock()
+	require.Len(t, written, 3)
+	assert.Equal(t, []mimirpb.LabelAdapter{
+		{Name: "__name__", Value: "m_f"},
+		{Name: "__proxy_source__", Value: "influx"},
+		{Name: "t", Value: "c"},
+	}, written[2].Labels)
+	assert.Equal(t, []mimirpb.Sample{{Value: 3, TimestampMs: 1465839830000}}, written[2].Samples)
+	recorderMock.AssertExpectations(t)
+}
+
+func TestUDPConfigValidate(t *testing.T) {
+	assert.NoError(t, UDPConfig{}.Validate())
+	assert.Error(t, UDPConfig{ListenAddress: ":8089", Precision: "ns", BatchSize: 1, BatchTimeout: time.Second, BatchPending: 1}.Validate())
+	assert.Error(t, UDPConfig{ListenAddress: ":8089", Tenant: "t", Precision: "ss", BatchSize: 1, BatchTimeout: time.Second, BatchPending: 1}.Validate())
+	assert.Error(t, UDPConfig{ListenAddress: ":8089", Tenant: "t", Precision: "ns", BatchTimeout: time.Second, BatchPending: 1}.Validate())
+}
@@ -0,0 +1,76 @@
+package influx
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"net/http"
+
+	"github.com/grafana/mimir-graphite/v2/pkg/errorx"
+)
+
+// V1Config configures the InfluxDB 1.x compatible /write endpoint.
+type V1Config struct {
+	// DB configures what to do with the db (database) query parameter.
+	DB ParamMapping
+	// RP configures what to do with the rp (retention policy) query parameter.
+	RP ParamMapping
+}
+
+func (c *V1Config) RegisterFlags(flags *flag.FlagSet) {
+	c.DB.RegisterFlagsWithPrefix("v1.db", "db", flags)
+	c.RP.RegisterFlagsWithPrefix("v1.rp", "rp", flags)
+}
+
+// Validate checks the configuration is usable.
+func (c V1Config) Validate() error {
+	return validateMappings(map[string]ParamMapping{"db": c.DB, "rp": c.RP})
+}
+
+// handleV1Write is a http.Handler for the InfluxDB 1.x /write endpoint. The
+// u and p credential parameters are accepted but not checked, the proxy relies
+// on X-Scope-OrgID like on every other endpoint.
+func (a *API) handleV1Write(w http.ResponseWriter, r *http.Request) {
+	a.handleWrite(w, r, a.parseV1WriteParams, a.handleError)
+}
+
+// parseV1WriteParams validates the query parameters the way InfluxDB 1.x does
+// and applies the configured db and rp mappings.
+func (a *API) parseV1WriteParams(r *http.Request) (context.Context, writeParams, error) {
+	qp := r.URL.Query()
+	ctx := r.Context()
+
+	db := qp.Get("db")
+	if db == "" {
+		return nil, writeParams{}, errorx.BadRequest{Msg: "database is required"}
+	}
+
+	precision := qp.Get("precision")
+	switch precision {
+	case "", "n", "ns":
+		precision = "ns"
+	case "u":
+		precision = "us"
+	case "ms", "s", "m", "h":
+	default:
+		return nil, writeParams{}, errorx.BadRequest{Msg: fmt.Sprintf("invalid precision %q (use n, u, ms, s, m or h)", precision)}
+	}
+
+	switch qp.Get("consistency") {
+	case "", "any", "one", "quorum", "all":
+	default:
+		return nil, writeParams{}, errorx.BadRequest{Msg: "invalid consistency level"}
+	}
+
+	params := writeParams{endpoint: endpointV1, precision: precision}
+	var err error
+	ctx, params.labels, err = a.v1.DB.apply(ctx, db, params.labels)
+	if err != nil {
+		return nil, writeParams{}, err
+	}
+	ctx, params.labels, err = a.v1.RP.apply(ctx, qp.Get("rp"), params.labels)
+	if err != nil {
+		return nil, writeParams{}, err
+	}
+	return ctx, params, nil
+}
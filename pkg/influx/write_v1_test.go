@@ -0,0 +1,243 @@
+package influx
+
+import (
+	"bytes"
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/go-kit/log"
+	"github.com/grafana/dskit/user"
+	"github.com/grafana/mimir-graphite/v2/pkg/remotewrite/remotewritemock"
+	"github.com/grafana/mimir/pkg/mimirpb"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/mock"
+	"github.com/stretchr/testify/require"
+)
+
+func TestHandleV1Write(t *testing.T) {
+	tests := []struct {
+		name           string
+		url            string
+		data           string
+		config         V1Config
+		expectedCode   int
+		expectJsonBody string
+		expectedOrgID  string
+		expectedSeries *mimirpb.TimeSeries
+	}{
+		{
+			name:          "db and rp ignored",
+			url:           "/write?db=telegraf&rp=autogen&u=user&p=pass&consistency=one",
+			data:          "measurement,t1=v1 f1=2 1465839830100400200",
+			config:        V1Config{DB: ParamMapping{Mode: MappingIgnore}, RP: ParamMapping{Mode: MappingIgnore}},
+			expectedCode:  http.StatusNoContent,
+			expectedOrgID: "fake",
+			expectedSeries: &mimirpb.TimeSeries{
+				Labels: []mimirpb.LabelAdapter{
+					{Name: "__name__", Value: "measurement_f1"},
+					{Name: "__proxy_source__", Value: "influx"},
+					{Name: "t1", Value: "v1"},
+				},
+				Samples: []mimirpb.Sample{{Value: 2, TimestampMs: 1465839830100}},
+			},
+		},
+		{
+			name:          "db and rp as labels",
+			url:           "/write?db=telegraf&rp=autogen",
+			data:          "measurement,t1=v1,database=other f1=2 1465839830100400200",
+			config:        V1Config{DB: ParamMapping{Mode: MappingLabel, Label: "database"}, RP: ParamMapping{Mode: MappingLabel, Label: "rp"}},
+			expectedCode:  http.StatusNoContent,
+			expectedOrgID: "fake",
+			expectedSeries: &mimirpb.TimeSeries{
+				Labels: []mimirpb.LabelAdapter{
+					{Name: "__name__", Value: "measurement_f1"},
+					{Name: "__proxy_source__", Value: "influx"},
+					{Name: "database", Value: "telegraf"},
+					{Name: "rp", Value: "autogen"},
+					{Name: "t1", Value: "v1"},
+				},
+				Samples: []mimirpb.Sample{{Value: 2, TimestampMs: 1465839830100}},
+			},
+		},
+		{
+			name:          "db as tenant",
+			url:           "/write?db=tenant-a",
+			data:          "measurement,t1=v1 f1=2 1465839830100400200",
+			config:        V1Config{DB: ParamMapping{Mode: MappingTenant}, RP: ParamMapping{Mode: MappingLabel, Label: "rp"}},
+			expectedCode:  http.StatusNoContent,
+			expectedOrgID: "tenant-a",
+			expectedSeries: &mimirpb.TimeSeries{
+				Labels: []mimirpb.LabelAdapter{
+					{Name: "__name__", Value: "measurement_f1"},
+					{Name: "__proxy_source__", Value: "influx"},
+					{Name: "t1", Value: "v1"},
+				},
+				Samples: []mimirpb.Sample{{Value: 2, TimestampMs: 1465839830100}},
+			},
+		},
+		{
+			name:         "invalid db as tenant",
+			url:          "/write?db=tenant-a%7Ctenant-b",
+			data:         "measurement,t1=v1 f1=2 1465839830100400200",
+			config:       V1Config{DB: ParamMapping{Mode: MappingTenant}},
+			expectedCode: http.StatusBadRequest,
+			expectJsonBody: `{
+				"code": "invalid",
+				"message": "invalid tenant: tenant ID 'tenant-a|tenant-b' contains unsupported character '|'"
+			}`,
+		},
+		{
+			name:          "precision in minutes",
+			url:           "/write?db=telegraf&precision=m",
+			data:          "measurement,t1=v1 f1=2 24430663",
+			expectedCode:  http.StatusNoContent,
+			expectedOrgID: "fake",
+			expectedSeries: &mimirpb.TimeSeries{
+				Labels: []mimirpb.LabelAdapter{
+					{Name: "__name__", Value: "measurement_f1"},
+					{Name: "__proxy_source__", Value: "influx"},
+					{Name: "t1", Value: "v1"},
+				},
+				Samples: []mimirpb.Sample{{Value: 2, TimestampMs: 1465839780000}},
+			},
+		},
+		{
+			name:          "precision in microseconds",
+			url:           "/write?db=telegraf&precision=u",
+			data:          "measurement,t1=v1 f1=2 1465839830100400",
+			expectedCode:  http.StatusNoContent,
+			expectedOrgID: "fake",
+			expectedSeries: &mimirpb.TimeSeries{
+				Labels: []mimirpb.LabelAdapter{
+					{Name: "__name__", Value: "measurement_f1"},
+					{Name: "__proxy_source__", Value: "influx"},
+					{Name: "t1", Value: "v1"},
+				},
+				Samples: []mimirpb.Sample{{Value: 2, TimestampMs: 1465839830100}},
+			},
+		},
+		{
+			name:         "missing db",
+			url:          "/write",
+			data:         "measurement,t1=v1 f1=2 1465839830100400200",
+			expectedCode: http.StatusBadRequest,
+			expectJsonBody: `{
+				"code": "invalid",
+				"message": "database is required"
+			}`,
+		},
+		{
+			name:         "invalid precision",
+			url:          "/write?db=telegraf&precision=us",
+			data:         "measurement,t1=v1 f1=2 1465839830100400200",
+			expectedCode: http.StatusBadRequest,
+			expectJsonBody: `{
+				"code": "invalid",
+				"message": "invalid precision \"us\" (use n, u, ms, s, m or h)"
+			}`,
+		},
+		{
+			name:         "invalid consistency",
+			url:          "/write?db=telegraf&consistency=some",
+			data:         "measurement,t1=v1 f1=2 1465839830100400200",
+			expectedCode: http.StatusBadRequest,
+			expectJsonBody: `{
+				"code": "invalid",
+				"message": "invalid consistency level"
+			}`,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			req := httptest.NewRequest("POST", tt.url, bytes.NewReader([]byte(tt.data)))
+			req = req.WithContext(user.InjectOrgID(req.Context(), "fake"))
+			rec := httptest.NewRecorder()
+
+			remoteWriteMock := &remotewritemock.Client{}
+			if tt.expectedSeries != nil {
+				remoteWriteMock.On("Write", mock.Anything, &mimirpb.WriteRequest{
+					Timeseries: []mimirpb.PreallocTimeseries{{TimeSeries: tt.expectedSeries}},
+				}).Return(nil).Run(func(args mock.Arguments) {
+					orgID, err := user.ExtractOrgID(args.Get(0).(context.Context))
+					require.NoError(t, err)
+					require.Equal(t, tt.expectedOrgID, orgID)
+				})
+			}
+			recorderMock := &MockRecorder{}
+			recorderMock.On("measureMetricsParsed", mock.Anything).Return(nil)
+			recorderMock.On("measureMetricsWritten", mock.Anything).Return(nil)
+			recorderMock.On("measureProxyErrors", mock.Anything).Return(nil)
+			recorderMock.On("measureConversionDuration", mock.Anything).Return(nil)
+
+			conf := ProxyConfig{
+				Logger:              log.NewNopLogger(),
+				MaxRequestSizeBytes: DefaultMaxRequestSizeBytes,
+				V1Config:            tt.config,
+			}
+			api, err := NewAPI(conf, remoteWriteMock, recorderMock)
+			require.NoError(t, err)
+
+			api.handleV1Write(rec, req)
+			assert.Equal(t, tt.expectedCode, rec.Code)
+			if tt.expectJsonBody != "" {
+				assert.JSONEq(t, tt.expectJsonBody, rec.Body.String())
+			}
+			remoteWriteMock.AssertExpectations(t)
+		})
+	}
+}
+
+func TestParsePointsWithPrecision(t *testing.T) {
+	now := time.Date(2022, 7, 18, 10, 19, 10, 0, time.UTC)
+
+	points, err := parsePointsWithPrecision([]byte("m f=1 2\nm f=1"), now, "h")
+	require.NoError(t, err)
+	require.Len(t, points, 2)
+	assert.Equal(t, time.Unix(2*60*60, 0).UTC(), points[0].Time().UTC())
+	assert.Equal(t, time.Date(2022, 7, 18, 10, 0, 0, 0, time.UTC), points[1].Time().UTC())
+
+	_, err = parsePointsWithPrecision([]byte("m f=1 9223372036854775"), now, "m")
+	require.Error(t, err)
+}
+
+func TestV1ConfigValidate(t *testing.T) {
+	tests := map[string]struct {
+		config      V1Config
+		expectedErr bool
+	}{
+		"valid": {
+			config: V1Config{DB: ParamMapping{Mode: MappingTenant}, RP: ParamMapping{Mode: MappingLabel, Label: "rp"}},
+		},
+		"unknown mode": {
+			config:      V1Config{DB: ParamMapping{Mode: "bucket"}, RP: ParamMapping{Mode: MappingIgnore}},
+			expectedErr: true,
+		},
+		"invalid label name": {
+			config:      V1Config{DB: ParamMapping{Mode: MappingLabel, Label: "data.base"}, RP: ParamMapping{Mode: MappingIgnore}},
+			expectedErr: true,
+		},
+		"reserved label name": {
+			config:      V1Config{DB: ParamMapping{Mode: MappingLabel, Label: "__name__"}, RP: ParamMapping{Mode: MappingIgnore}},
+			expectedErr: true,
+		},
+		"two tenants": {
+			config:      V1Config{DB: ParamMapping{Mode: MappingTenant}, RP: ParamMapping{Mode: MappingTenant}},
+			expectedErr: true,
+		},
+	}
+
+	for name, tt := range tests {
+		t.Run(name, func(t *testing.T) {
+			err := tt.config.Validate()
+			if tt.expectedErr {
+				require.Error(t, err)
+			} else {
+				require.NoError(t, err)
+			}
+		})
+	}
+}
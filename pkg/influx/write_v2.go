@@ -0,0 +1,95 @@
+package influx
+
+import (
+	"context"
+	"encoding/hex"
+	"flag"
+	"net/http"
+
+	"github.com/grafana/mimir-graphite/v2/pkg/errorx"
+)
+
+// V2Config configures the InfluxDB 2.x compatible /api/v2/write endpoint.
+type V2Config struct {
+	// Org configures what to do with the org or orgID query parameter.
+	Org ParamMapping
+	// Bucket configures what to do with the bucket query parameter.
+	Bucket ParamMapping
+}
+
+func (c *V2Config) RegisterFlags(flags *flag.FlagSet) {
+	c.Org.RegisterFlagsWithPrefix("v2.org", "org", flags)
+	c.Bucket.RegisterFlagsWithPrefix("v2.bucket", "bucket", flags)
+}
+
+// Validate checks the configuration is usable.
+func (c V2Config) Validate() error {
+	return validateMappings(map[string]ParamMapping{"org": c.Org, "bucket": c.Bucket})
+}
+
+// handleV2Write is a http.Handler for the InfluxDB 2.x /api/v2/write endpoint.
+func (a *API) handleV2Write(w http.ResponseWriter, r *http.Request) {
+	a.handleWrite(w, r, a.parseV2WriteParams, a.handleError)
+}
+
+// parseV2WriteParams validates the query parameters the way InfluxDB 2.x does
+// and applies the configured org and bucket mappings. As in InfluxDB, one of
+// org and orgID is required, orgID takes precedence over org, and the minute
+// and hour precisions of InfluxDB 1.x are rejected.
+func (a *API) parseV2WriteParams(r *http.Request) (context.Context, writeParams, error) {
+	qp := r.URL.Query()
+	ctx := r.Context()
+
+	bucket := qp.Get("bucket")
+	if bucket == "" {
+		return nil, writeParams{}, errorx.BadRequest{Msg: "bucket is required"}
+	}
+
+	org := qp.Get("org")
+	if orgID := qp.Get("orgID"); orgID != "" {
+		if !validID(orgID) {
+			return nil, writeParams{}, errorx.BadRequest{Msg: "invalid orgID"}
+		}
+		org = orgID
+	}
+	if org == "" {
+		return nil, writeParams{}, errorx.BadRequest{Msg: "organization is required"}
+	}
+
+	precision := qp.Get("precision")
+	switch precision {
+	case "", "ns", "us", "ms", "s":
+	default:
+		return nil, writeParams{}, errorx.BadRequest{Msg: "invalid precision; valid precision units are ns, us, ms, and s"}
+	}
+
+	params := writeParams{endpoint: endpointV2, precision: precision}
+	var err error
+	ctx, params.labels, err = a.v2.Org.apply(ctx, org, params.labels)
+	if err != nil {
+		return nil, writeParams{}, err
+	}
+	ctx, params.labels, err = a.v2.Bucket.apply(ctx, bucket, params.labels)
+	if err != nil {
+		return nil, writeParams{}, err
+	}
+	return ctx, params, nil
+}
+
+// validID reports whether id is a valid InfluxDB 2.x resource ID: 16
+// hexadecimal characters, not all zero.
+func validID(id string) bool {
+	if len(id) != 16 {
+		return false
+	}
+	b, err := hex.DecodeString(id)
+	if err != nil {
+		return false
+	}
+	for _, c := range b {
+		if c != 0 {
+			return true
+		}
+	}
+	return false
+}
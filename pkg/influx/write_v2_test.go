@@ -0,0 +1,152 @@
+package influx
+
+import (
+	"bytes"
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/go-kit/log"
+	"github.com/grafana/dskit/user"
+	"github.com/grafana/mimir-graphite/v2/pkg/remotewrite/remotewritemock"
+	"github.com/grafana/mimir/pkg/mimirpb"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/mock"
+	"github.com/stretchr/testify/require"
+)
+
+func TestHandleV2Write(t *testing.T) {
+	tests := []struct {
+		name           string
+		url            string
+		config         V2Config
+		expectedCode   int
+		expectJsonBody string
+		expectedOrgID  string
+		expectedLabels []mimirpb.LabelAdapter
+	}{
+		{
+			name:          "org and bucket ignored",
+			url:           "/api/v2/write?org=my-org&bucket=my-bucket",
+			expectedCode:  http.StatusNoContent,
+			expectedOrgID: "fake",
+			expectedLabels: []mimirpb.LabelAdapter{
+				{Name: "__name__", Value: "measurement_f1"},
+				{Name: "__proxy_source__", Value: "influx"},
+				{Name: "t1", Value: "v1"},
+			},
+		},
+		{
+			name:          "org as tenant and bucket as label",
+			url:           "/api/v2/write?org=my-org&bucket=my-bucket",
+			config:        V2Config{Org: ParamMapping{Mode: MappingTenant}, Bucket: ParamMapping{Mode: MappingLabel, Label: "bucket"}},
+			expectedCode:  http.StatusNoContent,
+			expectedOrgID: "my-org",
+			expectedLabels: []mimirpb.LabelAdapter{
+				{Name: "__name__", Value: "measurement_f1"},
+				{Name: "__proxy_source__", Value: "influx"},
+				{Name: "bucket", Value: "my-bucket"},
+				{Name: "t1", Value: "v1"},
+			},
+		},
+		{
+			name:          "orgID takes precedence over org",
+			url:           "/api/v2/write?org=my-org&orgID=033a3f2c5ccaa000&bucket=my-bucket",
+			config:        V2Config{Org: ParamMapping{Mode: MappingLabel, Label: "org"}, Bucket: ParamMapping{Mode: MappingTenant}},
+			expectedCode:  http.StatusNoContent,
+			expectedOrgID: "my-bucket",
+			expectedLabels: []mimirpb.LabelAdapter{
+				{Name: "__name__", Value: "measurement_f1"},
+				{Name: "__proxy_source__", Value: "influx"},
+				{Name: "org", Value: "033a3f2c5ccaa000"},
+				{Name: "t1", Value: "v1"},
+			},
+		},
+		{
+			name:         "missing bucket",
+			url:          "/api/v2/write?org=my-org",
+			expectedCode: http.StatusBadRequest,
+			expectJsonBody: `{
+				"code": "invalid",
+				"message": "bucket is required"
+			}`,
+		},
+		{
+			name:         "missing org",
+			url:          "/api/v2/write?bucket=my-bucket",
+			expectedCode: http.StatusBadRequest,
+			expectJsonBody: `{
+				"code": "invalid",
+				"message": "organization is required"
+			}`,
+		},
+		{
+			name:         "invalid orgID",
+			url:          "/api/v2/write?orgID=my-org&bucket=my-bucket",
+			expectedCode: http.StatusBadRequest,
+			expectJsonBody: `{
+				"code": "invalid",
+				"message": "invalid orgID"
+			}`,
+		},
+		{
+			name:         "invalid precision",
+			url:          "/api/v2/write?org=my-org&bucket=my-bucket&precision=h",
+			expectedCode: http.StatusBadRequest,
+			expectJsonBody: `{
+				"code": "invalid",
+				"message": "invalid precision; valid precision units are ns, us, ms, and s"
+			}`,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			req := httptest.NewRequest("POST", tt.url, bytes.NewReader([]byte("measurement,t1=v1 f1=2 1465839830100400200")))
+			req = req.WithContext(user.InjectOrgID(req.Context(), "fake"))
+			rec := httptest.NewRecorder()
+
+			remoteWriteMock := &remotewritemock.Client{}
+			if tt.expectedLabels != nil {
+				remoteWriteMock.On("Write", mock.Anything, &mimirpb.WriteRequest{
+					Timeseries: []mimirpb.PreallocTimeseries{{TimeSeries: &mimirpb.TimeSeries{
+						Labels:  tt.expectedLabels,
+						Samples: []mimirpb.Sample{{Value: 2, TimestampMs: 1465839830100}},
+					}}},
+				}).Return(nil).Run(func(args mock.Arguments) {
+					orgID, err := user.ExtractOrgID(args.Get(0).(context.Context))
+					require.NoError(t, err)
+					require.Equal(t, tt.expectedOrgID, orgID)
+				})
+			}
+			recorderMock := &MockRecorder{}
+			recorderMock.On("measureMetricsParsed", mock.Anything).Return(nil)
+			recorderMock.On("measureMetricsWritten", mock.Anything).Return(nil)
+			recorderMock.On("measureProxyErrors", mock.Anything).Return(nil)
+			recorderMock.On("measureConversionDuration", mock.Anything).Return(nil)
+
+			conf := ProxyConfig{
+				Logger:              log.NewNopLogger(),
+				MaxRequestSizeBytes: DefaultMaxRequestSizeBytes,
+				V2Config:            tt.config,
+			}
+			api, err := NewAPI(conf, remoteWriteMock, recorderMock)
+			require.NoError(t, err)
+
+			api.handleV2Write(rec, req)
+			assert.Equal(t, tt.expectedCode, rec.Code)
+			if tt.expectJsonBody != "" {
+				assert.JSONEq(t, tt.expectJsonBody, rec.Body.String())
+			}
+			remoteWriteMock.AssertExpectations(t)
+		})
+	}
+}
+
+func TestValidID(t *testing.T) {
+	assert.True(t, validID("033a3f2c5ccaa000"))
+	assert.False(t, validID("0000000000000000"))
+	assert.False(t, validID("033a3f2c5ccaa00"))
+	assert.False(t, validID("033a3f2c5ccaa00z"))
+}
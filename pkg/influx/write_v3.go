@@ -0,0 +1,129 @@
+package influx
+
+import (
+	"context"
+	"errors"
+	"flag"
+	"fmt"
+	"net/http"
+	"strconv"
+
+	"github.com/go-kit/log"
+	"github.com/grafana/mimir-graphite/v2/pkg/errorx"
+)
+
+// V3Config configures the InfluxDB 3 compatible /api/v3/write_lp endpoint.
+type V3Config struct {
+	// DB configures what to do with the db (database) query parameter.
+	DB ParamMapping
+}
+
+func (c *V3Config) RegisterFlags(flags *flag.FlagSet) {
+	c.DB.RegisterFlagsWithPrefix("v3.db", "db", flags)
+}
+
+// Validate checks the configuration is usable.
+func (c V3Config) Validate() error {
+	return validateMappings(map[string]ParamMapping{"db": c.DB})
+}
+
+// v3LineError is the InfluxDB 3 description of a line that failed to parse.
+type v3LineError struct {
+	OriginalLine string `json:"original_line"`
+	LineNumber   int    `json:"line_number"`
+	ErrorMessage string `json:"error_message"`
+}
+
+// handleV3Write is a http.Handler for the InfluxDB 3 /api/v3/write_lp
+// endpoint. The no_sync parameter is validated but has no effect: the proxy
+// always waits for the remote write to finish before responding.
+func (a *API) handleV3Write(w http.ResponseWriter, r *http.Request) {
+	a.handleWrite(w, r, a.parseV3WriteParams, a.handleV3Error)
+}
+
+// parseV3WriteParams validates the query parameters the way InfluxDB 3 does
+// and applies the configured db mapping. Without accept_partial, the request
+// is all or nothing, as in InfluxDB 3.
+func (a *API) parseV3WriteParams(r *http.Request) (context.Context, writeParams, error) {
+	qp := r.URL.Query()
+	ctx := r.Context()
+
+	db := qp.Get("db")
+	if db == "" {
+		return nil, writeParams{}, errorx.BadRequest{Msg: "missing required query parameter: db"}
+	}
+
+	precision := qp.Get("precision")
+	switch precision {
+	case "", "auto":
+		precision = "auto"
+	case "second", "s":
+		precision = "s"
+	case "millisecond", "ms":
+		precision = "ms"
+	case "microsecond", "us":
+		precision = "us"
+	case "nanosecond", "ns":
+		precision = "ns"
+	default:
+		return nil, writeParams{}, errorx.BadRequest{Msg: fmt.Sprintf("invalid precision: %s", precision)}
+	}
+
+	params := writeParams{endpoint: endpointV3, precision: precision}
+	if v := qp.Get("accept_partial"); v != "" {
+		acceptPartial, err := strconv.ParseBool(v)
+		if err != nil {
+			return nil, writeParams{}, errorx.BadRequest{Msg: fmt.Sprintf("invalid accept_partial: %s", v)}
+		}
+		params.allOrNothing = !acceptPartial
+	}
+	if v := qp.Get("no_sync"); v != "" {
+		if _, err := strconv.ParseBool(v); err != nil {
+			return nil, writeParams{}, errorx.BadRequest{Msg: fmt.Sprintf("invalid no_sync: %s", v)}
+		}
+	}
+
+	var err error
+	ctx, params.labels, err = a.v3.DB.apply(ctx, db, params.labels)
+	if err != nil {
+		return nil, writeParams{}, err
+	}
+	return ctx, params, nil
+}
+
+// handleV3Error is handleError for the InfluxDB 3 endpoint, which has its own
+// error body shape, with the invalid lines in its data.
+func (a *API) handleV3Error(w http.ResponseWriter, r *http.Request, err error, logger log.Logger) {
+	var data interface{}
+	var linesErr invalidLinesError
+	if errors.As(err, &linesErr) {
+		if linesErr.rejected {
+			err = errorx.BadRequest{Msg: "parsing failed for write_lp endpoint", Err: linesErr.lineErrs.errs[0].err}
+			data = toV3LineError(linesErr.lineErrs.errs[0])
+		} else {
+			lines := make([]v3LineError, 0, len(linesErr.lineErrs.errs))
+			for _, le := range linesErr.lineErrs.errs {
+				lines = append(lines, *toV3LineError(le))
+			}
+			err = errorx.BadRequest{Msg: "partial write of line protocol occurred", Err: linesErr.lineErrs.errs[0].err}
+			data = lines
+		}
+	}
+
+	statusCode, _, httpErrString := a.classifyError(r, err, logger)
+	a.writeErrorResponse(w, statusCode, struct {
+		Error string      `json:"error"`
+		Data  interface{} `json:"data,omitempty"`
+	}{
+		Error: httpErrString,
+		Data:  data,
+	}, logger)
+}
+
+func toV3LineError(le lineError) *v3LineError {
+	return &v3LineError{
+		OriginalLine: le.line,
+		LineNumber:   le.lineNum,
+		ErrorMessage: le.err.Error(),
+	}
+}
@@ -0,0 +1,214 @@
+package influx
+
+import (
+	"bytes"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/go-kit/log"
+	"github.com/grafana/dskit/user"
+	"github.com/grafana/mimir-graphite/v2/pkg/remotewrite/remotewritemock"
+	"github.com/grafana/mimir/pkg/mimirpb"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/mock"
+	"github.com/stretchr/testify/require"
+)
+
+func TestHandleV3Write(t *testing.T) {
+	series := func(name string, value float64) mimirpb.PreallocTimeseries {
+		return mimirpb.PreallocTimeseries{TimeSeries: &mimirpb.TimeSeries{
+			Labels: []mimirpb.LabelAdapter{
+				{Name: "__name__", Value: name},
+				{Name: "__proxy_source__", Value: "influx"},
+				{Name: "t1", Value: "v1"},
+			},
+			Samples: []mimirpb.Sample{{Value: value, TimestampMs: 1465839830000}},
+		}}
+	}
+
+	tests := []struct {
+		name           string
+		url            string
+		data           string
+		expectedCode   int
+		expectJsonBody string
+		expectedWrite  *mimirpb.WriteRequest
+	}{
+		{
+			name:          "auto precision",
+			url:           "/api/v3/write_lp?db=mydb",
+			data:          "measurement,t1=v1 f1=2 1465839830\nmeasurement,t1=v1 f2=3 1465839830000",
+			expectedCode:  http.StatusNoContent,
+			expectedWrite: &mimirpb.WriteRequest{Timeseries: []mimirpb.PreallocTimeseries{series("measurement_f1", 2), series("measurement_f2", 3)}},
+		},
+		{
+			name:          "explicit precision",
+			url:           "/api/v3/write_lp?db=mydb&precision=millisecond&no_sync=true",
+			data:          "measurement,t1=v1 f1=2 1465839830000",
+			expectedCode:  http.StatusNoContent,
+			expectedWrite: &mimirpb.WriteRequest{Timeseries: []mimirpb.PreallocTimeseries{series("measurement_f1", 2)}},
+		},
+		{
+			name:          "partial write",
+			url:           "/api/v3/write_lp?db=mydb&precision=second",
+			data:          "measurement,t1=v1 f1=2 1465839830\n\nmeasurement,t1=v1 f2= 1465839830",
+			expectedCode:  http.StatusBadRequest,
+			expectedWrite: &mimirpb.WriteRequest{Timeseries: []mimirpb.PreallocTimeseries{series("measurement_f1", 2)}},
+			expectJsonBody: `{
+				"error": "partial write of line protocol occurred",
+				"data": [{
+					"original_line": "measurement,t1=v1 f2= 1465839830",
+					"line_number": 3,
+					"error_message": "unable to parse 'measurement,t1=v1 f2= 1465839830': missing field value"
+				}]
+			}`,
+		},
+		{
+			name:         "partial write not accepted",
+			url:          "/api/v3/write_lp?db=mydb&accept_partial=false",
+			data:         "measurement,t1=v1 f1=2 1465839830\nmeasurement,t1=v1 f2= 1465839830",
+			expectedCode: http.StatusBadRequest,
+			expectJsonBody: `{
+				"error": "parsing failed for write_lp endpoint",
+				"data": {
+					"original_line": "measurement,t1=v1 f2= 1465839830",
+					"line_number": 2,
+					"error_message": "unable to parse 'measurement,t1=v1 f2= 1465839830': missing field value"
+				}
+			}`,
+		},
+		{
+			name:           "missing db",
+			url:            "/api/v3/write_lp",
+			data:           "measurement,t1=v1 f1=2 1465839830",
+			expectedCode:   http.StatusBadRequest,
+			expectJsonBody: `{"error": "missing required query parameter: db"}`,
+		},
+		{
+			name:           "invalid precision",
+			url:            "/api/v3/write_lp?db=mydb&precision=minute",
+			data:           "measurement,t1=v1 f1=2 1465839830",
+			expectedCode:   http.StatusBadRequest,
+			expectJsonBody: `{"error": "invalid precision: minute"}`,
+		},
+		{
+			name:           "invalid accept_partial",
+			url:            "/api/v3/write_lp?db=mydb&accept_partial=maybe",
+			data:           "measurement,t1=v1 f1=2 1465839830",
+			expectedCode:   http.StatusBadRequest,
+			expectJsonBody: `{"error": "invalid accept_partial: maybe"}`,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			req := httptest.NewRequest("POST", tt.url, bytes.NewReader([]byte(tt.data)))
+			req = req.WithContext(user.InjectOrgID(req.Context(), "fake"))
+			rec := httptest.NewRecorder()
+
+			remoteWriteMock := &remotewritemock.Client{}
+			if tt.expectedWrite != nil {
+				remoteWriteMock.On("Write", mock.Anything, mock.MatchedBy(func(req *mimirpb.WriteRequest) bool {
+					return assert.ElementsMatch(t, tt.expectedWrite.Timeseries, req.Timeseries)
+				})).Return(nil)
+			}
+			recorderMock := &MockRecorder{}
+			recorderMock.On("measureMetricsParsed", mock.Anything).Return(nil)
+			recorderMock.On("measureMetricsWritten", mock.Anything).Return(nil)
+			recorderMock.On("measureProxyErrors", "errorx.BadRequest").Return(nil)
+			recorderMock.On("measureFailedLines", "parse_error", 1).Return(nil)
+			recorderMock.On("measureConversionDuration", mock.Anything).Return(nil)
+
+			conf := ProxyConfig{
+				Logger:              log.NewNopLogger(),
+				MaxRequestSizeBytes: DefaultMaxRequestSizeBytes,
+			}
+			api, err := NewAPI(conf, remoteWriteMock, recorderMock)
+			require.NoError(t, err)
+
+			api.handleV3Write(rec, req)
+			assert.Equal(t, tt.expectedCode, rec.Code)
+			if tt.expectJsonBody != "" {
+				assert.JSONEq(t, tt.expectJsonBody, rec.Body.String())
+			} else {
+				assert.Empty(t, rec.Body.String())
+			}
+			remoteWriteMock.AssertExpectations(t)
+		})
+	}
+}
+
+func TestHandleV3WriteBatches(t *testing.T) {
+	const data = "m,t=a f=1 1465839830\nm,t=b f=2 1465839830\nm,t=a-tag-value-too-long-for-the-limit f=3 1465839830\nm,t=c f=4 1465839830"
+	tests := []struct {
+		name           string
+		url            string
+		expectedWrites int
+		expectJsonBody string
+	}{
+		{
+			name:           "partial write",
+			url:            "/api/v3/write_lp?db=mydb",
+			expectedWrites: 3,
+			expectJsonBody: `{
+				"error": "partial write of line protocol occurred",
+				"data": [{"original_line": "", "line_number": 3, "error_message": "line too long: more than 32 bytes"}]
+			}`,
+		},
+		{
+			name:           "partial write not accepted",
+			url:            "/api/v3/write_lp?db=mydb&accept_partial=false",
+			expectedWrites: 0,
+			expectJsonBody: `{
+				"error": "parsing failed for write_lp endpoint",
+				"data": {"original_line": "", "line_number": 3, "error_message": "line too long: more than 32 bytes"}
+			}`,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			req := httptest.NewRequest("POST", tt.url, bytes.NewReader([]byte(data)))
+			req = req.WithContext(user.InjectOrgID(req.Context(), "fake"))
+			rec := httptest.NewRecorder()
+
+			remoteWriteMock := &remotewritemock.Client{}
+			remoteWriteMock.On("Write", mock.Anything, mock.MatchedBy(func(req *mimirpb.WriteRequest) bool {
+				return len(req.Timeseries) == 1
+			})).Return(nil)
+			recorderMock := &MockRecorder{}
+			recorderMock.On("measureMetricsParsed", tt.expectedWrites).Return(nil)
+			if tt.expectedWrites > 0 {
+				recorderMock.On("measureMetricsWritten", 1).Return(nil)
+			}
+			recorderMock.On("measureProxyErrors", mock.Anything).Return(nil)
+			recorderMock.On("measureFailedLines", "line_too_long", 1).Return(nil)
+			recorderMock.On("measureConversionDuration", mock.Anything).Return(nil)
+
+			// Every series is written on its own, and the third line is too
+			// long. Without accept_partial, nothing is written.
+			api, err := NewAPI(ProxyConfig{
+				Logger:              log.NewNopLogger(),
+				MaxRequestSizeBytes: DefaultMaxRequestSizeBytes,
+				MaxLineLengthBytes:  32,
+				WriteBatchSize:      1,
+			}, remoteWriteMock, recorderMock)
+			require.NoError(t, err)
+
+			api.handleV3Write(rec, req)
+			assert.Equal(t, http.StatusBadRequest, rec.Code)
+			assert.JSONEq(t, tt.expectJsonBody, rec.Body.String())
+			remoteWriteMock.AssertNumberOfCalls(t, "Write", tt.expectedWrites)
+			recorderMock.AssertExpectations(t)
+		})
+	}
+}
+
+func TestGuessTimestampPrecision(t *testing.T) {
+	for _, ts := range []int64{1465839830, 1465839830000, 1465839830000000, 1465839830000000000} {
+		ns, err := guessTimestampPrecision(ts)
+		require.NoError(t, err)
+		assert.Equal(t, int64(1465839830000000000), ns)
+	}
+}